@@ -0,0 +1,112 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix  >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"relay/lib"
+	"strings"
+
+	"github.com/bfix/gospel/bitcoin/wallet"
+)
+
+// CosignerInfo is the public key information of a coin account that is
+// shared with other cosigners of a multisig wallet.
+type CosignerInfo struct {
+	Coin        string `json:"coin"`        // coin symbol
+	Mode        string `json:"mode"`        // address mode
+	Path        string `json:"path"`        // derivation path of account key
+	Fingerprint string `json:"fingerprint"` // master key fingerprint (hex)
+	Xpub        string `json:"xpub"`        // account key (SLIP-0132 version)
+	Descriptor  string `json:"descriptor"`  // key with origin (output descriptor)
+}
+
+// get cosigner information for a coin account. The key in the descriptor
+// uses the P2PKH version of the coin (like "xpub" for Bitcoin).
+func cosignerInfo(coin *lib.CoinConfig, netw int) (*CosignerInfo, error) {
+	if len(coin.Pk) == 0 || len(coin.Fingerprint) == 0 {
+		return nil, fmt.Errorf("no account key or fingerprint for '%s'", coin.Symb)
+	}
+	key, err := wallet.ParseExtended(coin.Pk)
+	if err != nil {
+		return nil, err
+	}
+	coinID, _ := wallet.GetCoinInfo(coin.Symb)
+	if v := wallet.GetXDVersion(coinID, wallet.AddrP2PKH, netw, true); v != 0 {
+		key.Version = v
+	}
+	mode := coin.Mode
+	if len(mode) == 0 {
+		mode = "P2PKH"
+	}
+	origin := coin.Fingerprint + strings.TrimPrefix(coin.Path, "m")
+	return &CosignerInfo{
+		Coin:        coin.Symb,
+		Mode:        mode,
+		Path:        coin.Path,
+		Fingerprint: coin.Fingerprint,
+		Xpub:        coin.Pk,
+		Descriptor:  "[" + origin + "]" + key.String(),
+	}, nil
+}
+
+// write cosigner information of all coins to file
+func writeCosigners(fname string, cfg *lib.Config, netw int) error {
+	list := make([]*CosignerInfo, 0)
+	for _, coin := range cfg.Coins {
+		ci, err := cosignerInfo(coin, netw)
+		if err != nil {
+			fmt.Println("<<< WARNING: " + err.Error())
+			continue
+		}
+		fmt.Printf("<<<    %s: %s\n", ci.Coin, ci.Descriptor)
+		list = append(list, ci)
+	}
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, data, 0644)
+}
+
+// get master key fingerprint from an extended key at depth 1 (the
+// fingerprint of its parent).
+func masterFingerprint(xpub string) (string, error) {
+	key, err := wallet.ParseExtended(xpub)
+	if err != nil {
+		return "", err
+	}
+	if key.Depth != 1 {
+		return "", fmt.Errorf("key at depth %d (expected 1)", key.Depth)
+	}
+	return fmt.Sprintf("%08x", key.ParentFP), nil
+}
+
+// purpose level of a derivation path (like "m/49'")
+func purposePath(path string) string {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 {
+		return path
+	}
+	return parts[0] + "/" + parts[1]
+}
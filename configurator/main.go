@@ -29,6 +29,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"os/exec"
 	"relay/lib"
 
 	trezor "github.com/bfix/bitbank-trezor"
@@ -55,13 +56,25 @@ func main() {
 		outConf string
 		export  bool
 		mode    string
+		hidden  bool
+		redact  bool
+		cosign  bool
 	)
 	flag.BoolVar(&export, "export", false, "Export embedded files")
-	flag.StringVar(&network, "n", "main", "Network [main|test|reg]")
+	flag.StringVar(&network, "n", "main", "Network [main|test|signet|testnet4|reg]")
 	flag.StringVar(&inConf, "i", "", "Configuration template file (default: embedded config)")
 	flag.StringVar(&outConf, "o", "config.json", "Configuration output file (default: config.json)")
 	flag.StringVar(&mode, "m", "trezor", "Configuration mode (trezor, seed)")
+	flag.BoolVar(&hidden, "passphrase", false, "Use passphrase-protected (hidden) Trezor wallet")
+	flag.BoolVar(&redact, "redact", false, "Write input configuration with secrets redacted (for bug reports)")
+	flag.BoolVar(&cosign, "cosigner", false, "Write cosigner information (keys for multisig wallets) of input configuration")
 	flag.Parse()
+	outSet := false
+	flag.Visit(func(f *flag.Flag) { outSet = outSet || f.Name == "o" })
+	if lib.GetNetwork(network) < 0 {
+		fmt.Printf("<<< ERROR: unknown network '%s'\n", network)
+		return
+	}
 
 	// special function "export embedded files"
 	if export {
@@ -103,10 +116,41 @@ func main() {
 			cfg, err = lib.ReadConfig(f)
 		}
 	}
+	// special function "redacted copy of configuration" (no validation:
+	// the configuration might be broken); never overwrites the default
+	// configuration file
+	if redact && err == nil {
+		if !outSet {
+			outConf = "config-redacted.json"
+		}
+		if err = writeRedacted(outConf, cfg); err != nil {
+			fmt.Println("<<< ERROR: " + err.Error())
+			return
+		}
+		fmt.Println("<<< DONE.")
+		return
+	}
+	// special function "cosigner information" (account keys with origin)
+	if cosign && err == nil {
+		if !outSet {
+			outConf = "cosigners.json"
+		}
+		if err = writeCosigners(outConf, cfg, lib.GetNetwork(network)); err != nil {
+			fmt.Println("<<< ERROR: " + err.Error())
+			return
+		}
+		fmt.Println("<<< DONE.")
+		return
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
 	if err != nil {
 		fmt.Println("<<< ERROR: " + err.Error())
 		return
 	}
+	// addresses are generated for the selected network
+	cfg.Network = network
 
 	// generate data based on configuration mode
 	if mode == "seed" {
@@ -156,6 +200,8 @@ func main() {
 		}
 		pk := hd.MasterPublic()
 		fmt.Printf("<<< Master Pub: %s\n", pk)
+		fp := fmt.Sprintf("%08x", pk.Fingerprint())
+		fmt.Printf("<<< Fingerprint: %s\n", fp)
 		sk := hd.MasterPrivate()
 		fmt.Printf("<<< Master Prv: %s\n", sk)
 
@@ -172,6 +218,8 @@ func main() {
 			}
 			bpk.Data.Version = coin.GetXDVersion()
 			coin.Pk = bpk.String()
+			coin.Fingerprint = fp
+			printKey(coin)
 
 			// get coin handler
 			hdlr, err := lib.NewHandler(coin, netw)
@@ -196,8 +244,16 @@ func main() {
 	} else if mode == "trezor" {
 		// Trezor-based configuration
 		// ==========================
-		ce := new(trezor.ConsoleEntry)
-		trezor, err := trezor.OpenTrezor(ce)
+		pe := new(PassphraseEntry)
+		if hidden {
+			// ask for passphrase of hidden wallet
+			if pe.passphrase, err = askPassphrase(); err != nil {
+				fmt.Println("<<< ERROR: " + err.Error())
+				return
+			}
+			pe.preset = true
+		}
+		trezor, err := trezor.OpenTrezor(pe)
 		if err != nil {
 			fmt.Println("<<< ERROR: " + err.Error())
 			return
@@ -214,6 +270,8 @@ func main() {
 		fmt.Printf("<<<     Firmare: %d.%d.%d\n", fw[0], fw[1], fw[2])
 		fmt.Printf("<<<       Label: '%s'\n", trezor.Label())
 
+		netw := lib.GetNetwork(network)
+		fp := ""
 		for _, coin := range cfg.Coins {
 			fmt.Printf("<<<    Processing '%s'...\n", coin.Symb)
 
@@ -222,11 +280,43 @@ func main() {
 				fmt.Println("<<< ERROR: " + err.Error())
 				continue
 			}
+			printKey(coin)
+			// get master fingerprint (parent of the purpose-level key)
+			if len(fp) == 0 {
+				var key string
+				if key, err = trezor.GetXpub(purposePath(coin.Path), coin.Symb, coin.Mode); err == nil {
+					fp, err = masterFingerprint(key)
+				}
+				if err != nil {
+					fmt.Println("<<< WARNING: no master fingerprint: " + err.Error())
+				}
+			}
+			coin.Fingerprint = fp
 			// get first address
 			if coin.Addr, err = trezor.GetAddress(coin.Path, coin.Symb, coin.Mode); err != nil {
 				fmt.Println("<<< ERROR: " + err.Error())
 				continue
 			}
+			// check first address derived from public master
+			hdlr, err := lib.NewHandler(coin, netw)
+			if err != nil {
+				fmt.Println("<<< ERROR: " + err.Error())
+				return
+			}
+			addr, err := hdlr.GetAddress(0)
+			if err != nil {
+				fmt.Println("<<< ERROR: " + err.Error())
+				return
+			}
+			if !lib.SameAddress(addr, coin.Addr) {
+				fmt.Printf("<<< ERROR: address mismatch for '%s' (device %s, derived %s)\n", coin.Symb, coin.Addr, addr)
+				return
+			}
+		}
+		// check use of hidden wallet
+		if hidden && !pe.used {
+			fmt.Println("<<< WARNING: Trezor did not ask for the passphrase; it is either")
+			fmt.Println("<<<          entered on the device or passphrase protection is disabled.")
 		}
 	} else {
 		fmt.Printf("<<< ERROR: invalid mode '%s'\n", mode)
@@ -239,3 +329,89 @@ func main() {
 	}
 	fmt.Println("<<< DONE.")
 }
+
+// write configuration with secrets redacted to file
+func writeRedacted(fname string, cfg *lib.Config) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return lib.WriteConfigRedacted(f, cfg)
+}
+
+// print the extended public key of a coin account. The key is encoded
+// with the SLIP-0132 version for the address mode of the coin (like
+// "ypub" for P2SH or "zpub" for P2WPKH); the variant is shown as label.
+func printKey(coin *lib.CoinConfig) {
+	label := "xpub"
+	if len(coin.Pk) > 4 {
+		label = coin.Pk[:4]
+	}
+	mode := coin.Mode
+	if len(mode) == 0 {
+		mode = "P2PKH"
+	}
+	fmt.Printf("<<<    Account key (%s, %s): %s\n", label, mode, coin.Pk)
+}
+
+//----------------------------------------------------------------------
+
+// PassphraseEntry handles PIN/passphrase dialogs for the Trezor device:
+// PINs are requested on the console; the passphrase is either preset or
+// requested (without echo) on the console.
+type PassphraseEntry struct {
+	trezor.ConsoleEntry
+
+	passphrase string // passphrase of hidden wallet
+	preset     bool   // passphrase is preset
+	used       bool   // passphrase requested by device
+}
+
+// Ask for PIN or passphrase
+func (e *PassphraseEntry) Ask(mode int) string {
+	// PIN entry
+	if mode == 0 {
+		return e.ConsoleEntry.Ask(mode)
+	}
+	// passphrase entry
+	e.used = true
+	if !e.preset {
+		e.passphrase, _ = readSecret(">>> Passphrase (Trezor): ")
+		e.preset = true
+	}
+	return e.passphrase
+}
+
+// askPassphrase reads the passphrase of a hidden wallet (twice).
+func askPassphrase() (string, error) {
+	pp1, err := readSecret(">>> Passphrase (Trezor): ")
+	if err != nil {
+		return "", err
+	}
+	pp2, err := readSecret(">>>  Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pp1 != pp2 {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return pp1, nil
+}
+
+// readSecret reads a line from the console without echo.
+func readSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err == nil {
+		defer stty("echo")
+	}
+	rdr := bufio.NewReader(os.Stdin)
+	data, _, err := rdr.ReadLine()
+	fmt.Println()
+	return string(data), err
+}
@@ -0,0 +1,79 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Handle account methods
+func account(args []string) {
+	if len(args) == 0 {
+		logger.Println(logger.ERROR, "ERROR: No account command specified")
+		return
+	}
+	switch args[0] {
+	case "reassign":
+		accountReassign(args[1:])
+	default:
+		logger.Printf(logger.ERROR, "ERROR: Unknown account command '%s'", args[0])
+	}
+}
+
+// Move addresses (and coin assignments) of an account to another account
+func accountReassign(args []string) {
+	// parse arguments
+	flags := flag.NewFlagSet("reassign", flag.ExitOnError)
+	var from, to string
+	var archive bool
+	flags.StringVar(&from, "from", "", "Source account (label)")
+	flags.StringVar(&to, "to", "", "Target account (label)")
+	flags.BoolVar(&archive, "archive", false, "Remove coin assignments from source account")
+	flags.Parse(args)
+
+	// resolve accounts
+	if len(from) == 0 || len(to) == 0 {
+		logger.Println(logger.ERROR, "ERROR: Source and target account required")
+		return
+	}
+	fromID, err := mdl.GetAccountID(from)
+	if err != nil {
+		logger.Printf(logger.ERROR, "Invalid account '%s'", from)
+		return
+	}
+	toID, err := mdl.GetAccountID(to)
+	if err != nil {
+		logger.Printf(logger.ERROR, "Invalid account '%s'", to)
+		return
+	}
+	// move addresses
+	n, err := mdl.ReassignAddresses(fromID, toID, archive)
+	if err != nil {
+		logger.Printf(logger.ERROR, "Reassign '%s' to '%s' failed: %s", from, to, err.Error())
+		return
+	}
+	logger.Printf(logger.INFO, "Moved %d addresses from '%s' to '%s'", n, from, to)
+	if archive {
+		logger.Printf(logger.INFO, "Account '%s' archived (no coins assigned)", from)
+	}
+}
@@ -0,0 +1,86 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Handle address methods
+func addr(args []string) {
+	if len(args) == 0 {
+		logger.Println(logger.ERROR, "ERROR: No addr command specified")
+		return
+	}
+	switch args[0] {
+	case "indices":
+		addrIndices(args[1:])
+	default:
+		logger.Printf(logger.ERROR, "ERROR: Unknown addr command '%s'", args[0])
+	}
+}
+
+// status names of addresses
+var addrStatus = []string{"open", "closed", "removed"}
+
+// List allocated derivation indices of a coin (and flag gaps)
+func addrIndices(args []string) {
+	// parse arguments
+	flags := flag.NewFlagSet("indices", flag.ExitOnError)
+	var coin string
+	flags.StringVar(&coin, "c", "", "Coin symbol")
+	flags.Parse(args)
+	if len(coin) == 0 {
+		logger.Println(logger.ERROR, "ERROR: No coin specified")
+		return
+	}
+	// get coin and its addresses
+	ci, err := mdl.GetCoin(coin)
+	if err != nil {
+		logger.Printf(logger.ERROR, "Invalid coin '%s'", coin)
+		return
+	}
+	list, err := mdl.GetAddressIndices(ci.ID)
+	if err != nil {
+		logger.Println(logger.ERROR, "GetAddressIndices: "+err.Error())
+		return
+	}
+	// print list of indices
+	next, gaps := 0, 0
+	for _, ai := range list {
+		if ai.Idx > next {
+			fmt.Printf("%6d - %d: *** GAP ***\n", next, ai.Idx-1)
+			gaps += ai.Idx - next
+		} else if ai.Idx < next {
+			fmt.Printf("%6d: *** DUPLICATE ***\n", ai.Idx)
+		}
+		stat := "?"
+		if ai.Status >= 0 && ai.Status < len(addrStatus) {
+			stat = addrStatus[ai.Status]
+		}
+		fmt.Printf("%6d: %s [%s] %s %.8f\n", ai.Idx, ai.Val, ai.Account, stat, ai.Balance)
+		next = ai.Idx + 1
+	}
+	fmt.Printf("%s: %d addresses, max. index %d, %d missing indices\n", ci.Symbol, len(list), next-1, gaps)
+}
@@ -39,14 +39,19 @@ create table coin (
     symbol varchar(7)  not null unique key,        -- coin symbol (lowercase short name)
     label  varchar(63) default null,               -- coin long name / description
     logo   text        default null,               -- coin logo (base64-encoded SVG)
-    rate   float(53)   default 0.0                 -- market data for coin
+    rate   float(53)   default 0.0,                -- market data for coin
+    est    boolean     default false,              -- rate is estimated (via BTC)
+    chains varchar(255) default null,              -- order of blockchain handlers (comma-separated)
+    display varchar(31) default null,              -- display name (GUI; overrides label)
+    color  varchar(15) default null                -- accent color (GUI; like '#f7931a')
 );
 
 -- account is a receiver for cryptocoins
 create table account (
     id    integer      auto_increment primary key, -- database record id
     label varchar(7)   not null unique key,        -- account label
-    name  varchar(127) default null                -- account name
+    name  varchar(127) default null,               -- account name
+    fiat  varchar(7)   default null                -- fiat currency (if not default)
 );
 
 -- accept list all account/coin pairs that can be processed
@@ -86,7 +91,9 @@ create table tx (
                                                                  --  0 = pending
                                                                  --  1 = expired
     validFrom integer     not null,                              -- transaction life-span (start)
-    validTo   integer     not null                               -- transaction life-span (end)
+    validTo   integer     not null,                              -- transaction life-span (end)
+    memo      bigint      default null,                          -- memo/destination tag (if required by coin)
+    refund    varchar(127) default null                          -- refund address of customer (if captured)
 );
 
 -- incoming funds
@@ -106,6 +113,32 @@ create table rates (
     unique key (dt, coin, fiat)                                  -- unique combinations
 );
 
+-- meta data (like schema version)
+create table meta (
+    name      varchar(31)  not null unique key,                  -- name of entry
+    val       varchar(255) default null                          -- value of entry
+);
+insert into meta(name,val) values ('schema','8');
+
+-- handler statistics (blockchain and market handlers)
+create table hdlrstat (
+    name      varchar(63)  not null unique key,                  -- name of handler
+    success   integer      default 0,                            -- number of successful requests
+    failure   integer      default 0,                            -- number of failed requests
+    lastError varchar(255) default null,                         -- last error message
+    lastFail  integer      default 0                             -- time of last failure
+);
+
+-- undelivered payment events (webhook dead-letter log)
+create table failed_events (
+    id        integer      auto_increment primary key,           -- database record id
+    body      text         not null,                             -- event payload (JSON)
+    error     varchar(255) default null,                         -- last delivery error
+    tries     integer      default 0,                            -- number of delivery attempts
+    created   integer      default 0,                            -- time of first failure
+    lastTry   integer      default 0                             -- time of last attempt
+);
+
 -- ---------------------------------------------------------------------
 -- create views
 -- ---------------------------------------------------------------------
@@ -117,6 +150,8 @@ create view v_coin_accnt as select
     c.label  as label,    -- coin name/label
     c.logo   as logo,     -- coin logo (as b64-encoded SVG)
     c.rate   as rate,     -- current market price for coin
+    c.display as display, -- coin display name (if set)
+    c.color  as color,    -- coin accent color (if set)
     a.id     as accntid,  -- account database ID
     a.label  as account
 from
@@ -162,7 +197,9 @@ create view v_tx as select
     b.name      as account,   -- account name
     t.stat      as stat,      -- transaction status
     t.validFrom as validFrom, -- transaction life-span (start)
-    t.validTo   as validTo    -- transaction life-span (end)
+    t.validTo   as validTo,   -- transaction life-span (end)
+    coalesce(t.memo,0) as memo, -- memo/destination tag (0 = none)
+    coalesce(t.refund,'') as refund -- refund address (empty = none)
 from
     tx t, addr a, account b, coin c
 where
@@ -0,0 +1,160 @@
+-- ---------------------------------------------------------------------
+-- This file is part of 'bitbank-relay'.
+-- Copyright (C) 2021 Bernd Fix   >Y<
+--
+-- 'bitbank-relay' is free software: you can redistribute it and/or modify
+-- it under the terms of the GNU Affero General Public License as published
+-- by the Free Software Foundation, either version 3 of the License,
+-- or (at your option) any later version.
+--
+-- 'bitbank-relay' is distributed in the hope that it will be useful,
+-- but WITHOUT ANY WARRANTY; without even the implied warranty of
+-- MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+-- Affero General Public License for more details.
+--
+-- You should have received a copy of the GNU Affero General Public License
+-- along with this program.  If not, see <http://www.gnu.org/licenses/>.
+--
+-- SPDX-License-Identifier: AGPL3.0-or-later
+-- ---------------------------------------------------------------------
+
+-- Upgrade of existing databases to the current schema version: apply all
+-- steps after the schema version of the database (see 'schema' entry in
+-- the 'meta' table) in the given order.
+
+-- ---------------------------------------------------------------------
+-- no schema version (no 'meta' table) -> 1: fiat currency of accounts
+-- ---------------------------------------------------------------------
+
+alter table account add column fiat varchar(7) default null;
+
+create table meta (
+    name      varchar(31)  not null unique,                      -- name of entry
+    val       varchar(255) default null                          -- value of entry
+);
+insert into meta(name,val) values ('schema','1');
+
+-- ---------------------------------------------------------------------
+-- schema version 1 -> 2: memo/destination tag of transactions
+-- ---------------------------------------------------------------------
+
+alter table tx add column memo bigint default null;
+
+drop view v_tx;
+create view v_tx as select
+    t.txid      as txid,      -- transaction ID
+    a.id        as addrId,    -- addrress database ID
+    a.val       as addr,      -- address string
+    c.id        as coinId,    -- coin database ID
+    c.label     as coin,      -- coin name
+    b.id        as accntId,   -- account database ID
+    b.name      as account,   -- account name
+    t.stat      as stat,      -- transaction status
+    t.validFrom as validFrom, -- transaction life-span (start)
+    t.validTo   as validTo,   -- transaction life-span (end)
+    coalesce(t.memo,0) as memo -- memo/destination tag (0 = none)
+from
+    tx t, addr a, account b, coin c
+where
+    t.addr = a.id and a.accnt = b.id and a.coin = c.id;
+
+update meta set val='2' where name='schema';
+
+-- ---------------------------------------------------------------------
+-- schema version 2 -> 3: handler statistics
+-- ---------------------------------------------------------------------
+
+-- handler statistics (blockchain and market handlers)
+create table hdlrstat (
+    name      varchar(63)  not null unique,                      -- name of handler
+    success   integer      default 0,                            -- number of successful requests
+    failure   integer      default 0,                            -- number of failed requests
+    lastError varchar(255) default null,                         -- last error message
+    lastFail  integer      default 0                             -- time of last failure
+);
+
+update meta set val='3' where name='schema';
+
+-- ---------------------------------------------------------------------
+-- schema version 3 -> 4: estimated coin rates
+-- ---------------------------------------------------------------------
+
+alter table coin add column est boolean default false;
+
+update meta set val='4' where name='schema';
+
+-- ---------------------------------------------------------------------
+-- schema version 4 -> 5: refund address of transactions
+-- ---------------------------------------------------------------------
+
+alter table tx add column refund varchar(127) default null;
+
+drop view v_tx;
+create view v_tx as select
+    t.txid      as txid,      -- transaction ID
+    a.id        as addrId,    -- addrress database ID
+    a.val       as addr,      -- address string
+    c.id        as coinId,    -- coin database ID
+    c.label     as coin,      -- coin name
+    b.id        as accntId,   -- account database ID
+    b.name      as account,   -- account name
+    t.stat      as stat,      -- transaction status
+    t.validFrom as validFrom, -- transaction life-span (start)
+    t.validTo   as validTo,   -- transaction life-span (end)
+    coalesce(t.memo,0) as memo, -- memo/destination tag (0 = none)
+    coalesce(t.refund,'') as refund -- refund address (empty = none)
+from
+    tx t, addr a, account b, coin c
+where
+    t.addr = a.id and a.accnt = b.id and a.coin = c.id;
+
+update meta set val='5' where name='schema';
+
+-- ---------------------------------------------------------------------
+-- schema version 5 -> 6: blockchain handler order per coin
+-- ---------------------------------------------------------------------
+
+alter table coin add column chains varchar(255) default null;
+
+update meta set val='6' where name='schema';
+
+-- ---------------------------------------------------------------------
+-- schema version 6 -> 7: undelivered payment events
+-- ---------------------------------------------------------------------
+
+-- undelivered payment events (webhook dead-letter log)
+create table failed_events (
+    id        integer      primary key,                          -- database record id
+    body      text         not null,                             -- event payload (JSON)
+    error     varchar(255) default null,                         -- last delivery error
+    tries     integer      default 0,                            -- number of delivery attempts
+    created   integer      default 0,                            -- time of first failure
+    lastTry   integer      default 0                             -- time of last attempt
+);
+
+update meta set val='7' where name='schema';
+
+-- ---------------------------------------------------------------------
+-- schema version 7 -> 8: display name and accent color of coins
+-- ---------------------------------------------------------------------
+
+alter table coin add column display varchar(31) default null;
+alter table coin add column color   varchar(15) default null;
+
+drop view v_coin_accnt;
+create view v_coin_accnt as select
+    c.id     as coinId,   -- coin database ID
+    c.symbol as coin,     -- coin symbol
+    c.label  as label,    -- coin name/label
+    c.logo   as logo,     -- coin logo (as b64-encoded SVG)
+    c.rate   as rate,     -- current market price for coin
+    c.display as display, -- coin display name (if set)
+    c.color  as color,    -- coin accent color (if set)
+    a.id     as accntid,  -- account database ID
+    a.label  as account
+from
+    coin c, account a, accept x
+where
+    x.accnt = a.id and x.coin = c.id;
+
+update meta set val='8' where name='schema';
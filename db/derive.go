@@ -0,0 +1,103 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"relay/lib"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Run address derivation service: the service derives addresses from the
+// public keys in the configuration and is used by web services that don't
+// hold the public keys themselves.
+func derive(args []string) {
+	// parse arguments
+	flags := flag.NewFlagSet("derive", flag.ExitOnError)
+	var listen string
+	flags.StringVar(&listen, "l", "localhost:8081", "Listen address for derivation service")
+	flags.Parse(args)
+
+	// access token (optional)
+	token := ""
+	if cfg.Derive != nil {
+		token = cfg.Derive.Token
+	}
+	if len(token) == 0 {
+		logger.Println(logger.WARN, "Derivation service without access token")
+	}
+	// run service
+	mux := http.NewServeMux()
+	mux.HandleFunc("/derive", func(w http.ResponseWriter, r *http.Request) {
+		if len(token) > 0 {
+			auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+				logger.Printf(logger.WARN, "derive: unauthorized access from %s", r.RemoteAddr)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		// create response and send it on exit
+		resp := new(lib.DeriveResponse)
+		defer func() {
+			buf, _ := json.Marshal(resp)
+			w.Write(buf)
+		}()
+
+		// derive address
+		coin := r.FormValue("c")
+		hdlr, ok := lib.HdlrList[coin]
+		if !ok {
+			resp.Error = "unknown coin"
+			return
+		}
+		idx, err := strconv.Atoi(r.FormValue("i"))
+		if err != nil || idx < 0 {
+			resp.Error = "invalid index"
+			return
+		}
+		if resp.Addr, err = hdlr.DeriveAddress(idx); err != nil {
+			resp.Error = err.Error()
+			return
+		}
+		logger.Printf(logger.INFO, "derive: %s[%d] => %s", coin, idx, resp.Addr)
+	})
+	srv := &http.Server{
+		Handler:      mux,
+		Addr:         listen,
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+	logger.Printf(logger.INFO, "Derivation service listening at %s", listen)
+	if err := srv.ListenAndServe(); err != nil {
+		logger.Println(logger.ERROR, err.Error())
+	}
+}
@@ -0,0 +1,199 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix  >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"relay/lib"
+	"sort"
+)
+
+// check results
+const (
+	chkPass = "PASS"
+	chkWarn = "WARN"
+	chkFail = "FAIL"
+)
+
+// DoctorReport collects the results of all checks (by category)
+type DoctorReport struct {
+	cats    []string            // list of categories (in order)
+	results map[string][]string // check results per category
+	count   map[string]int      // number of checks per result
+}
+
+// NewDoctorReport creates an empty report
+func NewDoctorReport() *DoctorReport {
+	return &DoctorReport{
+		cats:    make([]string, 0),
+		results: make(map[string][]string),
+		count:   make(map[string]int),
+	}
+}
+
+// Add the result of a check to the report
+func (r *DoctorReport) Add(cat, res, msg string, args ...interface{}) {
+	if _, ok := r.results[cat]; !ok {
+		r.cats = append(r.cats, cat)
+	}
+	r.results[cat] = append(r.results[cat], res+"  "+fmt.Sprintf(msg, args...))
+	r.count[res]++
+}
+
+// Print the report to stdout
+func (r *DoctorReport) Print() {
+	for _, cat := range r.cats {
+		fmt.Printf("[%s]\n", cat)
+		for _, line := range r.results[cat] {
+			fmt.Println("   " + line)
+		}
+	}
+	fmt.Printf("Summary: %d passed, %d warnings, %d failed\n",
+		r.count[chkPass], r.count[chkWarn], r.count[chkFail])
+}
+
+// Failed returns true if at least one check has failed.
+func (r *DoctorReport) Failed() bool {
+	return r.count[chkFail] > 0
+}
+
+// doctor cross-checks the configuration against the database and the
+// available handlers. The report is printed to stdout; returns false
+// if any check has failed.
+func doctor(cfg *lib.Config) bool {
+	rep := NewDoctorReport()
+	defer rep.Print()
+
+	// check database connection and schema version
+	var err error
+	if mdl, err = lib.Connect(cfg.Model); err != nil {
+		rep.Add("model", chkFail, "connect: %s", err.Error())
+		return false
+	}
+	defer mdl.Close()
+	version, err := mdl.GetSchemaVersion()
+	if err != nil {
+		rep.Add("model", chkFail, "schema version: %s", err.Error())
+	} else if version != lib.SchemaVersion {
+		rep.Add("model", chkFail, "schema version %d (expected %d; see db_upgrade.<engine>.sql)", version, lib.SchemaVersion)
+	} else {
+		rep.Add("model", chkPass, "schema version %d", version)
+	}
+
+	// check handler configurations (and their API keys)
+	if cfg.Handler == nil {
+		rep.Add("handler", chkFail, "no handler configuration")
+	} else {
+		for _, name := range sortedKeys(cfg.Handler.Blockchain) {
+			hdlrCfg := cfg.Handler.Blockchain[name]
+			if !lib.IsChainHandler(name) {
+				rep.Add("handler", chkFail, "unknown blockchain handler '%s'", name)
+				continue
+			}
+			rep.Add("handler", chkPass, "blockchain handler '%s'", name)
+			if len(hdlrCfg.ApiKey) == 0 {
+				rep.Add("keys", chkWarn, "no API key for blockchain handler '%s'", name)
+			} else {
+				rep.Add("keys", chkPass, "API key for blockchain handler '%s'", name)
+			}
+		}
+		if cfg.Handler.Market == nil || len(cfg.Handler.Market.Service) == 0 {
+			rep.Add("handler", chkFail, "no market handler configured")
+		} else {
+			if len(cfg.Handler.Market.Fiat) == 0 {
+				rep.Add("handler", chkFail, "no fiat currency for market handlers")
+			}
+			for _, name := range sortedKeys(cfg.Handler.Market.Service) {
+				hdlrCfg := cfg.Handler.Market.Service[name]
+				if !lib.IsMarketHandler(name) {
+					rep.Add("handler", chkFail, "unknown market handler '%s'", name)
+					continue
+				}
+				rep.Add("handler", chkPass, "market handler '%s'", name)
+				if len(hdlrCfg.ApiKey) == 0 {
+					status := chkFail
+					if !lib.MarketKeyRequired(name) {
+						status = chkWarn
+					}
+					rep.Add("keys", status, "no API key for market handler '%s'", name)
+				} else {
+					rep.Add("keys", chkPass, "API key for market handler '%s'", name)
+				}
+			}
+		}
+	}
+
+	// initialize shared handlers (used by the coin handlers)
+	if cfg.Handler != nil {
+		lib.InitSharedHandlers(cfg.Handler)
+	}
+	// check coins
+	for _, coin := range cfg.Coins {
+		// coin must be in the database
+		if _, err = mdl.GetCoin(coin.Symb); err != nil {
+			rep.Add("coins", chkFail, "%s: not in database (%s)", coin.Symb, err.Error())
+			continue
+		}
+		// blockchain handler for coin must be configured
+		if cfg.Handler != nil {
+			if _, ok := cfg.Handler.Blockchain[coin.Blockchain]; !ok {
+				rep.Add("coins", chkFail, "%s: blockchain handler '%s' not configured", coin.Symb, coin.Blockchain)
+				continue
+			}
+		}
+		// addresses derived by a remote service can't be checked here
+		if len(coin.Pk) == 0 && cfg.Derive != nil && len(cfg.Derive.URL) > 0 {
+			rep.Add("coins", chkWarn, "%s: remote derivation (address not checked)", coin.Symb)
+			continue
+		}
+		// coin handler must initialize and generate the expected address
+		hdlr, err := lib.NewHandler(coin, cfg.NetworkID())
+		if err != nil {
+			rep.Add("coins", chkFail, "%s: handler: %s", coin.Symb, err.Error())
+			continue
+		}
+		addr, err := hdlr.GetAddress(0)
+		if err != nil {
+			rep.Add("coins", chkFail, "%s: address: %s", coin.Symb, err.Error())
+			continue
+		}
+		if !lib.SameAddress(addr, coin.Addr) {
+			rep.Add("coins", chkFail, "%s: addr mismatch: %s != %s", coin.Symb, addr, coin.Addr)
+			continue
+		}
+		if !hdlr.Supported() {
+			rep.Add("coins", chkFail, "%s: not supported by blockchain handlers %v", coin.Symb, coin.ChainHandlers())
+			continue
+		}
+		rep.Add("coins", chkPass, "%s", coin.Symb)
+	}
+	return !rep.Failed()
+}
+
+// return the sorted list of keys in a map
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"relay/lib"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Handle undelivered payment events (dead-letter log)
+func events(args []string) {
+	if len(args) == 0 {
+		logger.Println(logger.ERROR, "ERROR: No events command specified")
+		return
+	}
+	switch args[0] {
+	case "list":
+		listEvents()
+	case "redrive":
+		redriveEvents(args[1:])
+	default:
+		logger.Printf(logger.ERROR, "ERROR: Unknown events command '%s'", args[0])
+	}
+}
+
+// List undelivered events
+func listEvents() {
+	list, err := mdl.GetFailedEvents()
+	if err != nil {
+		logger.Println(logger.ERROR, "Listing events failed: "+err.Error())
+		return
+	}
+	for _, ev := range list {
+		fmt.Printf("#%d: %s (%d tries, last %s): %s\n", ev.ID,
+			time.Unix(ev.Created, 0).Format(time.DateTime), ev.Tries,
+			time.Unix(ev.LastTry, 0).Format(time.DateTime), ev.Error)
+		fmt.Printf("    %s\n", ev.Body)
+	}
+	logger.Printf(logger.INFO, "Done: %d undelivered events.", len(list))
+}
+
+// Deliver undelivered events (again) to the webhook
+func redriveEvents(args []string) {
+	// parse arguments
+	flags := flag.NewFlagSet("redrive", flag.ExitOnError)
+	var id int64
+	flags.Int64Var(&id, "i", 0, "Event ID (default: all events)")
+	flags.Parse(args)
+
+	if cfg.Service.Webhook == nil || len(cfg.Service.Webhook.URL) == 0 {
+		logger.Println(logger.ERROR, "No webhook defined")
+		return
+	}
+	list, err := mdl.GetFailedEvents()
+	if err != nil {
+		logger.Println(logger.ERROR, "Listing events failed: "+err.Error())
+		return
+	}
+	wh := lib.NewWebhook(cfg.Service.Webhook, mdl)
+	ctx := context.Background()
+	total, failed := 0, 0
+	for _, ev := range list {
+		if id != 0 && ev.ID != id {
+			continue
+		}
+		total++
+		if err = wh.Redeliver(ctx, ev); err != nil {
+			logger.Printf(logger.ERROR, "Event #%d failed: %s", ev.ID, err.Error())
+			failed++
+			continue
+		}
+		logger.Printf(logger.INFO, "Event #%d delivered", ev.ID)
+	}
+	logger.Printf(logger.INFO, "Done: %d events delivered (%d failed).", total-failed, failed)
+}
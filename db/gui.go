@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"embed"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -42,13 +43,17 @@ import (
 	"github.com/bfix/gospel/logger"
 )
 
-//go:embed gui.htpl
+//go:embed gui.htpl report.htpl
 var fsys embed.FS
 
 var (
-	tpl    *template.Template // HTML templates
-	srv    *http.Server       // HTTP server
-	prefix string             // URL prefix (if behind reverse proxy)
+	tpl      *template.Template // HTML templates
+	srv      *http.Server       // HTTP server
+	prefix   string             // URL prefix (if behind reverse proxy)
+	maxLogo  int64              // max. size of logo uploads
+	dust     float64            // min. fiat value of listed addresses
+	compress bool               // compress responses?
+	decimals map[string]int     // number of decimals to display per coin
 )
 
 // PageData for generic data used to render page
@@ -65,6 +70,9 @@ func gui(args []string) {
 	)
 	flags.StringVar(&listen, "l", "localhost:8080", "Listen address for web GUI")
 	flags.StringVar(&prefix, "p", "", "URL prefix")
+	flags.Int64Var(&maxLogo, "m", 32768, "Max. size of logo uploads (in bytes)")
+	flags.Float64Var(&dust, "d", 0, "Hide addresses with fiat value below (dust)")
+	flags.BoolVar(&compress, "z", false, "Compress (gzip) responses")
 	flags.Parse(args)
 	// normalize prefix (no trailing slash)
 	prefix = strings.TrimRight(prefix, "/")
@@ -84,7 +92,22 @@ func gui(args []string) {
 		"date": func(ts int64) string {
 			return time.Unix(ts, 0).Format("02 Jan 06 15:04")
 		},
+		"addr": func(symb, addr string) string {
+			return lib.DisplayAddress(symb, addr, "")
+		},
+		"amount": func(a float64, symb string) string {
+			d, ok := decimals[symb]
+			if !ok {
+				d = 8
+			}
+			return fmt.Sprintf("%.[2]*[1]f", a, d)
+		},
 	})
+	// number of decimals to display per coin
+	decimals = make(map[string]int)
+	for _, coin := range cfg.Coins {
+		decimals[coin.Symb] = coin.GetDecimals()
+	}
 	if _, err := tpl.ParseFS(fsys, "gui.htpl"); err != nil {
 		logger.Println(logger.ERROR, "GUI templates: "+err.Error())
 		return
@@ -100,14 +123,24 @@ func gui(args []string) {
 	mux.HandleFunc("/tx/", transactionHandler)
 	mux.HandleFunc("/", guiHandler)
 
-	// prepare HTTP server
+	// prepare HTTP server (with optional access restriction and
+	// compression of responses)
+	admin, err := lib.NewAllowlist(cfg.Service.AdminNets)
+	if err != nil {
+		logger.Println(logger.ERROR, "GUI access: "+err.Error())
+		return
+	}
+	hdlr := lib.Restricted(admin, mux)
+	if compress {
+		hdlr = lib.Compressed(hdlr)
+	}
 	srv = &http.Server{
 		Addr:              listen,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       300 * time.Second,
 		ReadHeaderTimeout: 20 * time.Second,
-		Handler:           mux,
+		Handler:           hdlr,
 	}
 	// run HTTP server
 	go func() {
@@ -165,6 +198,11 @@ type DashboardData struct {
 	Coins     []*lib.AccCoinInfo `json:"coins"`     // list of active coins
 	Accounts  []*lib.AccntInfo   `json:"accounts"`  // list of active accounts
 	Addresses []*lib.AddrInfo    `json:"addresses"` // list of (active) addresses
+	Query     string             `json:"query"`     // account search query
+	Handlers  []*lib.HandlerStat `json:"handlers"`  // handler statistics
+	Stale     []*lib.StaleRate   `json:"stale"`     // coins with stale rates
+	Dust      float64            `json:"dust"`      // dust threshold (fiat value)
+	ShowDust  bool               `json:"showDust"`  // show addresses with dust?
 }
 
 // handle dashboard (main entry page)
@@ -180,13 +218,25 @@ func guiHandler(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "ERROR: "+err.Error())
 		return
 	}
-	// collect account info
-	if dd.Accounts, err = mdl.GetAccounts(0); err != nil {
+	// collect account info (optionally filtered by search query)
+	dd.Query = r.URL.Query().Get("q")
+	if len(dd.Query) > 0 {
+		dd.Accounts, err = mdl.SearchAccounts(dd.Query)
+	} else {
+		dd.Accounts, err = mdl.GetAccounts(0)
+	}
+	if err != nil {
 		io.WriteString(w, "ERROR: "+err.Error())
 		return
 	}
-	// collect address info
-	if dd.Addresses, err = mdl.GetAddresses(0, 0, 0, false); err != nil {
+	// collect address info (without dust balances unless requested)
+	dd.Dust = dust
+	dd.ShowDust = r.URL.Query().Get("dust") == "1"
+	minValue := dust
+	if dd.ShowDust {
+		minValue = 0
+	}
+	if dd.Addresses, err = mdl.GetAddressesAbove(0, 0, 0, false, minValue); err != nil {
 		io.WriteString(w, "ERROR: "+err.Error())
 		return
 	}
@@ -195,6 +245,18 @@ func guiHandler(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "ERROR: "+err.Error())
 		return
 	}
+	// collect handler statistics (as saved by the relay service)
+	if dd.Handlers, err = mdl.GetHandlerStats(); err != nil {
+		io.WriteString(w, "ERROR: "+err.Error())
+		return
+	}
+	// collect coins with stale exchange rates (if checked)
+	if age := cfg.Handler.Market.StaleAge; age > 0 {
+		if dd.Stale, err = mdl.GetStaleRates(age, dd.Fiat); err != nil {
+			io.WriteString(w, "ERROR: "+err.Error())
+			return
+		}
+	}
 	// show dashboard
 	renderPage(w, dd, "dashboard")
 }
@@ -206,8 +268,11 @@ func guiHandler(w http.ResponseWriter, r *http.Request) {
 // CoinData holds the information needed to render a coin page
 type CoinData struct {
 	PageData
-	Fiat string           `json:"fiat"` // fiat currency
-	Coin *lib.AccCoinInfo `json:"coin"` // info about coin
+	Fiat      string           `json:"fiat"`      // fiat currency
+	Coin      *lib.AccCoinInfo `json:"coin"`      // info about coin
+	Chains    string           `json:"chains"`    // order of blockchain handlers
+	Available []string         `json:"available"` // configured blockchain handlers
+	ChainErr  string           `json:"chainErr"`  // invalid order of handlers
 }
 
 // process "coin" page request
@@ -240,6 +305,13 @@ func coinHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, fmt.Sprintf("%s/coin/?id=%d", prefix, id), http.StatusFound)
 			return
 		}
+		// check if we change the order of blockchain handlers
+		if chains, ok := query["chains"]; ok {
+			if cd.ChainErr = setChainOrder(id, chains[0]); len(cd.ChainErr) == 0 {
+				http.Redirect(w, r, fmt.Sprintf("%s/coin/?id=%d", prefix, id), http.StatusFound)
+				return
+			}
+		}
 		// get assignments from model
 		if res, err := mdl.GetAccumulatedCoin(id); err == nil {
 			if len(res) > 0 {
@@ -256,10 +328,57 @@ func coinHandler(w http.ResponseWriter, r *http.Request) {
 		logger.Println(logger.WARN, "coinHandler: No ID in query")
 		return
 	}
+	// get order of blockchain handlers
+	if coin := coinConfig(cd.Coin.Symbol); coin != nil {
+		cd.Available = coin.ChainHandlers()
+		cd.Chains = strings.Join(cd.Available, ",")
+		if orders, err := mdl.GetChainOrders(); err == nil && orders[coin.Symb] != nil {
+			cd.Chains = strings.Join(orders[coin.Symb], ",")
+		}
+	}
 	// show coin page
 	renderPage(w, cd, "coin")
 }
 
+// setChainOrder stores a new order of blockchain handlers (comma-separated
+// list; empty for configured order) for a coin. Returns an error message
+// if the order is invalid.
+func setChainOrder(id int64, chains string) string {
+	ci, err := mdl.GetCoinInfo(id)
+	if err != nil {
+		return err.Error()
+	}
+	coin := coinConfig(ci.Symbol)
+	if coin == nil {
+		return "coin not configured"
+	}
+	var order []string
+	for _, name := range strings.Split(chains, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			order = append(order, name)
+		}
+	}
+	if len(order) > 0 {
+		if err = coin.CheckChainOrder(order); err != nil {
+			return err.Error()
+		}
+	}
+	if err = mdl.SetChainOrder(coin.Symb, order); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// coinConfig returns the configuration of a coin (or nil if not configured)
+func coinConfig(symb string) *lib.CoinConfig {
+	for _, coin := range cfg.Coins {
+		if coin.Symb == symb {
+			return coin
+		}
+	}
+	return nil
+}
+
 //======================================================================
 // handle account-related GUI requests
 //======================================================================
@@ -305,6 +424,10 @@ func accountHandler(w http.ResponseWriter, r *http.Request) {
 		if res, err := mdl.GetAccounts(id); err == nil {
 			if len(res) > 0 {
 				ad.Accnt = res[0]
+				// use fiat currency of account (if defined)
+				if len(ad.Accnt.Fiat) > 0 {
+					ad.Fiat = ad.Accnt.Fiat
+				}
 			} else {
 				logger.Println(logger.WARN, "accountHandler: no account infos")
 				return
@@ -361,6 +484,16 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			// flag address for balance sync
 			case "sync":
 				err = mdl.SyncAddress(id)
+			// set balance manually (operator override)
+			case "balance":
+				if r.Method != http.MethodPost {
+					err = fmt.Errorf("balance override requires POST")
+					break
+				}
+				var balance float64
+				if balance, err = strconv.ParseFloat(r.FormValue("balance"), 64); err == nil {
+					err = mdl.SetBalance(id, balance)
+				}
 			}
 			if err != nil {
 				logger.Printf(logger.ERROR, "addressHandler: "+err.Error())
@@ -425,9 +558,17 @@ type TxData struct {
 	Account string             `json:"account"` // account name
 	Coin    string             `json:"coin"`    // coin name
 	Txs     []*lib.Transaction `json:"txs"`     // list of transactions
+	Total   int                `json:"total"`   // total number of transactions
+	First   int                `json:"first"`   // index of first transaction in list
+	Last    int                `json:"last"`    // index of last transaction in list
+	Prev    string             `json:"prev"`    // link to previous page
+	Next    string             `json:"next"`    // link to next page
 	Links   map[string]string  `json:"links"`   // links
 }
 
+// number of transactions on a page
+const txPageSize = 50
+
 // handle transaction requests
 func transactionHandler(w http.ResponseWriter, r *http.Request) {
 	// show transaction infos
@@ -459,10 +600,27 @@ func transactionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		td.Links["&#9654; Coin"] = fmt.Sprintf("/coin/?id=%d", coin)
 	}
-	if td.Txs, err = mdl.GetTransactions(addr, accnt, coin); err != nil {
+	offset, _ := queryInt(query, "ofs")
+	if offset < 0 {
+		offset = 0
+	}
+	if td.Txs, td.Total, err = mdl.GetTransactions(addr, accnt, coin, txPageSize, int(offset)); err != nil {
 		logger.Println(logger.ERROR, "txHandler: "+err.Error())
 		return
 	}
+	// set page navigation
+	td.First = int(offset) + 1
+	td.Last = int(offset) + len(td.Txs)
+	pageLink := func(ofs int64) string {
+		query.Set("ofs", strconv.FormatInt(ofs, 10))
+		return prefix + "/tx/?" + query.Encode()
+	}
+	if offset > 0 {
+		td.Prev = pageLink(max(offset-txPageSize, 0))
+	}
+	if td.Last < td.Total {
+		td.Next = pageLink(offset + txPageSize)
+	}
 	// set page title
 	if td.Txs != nil && len(td.Txs) > 0 {
 		td.Address = td.Txs[0].Addr
@@ -511,7 +669,7 @@ func newHandler(w http.ResponseWriter, r *http.Request) {
 	// create new account object
 	case "accnt":
 		label := r.FormValue("label")
-		if len(label) == 0 || !checkChars(label, "^[A-Za-z0-9_]*$") {
+		if !checkChars(label, "^[A-Za-z0-9_]{1,7}$") {
 			logger.Println(logger.ERROR, "newAccount: Invalid label")
 			return
 		}
@@ -520,7 +678,12 @@ func newHandler(w http.ResponseWriter, r *http.Request) {
 			logger.Println(logger.ERROR, "newAccount: Invalid name")
 			return
 		}
-		if err := mdl.NewAccount(label, name); err != nil {
+		fiat := r.FormValue("fiat")
+		if len(fiat) > 0 && !checkChars(fiat, "^[A-Za-z]{3,7}$") {
+			logger.Println(logger.ERROR, "newAccount: Invalid fiat currency")
+			return
+		}
+		if _, err := mdl.NewAccount(label, name, fiat, nil); err != nil {
 			logger.Printf(logger.ERROR, "newAccount: %v", err)
 			return
 		}
@@ -534,25 +697,42 @@ func newHandler(w http.ResponseWriter, r *http.Request) {
 //======================================================================
 
 func logoHandler(w http.ResponseWriter, r *http.Request) {
+	// limit size of request (logo plus some room for form data)
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogo+4096)
+
 	// get POST parameters
-	if err := r.ParseMultipartForm(0); err != nil {
+	if err := r.ParseMultipartForm(maxLogo); err != nil {
 		logger.Printf(logger.ERROR, "ParseForm() err: %v", err)
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			http.Error(w, "logo too large", http.StatusRequestEntityTooLarge)
+		}
 		return
 	}
 	id := r.FormValue("id")
 	coin := r.FormValue("coin")
-	file, _, err := r.FormFile("logo")
+	file, hdr, err := r.FormFile("logo")
 	if err != nil {
 		logger.Printf(logger.ERROR, "ParseForm() err: %v", err)
 		return
 	}
 	defer file.Close()
+	if hdr.Size > maxLogo {
+		logger.Printf(logger.ERROR, "logo too large (%d bytes)", hdr.Size)
+		http.Error(w, "logo too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	// get logo data
 	body, err := io.ReadAll(file)
 	if err != nil {
 		logger.Printf(logger.ERROR, "ParseForm() err: %v", err)
 		return
 	}
+	if body, err = lib.SanitizeSVG(body); err != nil {
+		logger.Printf(logger.ERROR, "logo: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	logo := base64.StdEncoding.EncodeToString(body)
 	// save logo to model
 	if err := mdl.SetCoinLogo(coin, logo); err != nil {
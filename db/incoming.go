@@ -0,0 +1,98 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"context"
+	"flag"
+	"relay/lib"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Handle incoming funds
+func incoming(args []string) {
+	if len(args) == 0 {
+		logger.Println(logger.ERROR, "ERROR: No incoming command specified")
+		return
+	}
+	switch args[0] {
+	case "rebuild":
+		rebuildIncoming(args[1:])
+	default:
+		logger.Printf(logger.ERROR, "ERROR: Unknown incoming command '%s'", args[0])
+	}
+}
+
+// Rebuild 'incoming' table from funds on the blockchain
+func rebuildIncoming(args []string) {
+	// parse arguments
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	var span, accnt, coin, addr string
+	flags.StringVar(&span, "r", "*:*", "Date range for rebuild (YYYY-MM-DD)")
+	flags.StringVar(&addr, "a", "", "Rebuild address")
+	flags.StringVar(&coin, "c", "", "Rebuild coin")
+	flags.StringVar(&accnt, "p", "", "Rebuild account")
+	flags.Parse(args)
+
+	// resolve repository ids
+	addrID, coinID, accntID, ok := resolveIDs(addr, coin, accnt)
+	if !ok {
+		return
+	}
+	// check arguments
+	from, to, err := parseSpan(span)
+	if err != nil {
+		logger.Println(logger.ERROR, err.Error())
+		return
+	}
+	// list of addresses (same as for reports)
+	list, err := reportAddresses(addrID, accntID, coinID)
+	if err != nil {
+		return
+	}
+	// replace incoming funds for each address
+	ctx := context.Background()
+	total, failed := 0, 0
+	for _, ai := range list {
+		hdlr, ok := lib.HdlrList[ai.CoinSymb]
+		if !ok {
+			logger.Printf(logger.ERROR, "No matching handler for '%s'", ai.CoinName)
+			failed++
+			continue
+		}
+		funds, err := hdlr.GetFunds(ctx, ai.ID, ai.Val)
+		if err != nil {
+			logger.Printf(logger.ERROR, "Funds for '%s'(%s) failed: %s", ai.Val, ai.CoinSymb, err.Error())
+			failed++
+			continue
+		}
+		n, err := mdl.ReplaceFunds(ai.ID, from, to, funds)
+		if err != nil {
+			logger.Printf(logger.ERROR, "Rebuild for '%s'(%s) failed: %s", ai.Val, ai.CoinSymb, err.Error())
+			failed++
+			continue
+		}
+		logger.Printf(logger.INFO, "Rebuilt %d entries for '%s'(%s)", n, ai.Val, ai.CoinSymb)
+		total += n
+	}
+	logger.Printf(logger.INFO, "Done: %d entries for %d addresses (%d failed).", total, len(list)-failed, failed)
+}
@@ -21,11 +21,16 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"flag"
+	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"relay/lib"
 	"strings"
 
 	"github.com/bfix/gospel/logger"
@@ -50,18 +55,27 @@ func logoImport(args []string) {
 	// parse arguments
 	fs := flag.NewFlagSet("logo_import", flag.ExitOnError)
 	var (
-		dir, file string
+		dir, file, link, symb string
 	)
 	fs.StringVar(&dir, "i", "", "Folder with coin logos")
 	fs.StringVar(&file, "f", "", "File with coin logo")
+	fs.StringVar(&link, "u", "", "URL of coin logo")
+	fs.StringVar(&symb, "symb", "", "Coin symbol (for logo from URL)")
 	fs.Parse(args)
 
 	// check arguments
-	if len(dir) == 0 && len(file) == 0 {
-		logger.Println(logger.ERROR, "ERROR: logo-import -- missing input file or folder")
+	if len(dir) == 0 && len(file) == 0 && len(link) == 0 {
+		logger.Println(logger.ERROR, "ERROR: logo-import -- missing input file, folder or URL")
 		fs.Usage()
 		return
 	}
+	// import from URL?
+	if len(link) > 0 {
+		if err := importSVGFromURL(link, symb); err != nil {
+			logger.Println(logger.ERROR, "ERROR: "+err.Error())
+		}
+		return
+	}
 	// import single file?
 	if len(file) > 0 {
 		err := importSVG(file)
@@ -100,9 +114,37 @@ func importSVG(fname string) error {
 	if err != nil {
 		return err
 	}
+	coin := strings.TrimSuffix(filepath.Base(fname), ".svg")
+	return storeSVG(coin, body)
+}
+
+// import SVG from URL; the coin symbol is taken from the file name in the
+// URL if not specified.
+func importSVGFromURL(link, coin string) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	if len(coin) == 0 {
+		base := path.Base(u.Path)
+		if !strings.HasSuffix(base, ".svg") {
+			return fmt.Errorf("can't infer coin from URL (use '-symb')")
+		}
+		coin = strings.TrimSuffix(base, ".svg")
+	}
+	body, err := lib.HTTPQuery(context.Background(), u.String())
+	if err != nil {
+		return err
+	}
+	return storeSVG(coin, body)
+}
+
+// sanitize SVG and store it as coin logo
+func storeSVG(coin string, body []byte) (err error) {
+	if body, err = lib.SanitizeSVG(body); err != nil {
+		return
+	}
 	logo := base64.StdEncoding.EncodeToString(body)
-	base := filepath.Base(fname)
-	coin := base[:len(base)-4]
 
 	logger.Printf(logger.INFO, "Adding logo for coin '%s'\n", coin)
 	return mdl.SetCoinLogo(coin, logo)
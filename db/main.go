@@ -89,6 +89,14 @@ func main() {
 		logger.Println(logger.ERROR, err.Error())
 		return
 	}
+	// override settings from environment
+	for _, name := range cfg.ApplyEnv() {
+		logger.Println(logger.INFO, "   Using setting from "+name)
+	}
+	if err = cfg.Validate(); err != nil {
+		logger.Println(logger.ERROR, "Invalid configuration:\n"+err.Error())
+		return
+	}
 	// setup logging
 	logger.Println(logger.INFO, "Setting up logging...")
 	if len(cfg.Service.LogFile) > 0 {
@@ -97,6 +105,36 @@ func main() {
 	}
 	logger.SetLogLevelFromName(cfg.Service.LogLevel)
 
+	// special command "doctor": check configuration against model
+	// (needs to run before handler initialization)
+	if fs.Arg(0) == "doctor" {
+		if !doctor(cfg) {
+			logger.Flush()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// special command "verify": check base addresses of coins (no
+	// database access needed)
+	if fs.Arg(0) == "verify" {
+		if !verify(cfg) {
+			logger.Flush()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// special command "schedule": simulate balance check schedule (no
+	// database access needed)
+	if fs.Arg(0) == "schedule" {
+		if !schedule(cfg, fs.Args()[1:]) {
+			logger.Flush()
+			os.Exit(1)
+		}
+		return
+	}
+
 	// connect to model
 	logger.Println(logger.INFO, "Connecting to model...")
 	if mdl, err = lib.Connect(cfg.Model); err != nil {
@@ -138,5 +176,41 @@ func main() {
 	//------------------------------------------------------------------
 	case "report":
 		report(args[1:])
+
+	//------------------------------------------------------------------
+	// handle incoming funds
+	//------------------------------------------------------------------
+	case "incoming":
+		incoming(args[1:])
+
+	//------------------------------------------------------------------
+	// handle exchange rates
+	//------------------------------------------------------------------
+	case "rates":
+		rates(args[1:])
+
+	//------------------------------------------------------------------
+	// handle undelivered payment events
+	//------------------------------------------------------------------
+	case "events":
+		events(args[1:])
+
+	//------------------------------------------------------------------
+	// handle address methods
+	//------------------------------------------------------------------
+	case "addr":
+		addr(args[1:])
+
+	//------------------------------------------------------------------
+	// handle account methods
+	//------------------------------------------------------------------
+	case "account":
+		account(args[1:])
+
+	//------------------------------------------------------------------
+	// run address derivation service
+	//------------------------------------------------------------------
+	case "derive":
+		derive(args[1:])
 	}
 }
@@ -0,0 +1,64 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Handle exchange rates
+func rates(args []string) {
+	if len(args) == 0 {
+		logger.Println(logger.ERROR, "ERROR: No rates command specified")
+		return
+	}
+	switch args[0] {
+	case "prune":
+		pruneRates(args[1:])
+	default:
+		logger.Printf(logger.ERROR, "ERROR: Unknown rates command '%s'", args[0])
+	}
+}
+
+// Downsample old daily rates to weekly rates
+func pruneRates(args []string) {
+	// parse arguments
+	keep := 0
+	if r := cfg.Model.Rates; r != nil {
+		keep = r.Keep
+	}
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	flags.IntVar(&keep, "k", keep, "Number of days daily rates are kept")
+	flags.Parse(args)
+
+	if keep <= 0 {
+		logger.Println(logger.ERROR, "No retention for daily rates defined")
+		return
+	}
+	n, err := mdl.PruneRates(keep)
+	if err != nil {
+		logger.Println(logger.ERROR, "Pruning rates failed: "+err.Error())
+		return
+	}
+	logger.Printf(logger.INFO, "Done: %d daily rates older than %d days downsampled.", n, keep)
+}
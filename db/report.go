@@ -23,12 +23,15 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
+	htmpl "html/template"
 	"os"
 	"relay/lib"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,7 +46,8 @@ import (
 func report(args []string) {
 	// parse arguments
 	flags := flag.NewFlagSet("report", flag.ExitOnError)
-	var span, mode, accnt, coin, addr, out, fname string
+	var span, mode, accnt, coin, addr, out, fname, desc string
+	var resume bool
 	flags.StringVar(&span, "r", "*:*", "Date range for report (YYYY-MM-DD)")
 	flags.StringVar(&mode, "m", "fast", "Report mode")
 	flags.StringVar(&addr, "a", "", "Reported address")
@@ -51,64 +55,112 @@ func report(args []string) {
 	flags.StringVar(&accnt, "p", "", "Reported account")
 	flags.StringVar(&out, "o", "csv", "Output format")
 	flags.StringVar(&fname, "f", "report.txt", "Output file")
+	flags.StringVar(&desc, "d", defDescription, "Description template (accounting format)")
+	flags.BoolVar(&resume, "resume", false, "Resume full report from checkpoint")
 	flags.Parse(args)
 
 	// resolve repository ids
-	var (
-		coinID, addrID, accntID int64
-		err                     error
-	)
-	if coin != "" {
-		if coinID, err = mdl.GetCoinID(coin); err != nil {
-			logger.Printf(logger.ERROR, "Invalid coin '%s'\n", coin)
-			return
-		}
-	}
-	if accnt != "" {
-		if accntID, err = mdl.GetAccountID(accnt); err != nil {
-			logger.Printf(logger.ERROR, "Invalid account '%s'\n", coin)
-			return
-		}
-	}
-	if addr != "" {
-		if addrID, err = mdl.GetAddressID(addr); err != nil {
-			logger.Printf(logger.ERROR, "Invalid address '%s'\n", coin)
-			return
-		}
-	}
-	// check arguments
-	ts := strings.Split(span, ":")
-	from, err := convertDate(ts[0], true)
-	if err != nil {
-		logger.Println(logger.ERROR, "invalid start date: "+err.Error())
+	addrID, coinID, accntID, ok := resolveIDs(addr, coin, accnt)
+	if !ok {
 		return
 	}
-	to, err := convertDate(ts[1], false)
+	// check arguments
+	from, to, err := parseSpan(span)
 	if err != nil {
-		logger.Println(logger.ERROR, "invalid end date: "+err.Error())
+		logger.Println(logger.ERROR, err.Error())
 		return
 	}
 
-	// prepare report file
-	fOut, err := os.Create(fname)
-	if err != nil {
-		logger.Println(logger.ERROR, "output file: "+err.Error())
-		return
+	// full reports use a checkpoint file to keep track of progress
+	var ckpt *reportCheckpoint
+	if mode == "full" {
+		key := fmt.Sprintf("%d:%d:%d:%d:%d", addrID, coinID, accntID, from, to)
+		if ckpt, err = loadCheckpoint(fname+".ckpt", key, resume); err != nil {
+			logger.Println(logger.ERROR, "checkpoint: "+err.Error())
+			return
+		}
 	}
-	defer fOut.Close()
 
 	// call report generator.
 	ctx := context.Background()
-	report, err := doReporting(ctx, addrID, coinID, accntID, from, to, mode, out)
+	report, err := doReporting(ctx, addrID, coinID, accntID, from, to, mode, out, desc, ckpt)
 	if err != nil {
 		logger.Println(logger.ERROR, "report failed: "+err.Error())
+		if ckpt != nil {
+			logger.Println(logger.INFO, "Use '-resume' to continue the report.")
+		}
 		return
 	}
+	// write report file
 	logger.Printf(logger.DBG, "Report size: %d\n", len(report))
-	fOut.Write(report)
+	if err = os.WriteFile(fname, report, 0644); err != nil {
+		logger.Println(logger.ERROR, "output file: "+err.Error())
+		return
+	}
+	// report complete: remove checkpoint
+	if ckpt != nil {
+		os.Remove(ckpt.fname)
+	}
 	logger.Println(logger.INFO, "Done.")
 }
 
+//----------------------------------------------------------------------
+// Checkpoints for (long-running) full reports
+//----------------------------------------------------------------------
+
+// reportCheckpoint records the processed addresses and their transactions
+type reportCheckpoint struct {
+	Key  string      `json:"key"`  // selection criteria of report
+	Done []int64     `json:"done"` // list of processed addresses
+	Txs  []*ReportTx `json:"txs"`  // transactions of processed addresses
+
+	fname string         // name of checkpoint file
+	done  map[int64]bool // processed addresses (lookup)
+}
+
+// loadCheckpoint returns a checkpoint for a report. If resume is set, the
+// checkpoint is read from file (it must match the selection criteria);
+// a new (empty) checkpoint is returned otherwise.
+func loadCheckpoint(fname, key string, resume bool) (*reportCheckpoint, error) {
+	ckpt := &reportCheckpoint{
+		Key:   key,
+		Done:  make([]int64, 0),
+		Txs:   make([]*ReportTx, 0),
+		fname: fname,
+		done:  make(map[int64]bool),
+	}
+	if !resume {
+		return ckpt, nil
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, ckpt); err != nil {
+		return nil, err
+	}
+	if ckpt.Key != key {
+		return nil, fmt.Errorf("checkpoint does not match report arguments")
+	}
+	for _, id := range ckpt.Done {
+		ckpt.done[id] = true
+	}
+	logger.Printf(logger.INFO, "Resuming report: %d addresses already processed.", len(ckpt.Done))
+	return ckpt, nil
+}
+
+// Add the transactions of a processed address and save the checkpoint.
+func (ckpt *reportCheckpoint) Add(addrID int64, txs []*ReportTx) error {
+	ckpt.Done = append(ckpt.Done, addrID)
+	ckpt.Txs = append(ckpt.Txs, txs...)
+	ckpt.done[addrID] = true
+	data, err := json.Marshal(ckpt)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ckpt.fname, data, 0644)
+}
+
 //======================================================================
 // Report generator
 //======================================================================
@@ -122,13 +174,20 @@ type ReportTx struct {
 	Amount    float64 `json:"amount"`    // received funds
 	FiatRecv  float64 `json:"fiatRecv"`  // exchange value at receive time
 	FiatNow   float64 `json:"fiatNow"`   // exchange value at report time
+	Mismatch  bool    `json:"mismatch"`  // unexpected script type of funds
+	Explorer  string  `json:"explorer"`  // explorer link of receiving address
 }
 
+// default description of a fund in accounting reports
+const defDescription = "{coin} payment to {account} ({addr})"
+
 func doReporting(
 	ctx context.Context,
 	addrID, coinID, accntID int64, // selection criteria
 	from, to int64, // date range for report
 	mode, out string,
+	desc string, // description template (accounting format)
+	ckpt *reportCheckpoint, // checkpoint (full mode only; can be nil)
 ) (report []byte, err error) {
 
 	// sanity checks.
@@ -138,24 +197,23 @@ func doReporting(
 	if !strings.Contains(";full;fast;", ";"+mode+";") {
 		return nil, fmt.Errorf("invalid report mode")
 	}
-	if !strings.Contains(";csv;json;html;", ";"+out+";") {
+	if !strings.Contains(";csv;json;html;accounting;", ";"+out+";") {
 		return nil, fmt.Errorf("invalid output format")
 	}
 	// list of addresses we care about in the report
 	var list []*lib.AddrInfo
-	if list, err = mdl.GetAddresses(addrID, accntID, coinID, true); err != nil {
-		logger.Println(logger.ERROR, "Failed to collect address list")
+	if list, err = reportAddresses(addrID, accntID, coinID); err != nil {
 		return
 	}
-	logger.Printf(logger.INFO, "Found %d addresses for reporting.\n", len(list))
-
 	// generate list of transactions for report
 	txList := make([]*ReportTx, 0)
+	if ckpt != nil {
+		txList = append(txList, ckpt.Txs...)
+	}
 	var funds []*lib.Fund
 	for _, ai := range list {
-		// skip empty address
-		if ai.Balance < 1e-8 {
-			logger.Printf(logger.INFO, "Skipping empty address '%s'(%s)", ai.Val, ai.CoinSymb)
+		// skip addresses processed in a previous run
+		if ckpt != nil && ckpt.done[ai.ID] {
 			continue
 		}
 		if mode == "fast" {
@@ -177,6 +235,7 @@ func doReporting(
 			}
 		}
 		// convert funds into transactions
+		addrTxs := make([]*ReportTx, 0)
 		if n := len(funds); n > 0 {
 			logger.Printf(logger.INFO, "Found %d funding transactions for %s (%s).\n", n, ai.Val, ai.CoinSymb)
 			for _, f := range funds {
@@ -187,13 +246,22 @@ func doReporting(
 						Account:   ai.Account,
 						Addr:      ai.Val,
 						Coin:      ai.CoinSymb,
+						Mismatch:  f.Mismatch,
+						Explorer:  ai.Explorer,
 					}
-					txList = append(txList, tx)
+					addrTxs = append(addrTxs, tx)
 				}
 			}
 		} else {
 			logger.Printf(logger.INFO, "No funding transactions found for '%s'(%s)", ai.Val, ai.CoinSymb)
 		}
+		txList = append(txList, addrTxs...)
+		// record progress
+		if ckpt != nil {
+			if err = ckpt.Add(ai.ID, addrTxs); err != nil {
+				return
+			}
+		}
 	}
 	logger.Printf(logger.INFO, "Found %d reportable transactions.\n", len(txList))
 
@@ -222,21 +290,173 @@ func doReporting(
 		return json.Marshal(txList)
 	case "csv":
 		wrt := new(bytes.Buffer)
-		wrt.WriteString("Date;Account;Amount;Coin;FiatRecv;FiatNow\n")
+		wrt.WriteString("Date;Account;Amount;Coin;FiatRecv;FiatNow;Mismatch;Explorer\n")
 		for _, tx := range txList {
-			fmt.Fprintf(wrt, "%s;\"%s\";%.5f;\"%s\";%.2f;%.2f\n",
+			fmt.Fprintf(wrt, "%s;\"%s\";%.5f;\"%s\";%.2f;%.2f;%v;\"%s\"\n",
 				time.Unix(tx.Timestamp, 0).Format("2006-01-02"),
-				tx.Account, tx.Amount, tx.Coin, tx.FiatRecv, tx.FiatNow)
+				tx.Account, tx.Amount, tx.Coin, tx.FiatRecv, tx.FiatNow, tx.Mismatch, tx.Explorer)
 		}
 		report = wrt.Bytes()
+	case "accounting":
+		// CSV for import into accounting software (one row per fund)
+		wrt := new(bytes.Buffer)
+		cw := csv.NewWriter(wrt)
+		cw.Write([]string{"Date", "Description", "Account", "Gross", "Fiat", "Currency"})
+		for _, tx := range txList {
+			date := time.Unix(tx.Timestamp, 0).Format("2006-01-02")
+			descr := strings.NewReplacer(
+				"{date}", date,
+				"{account}", tx.Account,
+				"{coin}", tx.Coin,
+				"{addr}", tx.Addr,
+			).Replace(desc)
+			cw.Write([]string{
+				date, descr, tx.Account,
+				strconv.FormatFloat(tx.Amount, 'f', 8, 64),
+				strconv.FormatFloat(tx.FiatRecv, 'f', 2, 64),
+				cfg.Handler.Market.Fiat,
+			})
+		}
+		cw.Flush()
+		report, err = wrt.Bytes(), cw.Error()
+	case "html":
+		report, err = htmlReport(txList)
 	}
 	return
 }
 
+// ReportTotal is the sum of funds (per coin or overall)
+type ReportTotal struct {
+	Coin     string  // coin label (empty for overall total)
+	Count    int     // number of funds
+	Amount   float64 // sum of amounts (per coin only)
+	FiatRecv float64 // sum of exchange values at receive time
+	FiatNow  float64 // sum of exchange values at report time
+}
+
+// htmlReport renders the list of funds as HTML table with totals per coin
+// and a grand total.
+func htmlReport(txList []*ReportTx) ([]byte, error) {
+	data := struct {
+		Created     string
+		First, Last string
+		Fiat        string
+		Txs         []*ReportTx
+		Coins       []*ReportTotal
+		Total       *ReportTotal
+	}{
+		Created: time.Now().Format("2006-01-02 15:04"),
+		Fiat:    cfg.Handler.Market.Fiat,
+		Txs:     txList,
+		Total:   new(ReportTotal),
+	}
+	// compute totals
+	coins := make(map[string]*ReportTotal)
+	for _, tx := range txList {
+		ct, ok := coins[tx.Coin]
+		if !ok {
+			ct = &ReportTotal{Coin: tx.Coin}
+			coins[tx.Coin] = ct
+			data.Coins = append(data.Coins, ct)
+		}
+		for _, t := range []*ReportTotal{ct, data.Total} {
+			t.Count++
+			t.Amount += tx.Amount
+			t.FiatRecv += tx.FiatRecv
+			t.FiatNow += tx.FiatNow
+		}
+	}
+	sort.Slice(data.Coins, func(i, j int) bool {
+		return data.Coins[i].Coin < data.Coins[j].Coin
+	})
+	if n := len(txList); n > 0 {
+		data.First = time.Unix(txList[0].Timestamp, 0).Format("2006-01-02")
+		data.Last = time.Unix(txList[n-1].Timestamp, 0).Format("2006-01-02")
+	}
+	// render report
+	tpl := htmpl.New("report").Funcs(htmpl.FuncMap{
+		"date": func(ts int64) string {
+			return time.Unix(ts, 0).Format("2006-01-02")
+		},
+	})
+	if _, err := tpl.ParseFS(fsys, "report.htpl"); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := tpl.ExecuteTemplate(buf, "report", data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 //======================================================================
 // Helper functions
 //======================================================================
 
+// reportAddresses returns the list of (non-empty) addresses matching the
+// selection criteria.
+func reportAddresses(addrID, accntID, coinID int64) ([]*lib.AddrInfo, error) {
+	all, err := mdl.GetAddresses(addrID, accntID, coinID, true)
+	if err != nil {
+		logger.Println(logger.ERROR, "Failed to collect address list")
+		return nil, err
+	}
+	list := make([]*lib.AddrInfo, 0, len(all))
+	for _, ai := range all {
+		// skip empty address
+		if ai.Balance < 1e-8 {
+			logger.Printf(logger.INFO, "Skipping empty address '%s'(%s)", ai.Val, ai.CoinSymb)
+			continue
+		}
+		list = append(list, ai)
+	}
+	logger.Printf(logger.INFO, "Found %d addresses for reporting.\n", len(list))
+	return list, nil
+}
+
+// resolveIDs returns the repository ids for address, coin and account
+// (id is 0 if the argument is empty). Invalid arguments are logged.
+func resolveIDs(addr, coin, accnt string) (addrID, coinID, accntID int64, ok bool) {
+	var err error
+	if coin != "" {
+		if coinID, err = mdl.GetCoinID(coin); err != nil {
+			logger.Printf(logger.ERROR, "Invalid coin '%s'\n", coin)
+			return
+		}
+	}
+	if accnt != "" {
+		if accntID, err = mdl.GetAccountID(accnt); err != nil {
+			logger.Printf(logger.ERROR, "Invalid account '%s'\n", accnt)
+			return
+		}
+	}
+	if addr != "" {
+		if addrID, err = mdl.GetAddressID(addr); err != nil {
+			logger.Printf(logger.ERROR, "Invalid address '%s'\n", addr)
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// parseSpan returns the Unix epochs for a date range "<from>:<to>"
+func parseSpan(span string) (from, to int64, err error) {
+	ts := strings.Split(span, ":")
+	if len(ts) != 2 {
+		err = fmt.Errorf("invalid date range '%s'", span)
+		return
+	}
+	if from, err = convertDate(ts[0], true); err != nil {
+		err = fmt.Errorf("invalid start date: %s", err.Error())
+		return
+	}
+	if to, err = convertDate(ts[1], false); err != nil {
+		err = fmt.Errorf("invalid end date: %s", err.Error())
+	}
+	return
+}
+
 // convertDate returns the Unix epoch for a given date (times is 00:00:00
 // for start and "23:59:59" for end dates)
 func convertDate(d string, isStart bool) (int64, error) {
@@ -0,0 +1,95 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"relay/lib"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule prints the balance check schedule of an address for the wait
+// parameters [min, factor, max] and a sequence of check outcomes ('f' for
+// new funds found, 'n' for none). The wait times are computed like the
+// balancer does (without randomization unless requested); the database is
+// not accessed.
+func schedule(cfg *lib.Config, args []string) bool {
+	// parse arguments
+	flags := flag.NewFlagSet("schedule", flag.ExitOnError)
+	var wait, seq string
+	var random bool
+	flags.StringVar(&wait, "w", "", "Wait parameters 'min,factor,max' (default: balanceWait)")
+	flags.StringVar(&seq, "s", strings.Repeat("n", 20), "Outcomes of checks ('f': funds found, 'n': none)")
+	flags.BoolVar(&random, "r", false, "Randomize factor (like the balancer)")
+	flags.Parse(args)
+
+	bw := cfg.Model.BalanceWait
+	if len(wait) > 0 {
+		bw = nil
+		for _, s := range strings.Split(wait, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				fmt.Println("ERROR: invalid wait parameter: " + s)
+				return false
+			}
+			bw = append(bw, v)
+		}
+	}
+	if len(bw) != 3 || bw[0] < 1 || bw[1] < 1 || bw[2] < bw[0] {
+		fmt.Printf("ERROR: invalid wait parameters %v (need min >= 1, factor >= 1, max >= min)\n", bw)
+		return false
+	}
+	// simulate schedule: the address is requested at time 0
+	fmt.Printf("Wait parameters: min=%ds, factor=%.2f, max=%ds\n", int64(bw[0]), bw[1], int64(bw[2]))
+	fmt.Printf("%4s %-7s %10s %12s %14s\n", "#", "RESULT", "CHECK AT", "NEXT WAIT", "NEXT CHECK AT")
+	t, wt := int64(bw[0]), int64(bw[0])
+	for i, outcome := range seq {
+		var found bool
+		switch outcome {
+		case 'f':
+			found = true
+		case 'n':
+		default:
+			fmt.Printf("ERROR: invalid outcome '%c'\n", outcome)
+			return false
+		}
+		r := bw[1]
+		if random {
+			r = lib.WaitFactor(bw[1])
+		}
+		wt = lib.NextWait(bw, wt, found, r)
+		result := "none"
+		if found {
+			result = "funds"
+		}
+		fmt.Printf("%4d %-7s %10s %12s %14s\n", i+1, result, dur(t), dur(wt), dur(t+wt))
+		t += wt
+	}
+	return true
+}
+
+// format duration (in seconds)
+func dur(secs int64) string {
+	return (time.Duration(secs) * time.Second).String()
+}
@@ -0,0 +1,61 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"relay/lib"
+)
+
+// verify re-derives the base address (index 0) of all coins from the
+// configuration and compares it with the configured address. Unlike the
+// handler initialization, all coins are checked (and listed with their
+// result). Returns false if any coin failed.
+func verify(cfg *lib.Config) bool {
+	failed := 0
+	fmt.Printf("%-6s %-8s %s\n", "COIN", "RESULT", "ADDRESS")
+	for _, coin := range cfg.Coins {
+		// skip coins without public key (remote derivation)
+		if len(coin.Pk) == 0 {
+			fmt.Printf("%-6s %-8s %s\n", coin.Symb, "SKIPPED", "(no public key)")
+			continue
+		}
+		// derive base address
+		hdlr, err := lib.NewHandler(coin, cfg.NetworkID())
+		var addr string
+		if err == nil {
+			addr, err = hdlr.DeriveAddress(0)
+		}
+		switch {
+		case err != nil:
+			fmt.Printf("%-6s %-8s %s\n", coin.Symb, "ERROR", err.Error())
+			failed++
+		case !lib.SameAddress(addr, coin.Addr):
+			fmt.Printf("%-6s %-8s %s\n", coin.Symb, "MISMATCH", addr)
+			fmt.Printf("%-6s %-8s %s (configured)\n", "", "", coin.Addr)
+			failed++
+		default:
+			fmt.Printf("%-6s %-8s %s\n", coin.Symb, "OK", addr)
+		}
+	}
+	fmt.Printf("%d coins checked, %d failed\n", len(cfg.Coins), failed)
+	return failed == 0
+}
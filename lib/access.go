@@ -0,0 +1,142 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// AccessConfig restricts access to admin routes to a list of networks.
+// Behind a reverse proxy, the client address is taken from the
+// 'X-Forwarded-For' header if the request comes from a trusted proxy.
+type AccessConfig struct {
+	Allow   []string `json:"allow"`   // allowed networks (CIDR or IP address)
+	Proxies []string `json:"proxies"` // trusted proxies (CIDR or IP address)
+}
+
+// Allowlist of networks for access control
+type Allowlist struct {
+	allow   []*net.IPNet // allowed networks
+	proxies []*net.IPNet // trusted proxies
+}
+
+// NewAllowlist creates an allowlist from the configuration. Without
+// configuration (or allowed networks), nil is returned (no restriction).
+// On error, the returned list denies all requests.
+func NewAllowlist(cfg *AccessConfig) (al *Allowlist, err error) {
+	if cfg == nil || len(cfg.Allow) == 0 {
+		return nil, nil
+	}
+	al = new(Allowlist)
+	var allow, proxies []*net.IPNet
+	if allow, err = parseNets(cfg.Allow); err != nil {
+		return
+	}
+	if proxies, err = parseNets(cfg.Proxies); err != nil {
+		return
+	}
+	al.allow, al.proxies = allow, proxies
+	return
+}
+
+// Allows returns true if the client of a request is in an allowed network.
+func (al *Allowlist) Allows(r *http.Request) bool {
+	if al == nil {
+		return true
+	}
+	ip := al.clientIP(r)
+	return ip != nil && inNets(ip, al.allow)
+}
+
+// get client address of request: forwarded addresses are followed
+// (right to left) as long as the request comes from trusted proxies.
+func (al *Allowlist) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	fwd := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(fwd) - 1; i >= 0 && ip != nil && inNets(ip, al.proxies); i-- {
+		addr := strings.TrimSpace(fwd[i])
+		if len(addr) == 0 {
+			break
+		}
+		ip = net.ParseIP(addr)
+	}
+	return ip
+}
+
+// Restricted wraps a HTTP handler: requests from clients that are not
+// allowed are rejected (403).
+func Restricted(al *Allowlist, hdlr http.Handler) http.Handler {
+	if al == nil {
+		return hdlr
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !al.Allows(r) {
+			logger.Printf(logger.WARN, "Access denied: %s %s (from %s)", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		hdlr.ServeHTTP(w, r)
+	})
+}
+
+// parse list of networks (CIDR notation or single IP address)
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network '%s'", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s'", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// check if address is in one of the networks
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bfix/gospel/bitcoin"
+	"golang.org/x/crypto/sha3"
+)
+
+// Display formats of addresses: addresses are stored in one canonical form
+// (as derived), but can be displayed in another (equivalent) form for
+// client compatibility. Formats are coin-specific:
+//   - "checksum" (ETH, ETC): EIP-55 mixed-case checksum encoding (canonical
+//     form of new addresses)
+//   - "lower" (ETH, ETC): all-lowercase hex
+//   - "cashaddr" (BCH): CashAddr with "bitcoincash:" prefix
+//   - "legacy" (BCH): Base58Check encoding (like Bitcoin)
+//
+// An empty format (or "default") displays the canonical form.
+
+// Error codes
+var (
+	ErrAddrFormat  = fmt.Errorf("unknown address format for coin")
+	ErrAddrConvert = fmt.Errorf("address can't be converted")
+)
+
+// address converters per coin and format
+var addrFormats = map[string]map[string]func(string) (string, error){
+	"eth": {"checksum": eip55Address, "lower": lowerAddress},
+	"etc": {"checksum": eip55Address, "lower": lowerAddress},
+	"bch": {"cashaddr": prefixedCashAddr, "legacy": legacyCashAddr},
+}
+
+// ValidAddrFormat returns true if the display format is available for the
+// coin.
+func ValidAddrFormat(coin, format string) bool {
+	if len(format) == 0 || format == "default" {
+		return true
+	}
+	_, ok := addrFormats[coin][format]
+	return ok
+}
+
+// FormatAddress returns the address of a coin in the given display format.
+func FormatAddress(coin, addr, format string) (string, error) {
+	if len(format) == 0 || format == "default" {
+		return addr, nil
+	}
+	conv, ok := addrFormats[coin][format]
+	if !ok {
+		return "", ErrAddrFormat
+	}
+	return conv(addr)
+}
+
+// DisplayAddress returns the address of a coin in the given display format;
+// without format, the format from the coin configuration is used. The
+// address is returned unchanged if it can't be converted.
+func DisplayAddress(coin, addr, format string) string {
+	if len(format) == 0 {
+		if hdlr, ok := HdlrList[coin]; ok {
+			format = hdlr.Config().AddrFormat
+		}
+	}
+	if res, err := FormatAddress(coin, addr, format); err == nil {
+		return res
+	}
+	return addr
+}
+
+//----------------------------------------------------------------------
+// Payment URIs
+//----------------------------------------------------------------------
+
+// payment URI scheme of a coin
+type uriScheme struct {
+	scheme string // URI scheme (BIP-21 and derivatives; EIP-681)
+	suffix string // suffix of target address (like chain id)
+	memo   string // query parameter for memo/destination tag
+}
+
+// URI schemes of coins (coins without scheme use the plain address)
+var uriSchemes = map[string]uriScheme{
+	"btc":  {scheme: "bitcoin"},
+	"bch":  {scheme: "bitcoincash"},
+	"btg":  {scheme: "bitcoingold"},
+	"dash": {scheme: "dash"},
+	"dgb":  {scheme: "digibyte"},
+	"doge": {scheme: "dogecoin"},
+	"ltc":  {scheme: "litecoin"},
+	"nmc":  {scheme: "namecoin"},
+	"vtc":  {scheme: "vertcoin"},
+	"zec":  {scheme: "zcash"},
+	"eth":  {scheme: "ethereum"},
+	"etc":  {scheme: "ethereum", suffix: "@61"},
+	"xrp":  {scheme: "ripple", memo: "dt"},
+}
+
+// PaymentURI returns the payment URI for an address of a coin (as encoded
+// in QR codes). A memo/destination tag (if not zero) is added as the query
+// parameter used by wallets of the coin ("dt" if unknown).
+func PaymentURI(coin, addr string, memo int64) string {
+	uri := addr
+	us, ok := uriSchemes[coin]
+	if ok && !strings.HasPrefix(addr, us.scheme+":") {
+		uri = us.scheme + ":" + url.PathEscape(addr) + us.suffix
+	}
+	if memo > 0 {
+		param := us.memo
+		if len(param) == 0 {
+			param = "dt"
+		}
+		q := url.Values{}
+		q.Set(param, strconv.FormatInt(memo, 10))
+		uri += "?" + q.Encode()
+	}
+	return uri
+}
+
+//----------------------------------------------------------------------
+// ETH-like addresses
+//----------------------------------------------------------------------
+
+// SameAddress returns true if two addresses are equal. ETH-like addresses
+// are compared case-insensitive (with or without EIP-55 checksum).
+func SameAddress(a, b string) bool {
+	if isEthAddress(a) && isEthAddress(b) {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// check for ETH-like address ("0x" and 40 hex digits)
+func isEthAddress(addr string) bool {
+	_, err := ethHex(addr)
+	return err == nil && strings.HasPrefix(addr, "0x")
+}
+
+// get hex part of an ETH-like address
+func ethHex(addr string) (string, error) {
+	h := strings.ToLower(strings.TrimPrefix(addr, "0x"))
+	if len(h) != 40 {
+		return "", ErrAddrConvert
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return "", ErrAddrConvert
+	}
+	return h, nil
+}
+
+// all-lowercase address
+func lowerAddress(addr string) (string, error) {
+	h, err := ethHex(addr)
+	if err != nil {
+		return "", err
+	}
+	return "0x" + h, nil
+}
+
+// EIP-55 checksum encoding: a hex letter is upper case if the matching
+// nibble of the Keccak-256 hash of the lowercase address is 8 or higher.
+func eip55Address(addr string) (string, error) {
+	h, err := ethHex(addr)
+	if err != nil {
+		return "", err
+	}
+	hsh := sha3.NewLegacyKeccak256()
+	hsh.Write([]byte(h))
+	sum := hsh.Sum(nil)
+	res := []byte(h)
+	for i, c := range res {
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			res[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(res), nil
+}
+
+//----------------------------------------------------------------------
+// BCH addresses
+//----------------------------------------------------------------------
+
+// prefix of CashAddr addresses (mainnet)
+const cashAddrPrefix = "bitcoincash:"
+
+// CashAddr with prefix
+func prefixedCashAddr(addr string) (string, error) {
+	if strings.HasPrefix(addr, cashAddrPrefix) {
+		return addr, nil
+	}
+	if _, err := cashAddrPayload(addr); err != nil {
+		return "", err
+	}
+	return cashAddrPrefix + addr, nil
+}
+
+// CashAddr as legacy (Base58Check) address
+func legacyCashAddr(addr string) (string, error) {
+	payload, err := cashAddrPayload(addr)
+	if err != nil {
+		return "", err
+	}
+	// map CashAddr type to legacy version (P2PKH, P2SH)
+	var version byte
+	switch payload[0] {
+	case 0x00:
+		version = 0x00
+	case 0x08:
+		version = 0x05
+	default:
+		return "", ErrAddrConvert
+	}
+	data := append([]byte{version}, payload[1:]...)
+	cs := bitcoin.Hash256(data)
+	return bitcoin.Base58Encode(append(data, cs[:4]...)), nil
+}
+
+// get payload (version byte and hash) from CashAddr address (without
+// checksum verification: only used for addresses in canonical form)
+func cashAddrPayload(addr string) ([]byte, error) {
+	addr = strings.ToLower(strings.TrimPrefix(addr, cashAddrPrefix))
+	// 160-bit hash: 34 characters payload and 8 characters checksum
+	if len(addr) != 42 {
+		return nil, ErrAddrConvert
+	}
+	b32 := base32.NewEncoding("qpzry9x8gf2tvdw0s3jn54khce6mua7l").WithPadding(base32.NoPadding)
+	payload, err := b32.DecodeString(addr[:34])
+	if err != nil || len(payload) != 21 {
+		return nil, ErrAddrConvert
+	}
+	return payload, nil
+}
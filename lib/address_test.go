@@ -0,0 +1,122 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPaymentURI(t *testing.T) {
+	for _, tc := range []struct {
+		coin, addr string
+		memo       int64
+		want       string
+	}{
+		{"btc", "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", 0, "bitcoin:bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"},
+		{"bch", "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", 0, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"},
+		{"bch", "qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", 0, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"},
+		{"eth", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", 0, "ethereum:0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{"etc", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", 0, "ethereum:0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed@61"},
+		{"xrp", "rEb8TK3gBgk5auZkwc6sHnwrGVJH8DuaLh", 123456, "ripple:rEb8TK3gBgk5auZkwc6sHnwrGVJH8DuaLh?dt=123456"},
+		{"xyz", "someaddr", 0, "someaddr"},
+		{"xyz", "someaddr", 42, "someaddr?dt=42"},
+	} {
+		if got := PaymentURI(tc.coin, tc.addr, tc.memo); got != tc.want {
+			t.Errorf("%s(%s,%d): got '%s', want '%s'", tc.coin, tc.addr, tc.memo, got, tc.want)
+		}
+	}
+}
+
+func TestLegacyCashAddr(t *testing.T) {
+	// test vectors from the CashAddr specification
+	for _, tc := range []struct {
+		addr, want string
+	}{
+		{"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu"},
+		{"bitcoincash:qr95sy3j9xwd2ap32xkykttr4cvcu7as4y0qverfuy", "1KXrWXciRDZUpQwQmuM1DbwsKDLYAYsVLR"},
+		{"bitcoincash:qqq3728yw0y47sqn6l2na30mcw6zm78dzqre909m2r", "16w1D5WRVKJuZUsSRzdLp9w3YGcgoxDXb"},
+		{"bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq", "3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC"},
+		{"bitcoincash:pr95sy3j9xwd2ap32xkykttr4cvcu7as4yc93ky28e", "3LDsS579y7sruadqu11beEJoTjdFiFCdX4"},
+		{"bitcoincash:pqq3728yw0y47sqn6l2na30mcw6zm78dzq5ucqzc37", "31nwvkZwyPdgzjBJZXfDmSWsC4ZLKpYyUw"},
+		// without prefix
+		{"qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu"},
+	} {
+		got, err := FormatAddress("bch", tc.addr, "legacy")
+		if err != nil {
+			t.Errorf("%s: %s", tc.addr, err.Error())
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.addr, got, tc.want)
+		}
+	}
+	// invalid addresses
+	for _, addr := range []string{
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx",   // too short
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwoy22gdx6a", // invalid character
+		"1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu",                     // legacy
+	} {
+		if got, err := FormatAddress("bch", addr, "legacy"); err == nil {
+			t.Errorf("%s: converted to %s", addr, got)
+		}
+	}
+}
+
+func TestEIP55Address(t *testing.T) {
+	// test vectors from EIP-55
+	for _, want := range []string{
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		for _, addr := range []string{want, strings.ToLower(want), "0x" + strings.ToUpper(want[2:])} {
+			got, err := FormatAddress("eth", addr, "checksum")
+			if err != nil {
+				t.Errorf("%s: %s", addr, err.Error())
+				continue
+			}
+			if got != want {
+				t.Errorf("%s: got %s, want %s", addr, got, want)
+			}
+		}
+		if got, _ := FormatAddress("etc", want, "lower"); got != strings.ToLower(want) {
+			t.Errorf("%s: got lowercase %s", want, got)
+		}
+		if !SameAddress(want, strings.ToLower(want)) {
+			t.Errorf("%s: not same as lowercase", want)
+		}
+	}
+	// invalid addresses
+	for _, addr := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA",   // too short
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", // no hex
+	} {
+		if got, err := FormatAddress("eth", addr, "checksum"); err == nil {
+			t.Errorf("%s: converted to %s", addr, got)
+		}
+	}
+}
@@ -22,7 +22,10 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/bfix/gospel/logger"
 )
@@ -33,14 +36,75 @@ var (
 	ErrBalanceAccessDenied = fmt.Errorf("HTTP GET access denied")
 )
 
+// default time (in seconds) after which a pending balance check is
+// considered stuck
+const defaultStaleCheck = 3600
+
+// coins skipped by the balancer as unsupported (reported once)
+var unsupported sync.Map
+
+// Payment is the context of incoming funds on an address (detected by an
+// increased balance).
+type Payment struct {
+	AddrID  int64   // repository ID of address
+	Addr    string  // address
+	Coin    string  // coin symbol
+	Amount  float64 // amount received (balance increase)
+	Balance float64 // new balance of address
+	Rate    float64 // exchange rate of coin
+	Closed  bool    // address closed (limit reached)
+	Time    int64   // time of balance check
+}
+
+// PaymentHandler reacts to incoming payments (like notifications or custom
+// bookkeeping). OnPayment is called by the balancer after the new balance
+// and the incoming funds are recorded in the model. The call happens in the
+// goroutine of the balance check: it must return quickly (and move
+// long-running or blocking work to its own goroutine); the address is not
+// checked again before OnPayment returns. The payment must not be modified.
+type PaymentHandler interface {
+	OnPayment(ctx context.Context, p *Payment)
+}
+
+// PaymentFunc is a function used as a PaymentHandler.
+type PaymentFunc func(ctx context.Context, p *Payment)
+
+// OnPayment calls the function.
+func (f PaymentFunc) OnPayment(ctx context.Context, p *Payment) {
+	f(ctx, p)
+}
+
+// default payment handler (no action)
+var nopPayment = PaymentFunc(func(context.Context, *Payment) {})
+
 // StartBalancer starts the background balance processor.
 // It returns a channel for balance check requests that accepts int64
 // values that refer to the model id of the address record
 // that is to be checked.
-func StartBalancer(ctx context.Context, mdl *Model) chan int64 {
+// Successful balance checks are held for a configurable time: requests for
+// the same address within that time are ignored (the result of the previous
+// check is still valid).
+// A pending check that is running for longer than the configured time
+// (model setting "staleCheck"; default one hour) is considered stuck: it is logged and a new request for the address starts
+// a new check (otherwise the address would never be checked again).
+// An optional payment handler is called for every balance increase (see
+// PaymentHandler for its contract); without handler, no action is taken.
+func StartBalancer(ctx context.Context, mdl *Model, onPayment ...PaymentHandler) chan int64 {
+	// payment handler (if defined)
+	var payHdlr PaymentHandler = nopPayment
+	if len(onPayment) > 0 && onPayment[0] != nil {
+		payHdlr = onPayment[0]
+	}
 	// start background process
 	ch := make(chan int64)
-	running := make(map[int64]bool)
+	running := make(map[int64]int64) // start time of pending check
+	checked := make(map[int64]int64) // time of last successful check
+	hold := int64(mdl.cfg.BalanceHold)
+	stale := int64(mdl.cfg.StaleCheck)
+	if stale == 0 {
+		stale = defaultStaleCheck
+	}
+	var lock sync.Mutex
 	pid := 0
 	go func() {
 		for {
@@ -52,69 +116,141 @@ func StartBalancer(ctx context.Context, mdl *Model) chan int64 {
 					close(ch)
 					return
 				}
-				// ignore request for already pending address
-				if _, ok := running[ID]; ok {
+				// ignore request for already pending or recently checked address
+				lock.Lock()
+				now := time.Now().Unix()
+				if ts, ok := running[ID]; ok {
+					if now-ts < stale {
+						lock.Unlock()
+						break
+					}
+					logger.Printf(logger.WARN, "Balancer: stale check for address #%d (running for %ds) reset", ID, now-ts)
+				}
+				if ts, ok := checked[ID]; ok && now-ts < hold {
+					lock.Unlock()
+					logger.Printf(logger.DBG, "Balancer: address #%d checked recently", ID)
 					break
 				}
-				running[ID] = true
+				running[ID] = now
+				lock.Unlock()
+
+				// correlate check with triggering request (if any)
+				cctx := ctx
+				if id, ok := mdl.reqIDs.LoadAndDelete(ID); ok {
+					cctx = WithRequestID(ctx, id.(string))
+				}
 
 				// get address information
 				addr, coin, balance, rate, err := mdl.GetAddressInfo(ID)
 				if err != nil {
-					logger.Printf(logger.ERROR, "Balancer: can't retrieve address #%d", ID)
-					logger.Println(logger.ERROR, "=> "+err.Error())
+					Logf(cctx, logger.ERROR, "Balancer: can't retrieve address #%d", ID)
+					Logf(cctx, logger.ERROR, "=> %s", err.Error())
+					lock.Lock()
+					delete(running, ID)
+					lock.Unlock()
 					break
 				}
+				started := now
 				pid++
-				logger.Printf(logger.INFO, "Balancer[%d] update addr=%s (%f %s)...", pid, addr, balance, coin)
+				Logf(cctx, logger.INFO, "Balancer[%d] update addr=%s (%f %s)...", pid, addr, balance, coin)
 
 				// get new address balance
 				go func(pid int) {
-					flag := false
+					flag, ok, skip := false, false, false
 					defer func() {
-						mdl.NextUpdate(ID, flag)
-						delete(running, ID)
+						// keep schedule of addresses for unsupported coins (or if
+						// no blockchain handler is available)
+						if !skip {
+							mdl.NextUpdate(ID, flag)
+						}
+						lock.Lock()
+						// don't remove a newer check (if this one was stale)
+						if running[ID] == started {
+							delete(running, ID)
+						}
+						if ok && hold > 0 {
+							// remember check (and drop expired entries)
+							now := time.Now().Unix()
+							for id, ts := range checked {
+								if now-ts >= hold {
+									delete(checked, id)
+								}
+							}
+							checked[ID] = now
+						}
+						lock.Unlock()
 					}()
 					// get matching handler
-					hdlr, ok := HdlrList[coin]
-					if !ok {
-						logger.Printf(logger.ERROR, "Balancer[%d] No handler for '%s'", pid, coin)
+					hdlr, found := HdlrList[coin]
+					if !found {
+						Logf(cctx, logger.ERROR, "Balancer[%d] No handler for '%s'", pid, coin)
 						return
 					}
 					// perform balance check
-					newBalance, err := hdlr.GetBalance(ctx, addr)
+					newBalance, err := hdlr.GetBalance(cctx, addr)
+					if errors.Is(err, ErrHdlrUnavailable) {
+						// keep schedule: nothing was queried
+						skip = true
+						Logf(cctx, logger.DBG, "Balancer[%d] skipped: %s", pid, err.Error())
+						return
+					}
+					if errors.Is(err, ErrNotImplemented) {
+						skip = true
+						level := logger.DBG
+						if _, warned := unsupported.LoadOrStore(coin, true); !warned {
+							level = logger.WARN
+						}
+						Logf(cctx, level, "Balancer[%d] skipped: coin '%s' unsupported by blockchain handler", pid, coin)
+						return
+					}
+					countCheck(coin, err)
 					if err != nil {
-						logger.Printf(logger.ERROR, "Balancer[%d] sync failed: %s", pid, err.Error())
+						Logf(cctx, logger.ERROR, "Balancer[%d] sync failed: %s", pid, err.Error())
 						return
 					}
+					ok = true
 					// update balance if increased
 					diff := newBalance - balance
 					if diff < 1e-8 {
-						logger.Printf(logger.INFO, "Balancer[%d] unchanged balance (%f)", pid, balance)
+						Logf(cctx, logger.INFO, "Balancer[%d] unchanged balance (%f)", pid, balance)
 						return
 					}
-					logger.Printf(logger.INFO, "Balancer[%d] => new balance: %f", pid, newBalance)
+					Logf(cctx, logger.INFO, "Balancer[%d] => new balance: %f", pid, newBalance)
 					flag = true
 
 					// update balance in model
 					if err = mdl.UpdateBalance(ID, newBalance); err != nil {
-						logger.Printf(logger.ERROR, "Balancer[%d] update failed: %s", pid, err.Error())
+						Logf(cctx, logger.ERROR, "Balancer[%d] update failed: %s", pid, err.Error())
 						return
 					}
 					// record incoming funds
 					if err = mdl.Incoming(ID, diff); err != nil {
-						logger.Printf(logger.ERROR, "Balancer[%d] record incoming failed: %s", pid, err.Error())
+						Logf(cctx, logger.ERROR, "Balancer[%d] record incoming failed: %s", pid, err.Error())
 						return
 					}
-					// check if account limit is reached...
-					if hdlr.limit > 0 && hdlr.limit < balance*rate {
+					pay := &Payment{
+						AddrID:  ID,
+						Addr:    addr,
+						Coin:    coin,
+						Amount:  diff,
+						Balance: newBalance,
+						Rate:    rate,
+						Time:    time.Now().Unix(),
+					}
+					// check if account limit is reached (and the global minimum
+					// value for closing addresses) with the new balance...
+					value := newBalance * rate
+					if hdlr.limit > 0 && hdlr.limit < value && value >= mdl.cfg.MinClose {
 						// yes: close address
-						logger.Printf(logger.INFO, "Balancer[%d]: Closing address '%s' with balance=%f", pid, addr, newBalance)
+						Logf(cctx, logger.INFO, "Balancer[%d]: Closing address '%s' with balance=%f", pid, addr, newBalance)
 						if err = mdl.CloseAddress(ID); err != nil {
-							logger.Printf(logger.ERROR, "Balancer[%d] CloseAddress: %s", pid, err.Error())
-							return
+							Logf(cctx, logger.ERROR, "Balancer[%d] CloseAddress: %s", pid, err.Error())
+						} else {
+							pay.Closed = true
 						}
 					}
+					// custom reaction to payment
+					payHdlr.OnPayment(cctx, pay)
 				}(pid)
 
 			// cancel processor
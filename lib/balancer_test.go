@@ -0,0 +1,155 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stub blockchain handler with fixed balances (by address)
+type balanceChainHandler struct {
+	balances map[string]float64
+}
+
+func (hdlr *balanceChainHandler) Init(cfg *ChainHandlerConfig) {}
+
+func (hdlr *balanceChainHandler) Balance(ctx context.Context, addr, coin string) (float64, error) {
+	return hdlr.balances[addr], nil
+}
+
+func (hdlr *balanceChainHandler) GetFunds(ctx context.Context, addrId int64, addr, coin string) ([]*Fund, error) {
+	return nil, ErrNotImplemented
+}
+
+func TestBalancerClose(t *testing.T) {
+	mdl := newTestModel(t)
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label,rate) values(1,'btc','Bitcoin',1000)",
+		"insert into coin(id,symbol,label,rate) values(2,'ltc','Litecoin',1000)",
+		"insert into account(id,label,name) values(1,'test','Test')",
+		"insert into addr(id,coin,accnt,idx,val) values(1,1,1,0,'addr1')",
+		"insert into addr(id,coin,accnt,idx,val) values(2,2,1,0,'addr2')",
+	)
+	// a single payment of 0.2 coins (value 200) exceeds the limit (100) of
+	// both coins, but only the value for BTC reaches the minimum value for
+	// closing addresses (LTC has a higher minimum)
+	chain := &balanceChainHandler{balances: map[string]float64{"addr1": 0.2, "addr2": 0.2}}
+	for _, symb := range []string{"btc", "ltc"} {
+		HdlrList[symb] = &Handler{
+			symb:   symb,
+			limit:  100,
+			chains: []*chainBackend{{name: "stub", chain: chain}},
+		}
+		defer delete(HdlrList, symb)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pays := make(chan *Payment, 2)
+	ch := StartBalancer(ctx, mdl, PaymentFunc(func(_ context.Context, p *Payment) {
+		pays <- p
+	}))
+	check := func(ID int64, minClose float64, closed bool) {
+		t.Helper()
+		mdl.cfg.MinClose = minClose
+		ch <- ID
+		var p *Payment
+		select {
+		case p = <-pays:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no payment for address #%d", ID)
+		}
+		if p.AddrID != ID || p.Amount != 0.2 || p.Closed != closed {
+			t.Fatalf("address #%d: amount %f, closed %v; want 0.2/%v", ID, p.Amount, p.Closed, closed)
+		}
+		var stat int
+		if err := mdl.inst.QueryRow("select stat from addr where id=?", ID).Scan(&stat); err != nil {
+			t.Fatal(err)
+		}
+		if (stat == 1) != closed {
+			t.Fatalf("address #%d: status %d", ID, stat)
+		}
+	}
+	check(1, 150, true)
+	check(2, 500, false)
+}
+
+func TestBalancerBreakerOpen(t *testing.T) {
+	mdl := newTestModel(t)
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label,rate) values(1,'btc','Bitcoin',1000)",
+		"insert into account(id,label,name) values(1,'test','Test')",
+		"insert into addr(id,coin,accnt,idx,val,waitCheck,nextCheck) values(1,1,1,0,'addr1',300,1000)",
+	)
+	// rescheduling of an address is counted
+	var updates atomic.Int32
+	mdl.SetWaitFactor(func(f float64) float64 {
+		updates.Add(1)
+		return f
+	})
+	// blockchain handler with open circuit breaker (signals every check
+	// of the breaker)
+	checks := make(chan struct{}, 10)
+	b := newBreaker("stub", 1, 3600)
+	b.Record(errors.New("failed"))
+	b.now = func() time.Time {
+		checks <- struct{}{}
+		return time.Now()
+	}
+	chain := &balanceChainHandler{balances: map[string]float64{"addr1": 0.2}}
+	HdlrList["btc"] = &Handler{
+		symb:   "btc",
+		chains: []*chainBackend{{name: "stub", chain: chain, breaker: b}},
+	}
+	defer delete(HdlrList, "btc")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := StartBalancer(ctx, mdl)
+
+	// request balance checks until the second check is running (so the
+	// first check has finished)
+	deadline := time.After(5 * time.Second)
+	for n := 0; n < 2; {
+		ch <- 1
+		select {
+		case <-checks:
+			n++
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no balance check")
+		}
+	}
+	// address schedule is unchanged
+	if n := updates.Load(); n != 0 {
+		t.Fatalf("address rescheduled %d times", n)
+	}
+	var wait, next int64
+	if err := mdl.inst.QueryRow("select waitCheck,nextCheck from addr where id=1").Scan(&wait, &next); err != nil {
+		t.Fatal(err)
+	}
+	if wait != 300 || next != 1000 {
+		t.Fatalf("wait=%d, next=%d; want 300/1000", wait, next)
+	}
+}
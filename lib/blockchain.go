@@ -22,14 +22,19 @@ package lib
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bfix/gospel/logger"
 	"github.com/bfix/gospel/network"
 )
 
@@ -46,6 +51,31 @@ type ChainHandler interface {
 	GetFunds(ctx context.Context, addrId int64, addr, coin string) ([]*Fund, error)
 }
 
+// ErrNotImplemented is returned by blockchain handlers for coins (or
+// operations) they don't support. It must be used instead of returning
+// zero values, so a missing implementation is not mistaken for an
+// address without funds.
+var ErrNotImplemented = fmt.Errorf("not implemented by blockchain handler")
+
+// CoinSupporter is implemented by blockchain handlers that only support
+// a subset of coins.
+type CoinSupporter interface {
+	Supports(coin string) bool
+}
+
+// UnconfirmedHandler is implemented by blockchain handlers that can report
+// unconfirmed (mempool) funds on an address.
+type UnconfirmedHandler interface {
+	Unconfirmed(ctx context.Context, addr, coin string) (float64, error)
+}
+
+// IsUnconfirmedHandler returns true if a shared blockchain handler with
+// given name can report unconfirmed funds.
+func IsUnconfirmedHandler(name string) bool {
+	_, ok := baseChainHdlrs[name].(UnconfirmedHandler)
+	return ok || IsElectrumHandler(name)
+}
+
 //----------------------------------------------------------------------
 // Basic chain handlers are generic stand-alone handlers for a coin
 //----------------------------------------------------------------------
@@ -54,6 +84,7 @@ type ChainHandler interface {
 type BasicChainHandler struct {
 	ratelimiter *network.RateLimiter
 	apiKey      string
+	paging      *paging
 	lock        sync.Mutex
 }
 
@@ -61,6 +92,51 @@ type BasicChainHandler struct {
 func (hdlr *BasicChainHandler) Init(cfg *ChainHandlerConfig) {
 	hdlr.ratelimiter = network.NewRateLimiter(cfg.RateLimits...)
 	hdlr.apiKey = cfg.ApiKey
+	hdlr.paging = newPaging(cfg, 20, 100)
+}
+
+//----------------------------------------------------------------------
+// Paging of transaction lists (listing funds)
+//----------------------------------------------------------------------
+
+// maximum number of transactions retrieved for an address (safety cap)
+const maxFundTxs = 10000
+
+// Error codes
+var (
+	ErrChainTooManyTxs = fmt.Errorf("too many transactions for address")
+)
+
+// paging settings of a chain handler
+type paging struct {
+	size  int // number of transactions per request
+	pages int // max. number of requests (0 = unlimited)
+}
+
+// newPaging returns the paging settings from the handler configuration.
+// The page size defaults to 'def' and can't exceed 'max' (limit of the
+// service).
+func newPaging(cfg *ChainHandlerConfig, def, max int) *paging {
+	p := &paging{size: cfg.PageSize, pages: cfg.MaxPages}
+	if p.size <= 0 {
+		p.size = def
+	}
+	p.size = min(p.size, max)
+	return p
+}
+
+// Next returns true if another page can be requested: 'page' is the
+// number of pages retrieved so far, 'count' the number of transactions.
+// Returns an error if the safety cap is exceeded.
+func (p *paging) Next(page, count int) (bool, error) {
+	if count >= maxFundTxs {
+		return false, ErrChainTooManyTxs
+	}
+	if p.pages > 0 && page >= p.pages {
+		logger.Printf(logger.WARN, "Listing funds stopped after %d pages (%d transactions)", page, count)
+		return false, nil
+	}
+	return true, nil
 }
 
 //======================================================================
@@ -75,9 +151,28 @@ var (
 		"btgexplorer.com": new(BtgChainHandler),
 		"zcha.in":         new(ZecChainHandler),
 		"blockscout.com":  new(EtcChainHandler),
+		"mempool.space":   new(MempoolChainHandler),
 	}
 )
 
+// IsChainHandler returns true if a shared blockchain handler with given
+// name exists.
+func IsChainHandler(name string) bool {
+	_, ok := baseChainHdlrs[name]
+	return ok || IsElectrumHandler(name)
+}
+
+// get shared blockchain handler by name (Electrum handlers are created
+// on first use)
+func chainHandler(name string) (ChainHandler, bool) {
+	hdlr, ok := baseChainHdlrs[name]
+	if !ok && IsElectrumHandler(name) {
+		hdlr, ok = new(ElectrumChainHandler), true
+		baseChainHdlrs[name] = hdlr
+	}
+	return hdlr, ok
+}
+
 //----------------------------------------------------------------------
 // (chainz.cryptoid.info)
 //----------------------------------------------------------------------
@@ -162,7 +257,7 @@ func (hdlr *CciChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 		if hdlr.apiKey != "" {
 			query += fmt.Sprintf("?key=%s", hdlr.apiKey)
 		}
-		if body, err = HTTPQuery(context.Background(), query); err != nil {
+		if body, err = HTTPQuery(ctx, query); err != nil {
 			return nil, err
 		}
 		// parse response
@@ -177,6 +272,7 @@ func (hdlr *CciChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 					Seen:   tx.Timestamp,
 					Addr:   addrId,
 					Amount: vout.Amount,
+					Script: scriptTypeFromHex(vout.Script),
 				}
 				funds = append(funds, f)
 			}
@@ -236,6 +332,7 @@ type CciTxInfo struct {
 type BcChainHandler struct {
 	ratelimiter *network.RateLimiter // limit calls to service
 	apiKey      string               // optional API key
+	paging      *paging              // paging of transaction lists
 	initialized bool                 // handler set-up?
 	lock        sync.Mutex           // serialize operations
 }
@@ -247,6 +344,7 @@ func (hdlr *BcChainHandler) Init(cfg *ChainHandlerConfig) {
 		hdlr.initialized = true
 		hdlr.ratelimiter = network.NewRateLimiter(cfg.RateLimits...)
 		hdlr.apiKey = cfg.ApiKey
+		hdlr.paging = newPaging(cfg, 1, 10)
 	}
 }
 
@@ -300,6 +398,12 @@ func (hdlr *BcChainHandler) query(ctx context.Context, addr, coin string) (*Bloc
 	if data.Context.Code != 200 {
 		return nil, fmt.Errorf("HTTP response %d", data.Context.Code)
 	}
+	// address keys may differ in case (ETH-like addresses)
+	for key, val := range data.Data {
+		if key != addr && SameAddress(key, addr) {
+			data.Data[addr] = val
+		}
+	}
 	return data, nil
 }
 
@@ -334,12 +438,24 @@ func (hdlr *BcChainHandler) GetFunds(ctx context.Context, addrId int64, addr, co
 	if !ok {
 		c = coin
 	}
-	// collect funding transactions
+	// collect funding transactions (in batches of transactions)
 	funds := make([]*Fund, 0)
-	for _, txHash := range data.Data[addr].Transactions {
+	txHashes := data.Data[addr].Transactions
+	for page, pos := 0, 0; pos < len(txHashes); page++ {
+		if next, err := hdlr.paging.Next(page, pos); err != nil {
+			return nil, err
+		} else if !next {
+			break
+		}
+		batch := txHashes[pos:min(pos+hdlr.paging.size, len(txHashes))]
+		pos += len(batch)
+
 		// perform query
 		hdlr.ratelimiter.Pass()
-		query := fmt.Sprintf("https://api.blockchair.com/%s/dashboards/transaction/%s", c, txHash)
+		query := fmt.Sprintf("https://api.blockchair.com/%s/dashboards/transaction/%s", c, batch[0])
+		if len(batch) > 1 {
+			query = fmt.Sprintf("https://api.blockchair.com/%s/dashboards/transactions/%s", c, strings.Join(batch, ","))
+		}
 		if hdlr.apiKey != "" {
 			query += fmt.Sprintf("?key=%s", hdlr.apiKey)
 		}
@@ -352,20 +468,24 @@ func (hdlr *BcChainHandler) GetFunds(ctx context.Context, addrId int64, addr, co
 		if err = json.Unmarshal(body, &rec); err != nil {
 			return nil, err
 		}
-		tx := rec.Data[txHash]
-		// find received funds in transaction outputs
-		for _, vout := range tx.Outputs {
-			if addr == vout.Recipient {
-				ts, err := time.Parse("2006-01-02 15:04:05", vout.Time)
-				if err != nil {
-					return nil, err
-				}
-				f := &Fund{
-					Seen:   ts.Unix(),
-					Addr:   addrId,
-					Amount: float64(vout.Value) / 1e8,
+		for _, txHash := range batch {
+			tx := rec.Data[txHash]
+			// find received funds in transaction outputs
+			for _, vout := range tx.Outputs {
+				if SameAddress(addr, vout.Recipient) {
+					ts, err := time.Parse("2006-01-02 15:04:05", vout.Time)
+					if err != nil {
+						return nil, err
+					}
+					f := &Fund{
+						Seen:     ts.Unix(),
+						Addr:     addrId,
+						Amount:   float64(vout.Value) / 1e8,
+						Script:   scriptTypes[vout.Type],
+						Coinbase: tx.Transaction.IsCoinbase || vout.FromCoinbase,
+					}
+					funds = append(funds, f)
 				}
-				funds = append(funds, f)
 			}
 		}
 	}
@@ -528,6 +648,29 @@ func (hdlr *BtgChainHandler) Balance(ctx context.Context, addr, coin string) (fl
 	return val, nil
 }
 
+// Unconfirmed returns the unconfirmed balance of a Bitcoin Gold address.
+func (hdlr *BtgChainHandler) Unconfirmed(ctx context.Context, addr, coin string) (float64, error) {
+	// only handle one call at a time
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+
+	// perform query
+	hdlr.ratelimiter.Pass()
+	query := fmt.Sprintf("https://btgexplorer.com/api/address/%s", addr)
+	body, err := HTTPQuery(ctx, query)
+	if err != nil {
+		return -1, err
+	}
+	data := new(BtgAddrInfo)
+	if err = json.Unmarshal(body, &data); err != nil {
+		return -1, err
+	}
+	if len(data.UnconfirmedBalance) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(data.UnconfirmedBalance, 64)
+}
+
 // GetFunds returns incoming transaction for a Bitcoin Gold address.
 func (hdlr *BtgChainHandler) GetFunds(ctx context.Context, addrId int64, addr, coin string) ([]*Fund, error) {
 	// only handle one call at a time
@@ -561,6 +704,7 @@ func (hdlr *BtgChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 		}
 		// find received funds in transaction outputs
 		for _, tx := range data {
+			coinbase := len(tx.Vin) > 0 && len(tx.Vin[0].Coinbase) > 0
 			for _, vout := range tx.Vout {
 				val, err := strconv.ParseFloat(vout.Value, 64)
 				if err != nil {
@@ -569,9 +713,11 @@ func (hdlr *BtgChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 				for _, a := range vout.ScriptPubKey.Addresses {
 					if addr == a {
 						f := &Fund{
-							Seen:   tx.Time,
-							Addr:   addrId,
-							Amount: val,
+							Seen:     tx.Time,
+							Addr:     addrId,
+							Amount:   val,
+							Script:   scriptTypeFromHex(vout.ScriptPubKey.Hex),
+							Coinbase: coinbase,
 						}
 						funds = append(funds, f)
 					}
@@ -621,6 +767,7 @@ type BtgTxInfo struct {
 
 // BtgTxVin is an input slot
 type BtgTxVin struct {
+	Coinbase  string `json:"coinbase"`
 	TxID      string `json:"txid"`
 	Vout      int    `json:"vout"`
 	Sequence  int32  `json:"sequence"`
@@ -645,6 +792,178 @@ type BtgTxVout struct {
 	Spent bool `json:"spent"`
 }
 
+//======================================================================
+// BTC (Bitcoin) via mempool.space
+//======================================================================
+
+// MempoolChainHandler handles Bitcoin-related blockchain operations
+// (mempool.space Esplora API)
+type MempoolChainHandler struct {
+	BasicChainHandler
+}
+
+// number of confirmed transactions per page (fixed by mempool.space)
+const mempoolPageSize = 25
+
+// Init a new chain handler instance
+func (hdlr *MempoolChainHandler) Init(cfg *ChainHandlerConfig) {
+	hdlr.BasicChainHandler.Init(cfg)
+	hdlr.paging = newPaging(cfg, mempoolPageSize, mempoolPageSize)
+}
+
+// Supports returns true for Bitcoin only.
+func (hdlr *MempoolChainHandler) Supports(coin string) bool {
+	return coin == "btc"
+}
+
+// query address information
+func (hdlr *MempoolChainHandler) query(ctx context.Context, addr string) (*MempoolAddrInfo, error) {
+	// only handle one call at a time
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+
+	// perform query
+	hdlr.ratelimiter.Pass()
+	query := fmt.Sprintf("https://mempool.space/api/address/%s", addr)
+	body, err := HTTPQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	data := new(MempoolAddrInfo)
+	if err = json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Balance gets the balance of a Bitcoin address (total amount received
+// in confirmed transactions)
+func (hdlr *MempoolChainHandler) Balance(ctx context.Context, addr, coin string) (float64, error) {
+	data, err := hdlr.query(ctx, addr)
+	if err != nil {
+		return -1, err
+	}
+	return float64(data.ChainStats.FundedSum) / 1e8, nil
+}
+
+// Unconfirmed returns the unconfirmed balance of a Bitcoin address.
+func (hdlr *MempoolChainHandler) Unconfirmed(ctx context.Context, addr, coin string) (float64, error) {
+	data, err := hdlr.query(ctx, addr)
+	if err != nil {
+		return -1, err
+	}
+	return float64(data.MempoolStats.FundedSum) / 1e8, nil
+}
+
+// GetFunds returns incoming transaction for a Bitcoin address.
+func (hdlr *MempoolChainHandler) GetFunds(ctx context.Context, addrId int64, addr, coin string) ([]*Fund, error) {
+	// only handle one call at a time
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+
+	// retrieve list of confirmed transactions in chunks (newest first;
+	// the next chunk starts after the last transaction seen)
+	funds := make([]*Fund, 0)
+	last, count := "", 0
+	for page := 0; ; page++ {
+		if next, err := hdlr.paging.Next(page, count); err != nil {
+			return nil, err
+		} else if !next {
+			break
+		}
+		// perform query
+		hdlr.ratelimiter.Pass()
+		query := fmt.Sprintf("https://mempool.space/api/address/%s/txs/chain", addr)
+		if len(last) > 0 {
+			query += "/" + last
+		}
+		body, err := HTTPQuery(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]*MempoolTx, 0)
+		if err = json.Unmarshal(body, &data); err != nil {
+			return nil, err
+		}
+		// find received funds in transaction outputs
+		for _, tx := range data {
+			coinbase := len(tx.Vin) > 0 && tx.Vin[0].Coinbase
+			for _, vout := range tx.Vout {
+				if addr == vout.Address {
+					f := &Fund{
+						Seen:     tx.Status.BlockTime,
+						Addr:     addrId,
+						Amount:   float64(vout.Value) / 1e8,
+						Script:   scriptTypes[vout.Type],
+						Coinbase: coinbase,
+					}
+					funds = append(funds, f)
+				}
+			}
+		}
+		// address next chunk
+		n := len(data)
+		if n < mempoolPageSize {
+			break
+		}
+		count += n
+		last = data[n-1].TxID
+	}
+	// return funds
+	return funds, nil
+}
+
+//----------------------------------------------------------------------
+// internal access helpers
+//----------------------------------------------------------------------
+
+// MempoolStats are address statistics (amounts in satoshi)
+type MempoolStats struct {
+	FundedCount int   `json:"funded_txo_count"`
+	FundedSum   int64 `json:"funded_txo_sum"`
+	SpentCount  int   `json:"spent_txo_count"`
+	SpentSum    int64 `json:"spent_txo_sum"`
+	TxCount     int   `json:"tx_count"`
+}
+
+// MempoolAddrInfo is the response from the mempool.space API for an
+// address query
+type MempoolAddrInfo struct {
+	Address      string        `json:"address"`
+	ChainStats   *MempoolStats `json:"chain_stats"`
+	MempoolStats *MempoolStats `json:"mempool_stats"`
+}
+
+// MempoolTx represents a Bitcoin transaction
+type MempoolTx struct {
+	TxID     string `json:"txid"`
+	Version  int    `json:"version"`
+	LockTime int64  `json:"locktime"`
+	Vin      []struct {
+		TxID     string `json:"txid"`
+		Vout     int    `json:"vout"`
+		Coinbase bool   `json:"is_coinbase"`
+		Sequence uint32 `json:"sequence"`
+	} `json:"vin"`
+	Vout   []*MempoolTxVout `json:"vout"`
+	Size   int              `json:"size"`
+	Fee    int64            `json:"fee"`
+	Status struct {
+		Confirmed   bool   `json:"confirmed"`
+		BlockHeight int    `json:"block_height"`
+		BlockHash   string `json:"block_hash"`
+		BlockTime   int64  `json:"block_time"`
+	} `json:"status"`
+}
+
+// MempoolTxVout is an output slot (value in satoshi)
+type MempoolTxVout struct {
+	Script  string `json:"scriptpubkey"`
+	Type    string `json:"scriptpubkey_type"`
+	Address string `json:"scriptpubkey_address"`
+	Value   int64  `json:"value"`
+}
+
 //======================================================================
 // ETC (Ethereum Classic)
 //======================================================================
@@ -675,11 +994,7 @@ func (hdlr *EtcChainHandler) Balance(ctx context.Context, addr, coin string) (fl
 	if data.Result == nil {
 		return -1, err
 	}
-	val, err := strconv.ParseInt(*data.Result, 10, 64)
-	if err != nil {
-		return -1, err
-	}
-	return float64(val) / 1e18, nil
+	return weiToCoin(*data.Result)
 }
 
 // GetFunds returns incoming transaction for an Ethereum address.
@@ -706,14 +1021,14 @@ func (hdlr *EtcChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 		if err != nil {
 			continue
 		}
-		val, err := strconv.ParseInt(tx.Value, 10, 64)
+		val, err := weiToCoin(tx.Value)
 		if err != nil {
 			continue
 		}
 		f := &Fund{
 			Seen:   ts,
 			Addr:   addrId,
-			Amount: float64(val) / 1e18,
+			Amount: val,
 		}
 		funds = append(funds, f)
 	}
@@ -745,7 +1060,7 @@ type EtcTxInfo struct {
 		Input           string `json:"input"`
 		IsError         string `json:"isError"`
 		None            string `json:"nonce"`
-		Timestamp       string `jspn:"timeStamp"`
+		Timestamp       string `json:"timeStamp"`
 		To              string `json:"to"`
 		TxIndex         string `json:"transactionIndex"`
 		TxReceipt       string `json:"txreceipt_status"`
@@ -754,6 +1069,17 @@ type EtcTxInfo struct {
 	Status string `json:"status"`
 }
 
+// weiToCoin converts an amount in Wei (decimal string) to coins (18
+// decimals). Amounts can exceed the range of int64 (about 9.2 coins).
+func weiToCoin(wei string) (float64, error) {
+	val, ok := new(big.Float).SetString(wei)
+	if !ok {
+		return -1, fmt.Errorf("invalid amount '%s'", wei)
+	}
+	coins, _ := val.Quo(val, big.NewFloat(1e18)).Float64()
+	return coins, nil
+}
+
 //======================================================================
 // ZEC (ZCash)
 //======================================================================
@@ -793,13 +1119,18 @@ func (hdlr *ZecChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 	// retrieve list of transactions in chunks
 	funds := make([]*Fund, 0)
 	offset := 0
-	for {
+	for page := 0; ; page++ {
+		if next, err := hdlr.paging.Next(page, offset); err != nil {
+			return nil, err
+		} else if !next {
+			break
+		}
 		// perform query
 		hdlr.ratelimiter.Pass()
 		query := fmt.Sprintf(
 			"https://api.zcha.in/v2/mainnet/accounts/%s/recv"+
-				"?limit=20&offset=%d&sort=timestamp&direction=ascending",
-			addr, offset)
+				"?limit=%d&offset=%d&sort=timestamp&direction=ascending",
+			addr, hdlr.paging.size, offset)
 		body, err := HTTPQuery(ctx, query)
 		if err != nil {
 			return nil, err
@@ -810,13 +1141,16 @@ func (hdlr *ZecChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 		}
 		// find received funds in transaction outputs
 		for _, tx := range data {
+			coinbase := len(tx.Vin) > 0 && len(tx.Vin[0].Coinbase) > 0
 			for _, vout := range tx.Vout {
 				for _, a := range vout.ScriptPubKey.Addresses {
 					if addr == a {
 						f := &Fund{
-							Seen:   tx.Timestamp,
-							Addr:   addrId,
-							Amount: tx.Value,
+							Seen:     tx.Timestamp,
+							Addr:     addrId,
+							Amount:   tx.Value,
+							Script:   scriptTypes[vout.ScriptPubKey.Type],
+							Coinbase: coinbase,
 						}
 						funds = append(funds, f)
 					}
@@ -825,7 +1159,7 @@ func (hdlr *ZecChainHandler) GetFunds(ctx context.Context, addrId int64, addr, c
 		}
 		// address next chunk
 		n := len(data)
-		if n < 20 {
+		if n < hdlr.paging.size {
 			break
 		}
 		offset += n
@@ -908,22 +1242,212 @@ type ZecTxVout struct {
 // Helper functions
 //----------------------------------------------------------------------
 
-func HTTPQuery(ctx context.Context, query string) ([]byte, error) {
+// script types (as used by blockchain services) mapped to address modes
+var scriptTypes = map[string]string{
+	"pubkeyhash":            "P2PKH",
+	"scripthash":            "P2SH",
+	"witness_v0_keyhash":    "P2WPKH",
+	"witness_v0_scripthash": "P2WSH",
+	"witness_v1_taproot":    "P2TR",
+	"p2pkh":                 "P2PKH",
+	"p2sh":                  "P2SH",
+	"v0_p2wpkh":             "P2WPKH",
+	"v0_p2wsh":              "P2WSH",
+	"v1_p2tr":               "P2TR",
+}
+
+// scriptTypeFromHex returns the script type (address mode) of an output
+// script in hex representation (or an empty string if unknown).
+func scriptTypeFromHex(script string) string {
+	script = strings.ToLower(script)
+	switch {
+	case len(script) == 50 && strings.HasPrefix(script, "76a914") && strings.HasSuffix(script, "88ac"):
+		return "P2PKH"
+	case len(script) == 46 && strings.HasPrefix(script, "a914") && strings.HasSuffix(script, "87"):
+		return "P2SH"
+	case len(script) == 44 && strings.HasPrefix(script, "0014"):
+		return "P2WPKH"
+	case len(script) == 68 && strings.HasPrefix(script, "0020"):
+		return "P2WSH"
+	case len(script) == 68 && strings.HasPrefix(script, "5120"):
+		return "P2TR"
+	}
+	return ""
+}
+
+//----------------------------------------------------------------------
+// HTTP clients
+//----------------------------------------------------------------------
+
+// HTTPClient used for queries (with request timeout)
+type HTTPClient struct {
+	cl       *http.Client
+	timeout  time.Duration
+	attempts int // max. attempts per query (transient failures)
+}
+
+// shared default client
+var defaultClient = &HTTPClient{
+	cl:       &http.Client{},
+	timeout:  time.Minute,
+	attempts: 3,
+}
+
+// NewHTTPClient creates a client from configuration. Returns the shared
+// default client if no configuration is given.
+func NewHTTPClient(cfg *HTTPConfig) (*HTTPClient, error) {
+	if cfg == nil {
+		return defaultClient, nil
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Insecure {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if len(cfg.Proxy) > 0 {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(proxy)
+	}
+	client := &HTTPClient{
+		cl:       &http.Client{Transport: tr},
+		timeout:  defaultClient.timeout,
+		attempts: defaultClient.attempts,
+	}
+	if cfg.Timeout > 0 {
+		client.timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	if cfg.Attempts > 0 {
+		client.attempts = cfg.Attempts
+	}
+	return client, nil
+}
+
+// context key for HTTP client
+type httpClientKey struct{}
+
+// WithHTTPClient returns a context that makes HTTPQuery use the given client.
+func WithHTTPClient(ctx context.Context, client *HTTPClient) context.Context {
+	if client == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, httpClientKey{}, client)
+}
+
+// HTTPStatusError is returned by HTTPQuery for responses with a status
+// other than 2xx; the response body is available to the caller.
+type HTTPStatusError struct {
+	Code   int    // HTTP status code
+	Status string // HTTP status line (like "404 Not Found")
+	Body   []byte // response body
+}
+
+// Error returns a human-readable error message.
+func (e *HTTPStatusError) Error() string {
+	return "HTTP " + e.Status
+}
+
+// maximum wait time between attempts of a query; a longer 'Retry-After'
+// of a service is not honored (the query fails).
+const maxRetryWait = time.Minute
+
+// initial wait time between attempts of a query (doubled after each
+// attempt)
+var retryWait = time.Second
+
+// HTTPQuery performs a GET request and returns the response body. The
+// HTTP client is taken from the context (shared default client if not set).
+// Transient failures (network errors and HTTP status 429, 500, 502, 503
+// and 504) are retried with exponential backoff (or the delay requested by
+// the service in 'Retry-After'); other responses are returned immediately.
+// A response with a status other than 2xx is returned as HTTPStatusError
+// (the body is returned as well).
+func HTTPQuery(ctx context.Context, query string) (body []byte, err error) {
+	// trace query
+	ctx, span := StartSpan(ctx, "http.query", Attr("endpoint", endpoint(query)))
+	defer func() { span.End(err) }()
+	defer observeQuery(ctx, time.Now())
+
+	client, ok := ctx.Value(httpClientKey{}).(*HTTPClient)
+	if !ok {
+		client = defaultClient
+	}
+	wait := retryWait
+	for try := 1; ; try++ {
+		var retry time.Duration
+		if body, retry, err = client.query(ctx, query); err == nil || retry < 0 {
+			return
+		}
+		if try >= max(client.attempts, 1) {
+			return
+		}
+		// wait before next attempt
+		if retry == 0 {
+			retry = wait
+			wait *= 2
+		}
+		if retry > maxRetryWait {
+			return
+		}
+		logger.Printf(logger.DBG, "[http] %s failed (retry in %s): %s", endpoint(query), retry, err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// perform a single GET request. Returns the delay before the next attempt
+// on transient failures (0 = default backoff) or -1 if the query must not
+// be retried.
+func (client *HTTPClient) query(ctx context.Context, query string) ([]byte, time.Duration, error) {
 	// time-out HTTP client
-	toCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	toCtx, cancel := context.WithTimeout(ctx, client.timeout)
 	defer cancel()
-	cl := &http.Client{}
 
 	// request information
 	req, err := http.NewRequestWithContext(toCtx, http.MethodGet, query, nil)
 	if err != nil {
-		return nil, err
+		return nil, -1, err
 	}
-	resp, err := cl.Do(req)
+	resp, err := client.cl.Do(req)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, -1, err
+		}
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
-	// read and parse response
-	return io.ReadAll(resp.Body)
+	// read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return body, -1, nil
+	}
+	err = &HTTPStatusError{Code: resp.StatusCode, Status: resp.Status, Body: body}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return body, retryAfter(resp.Header.Get("Retry-After")), err
+	}
+	return body, -1, err
+}
+
+// get delay from 'Retry-After' header (seconds or HTTP date); returns 0
+// if not set or invalid.
+func retryAfter(val string) time.Duration {
+	if len(val) == 0 {
+		return 0
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
 }
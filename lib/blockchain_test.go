@@ -0,0 +1,247 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirect all requests of a client to a test server
+type testTransport struct {
+	srv *httptest.Server
+}
+
+func (tr *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = tr.srv.Listener.Addr().String()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// context with an HTTP client that sends all queries to a test handler
+func testHTTPContext(t *testing.T, hdlr http.HandlerFunc) context.Context {
+	return testRetryContext(t, 1, hdlr)
+}
+
+// context with an HTTP client that sends all queries to a test handler
+// (with given number of attempts per query)
+func testRetryContext(t *testing.T, attempts int, hdlr http.HandlerFunc) context.Context {
+	t.Helper()
+	srv := httptest.NewServer(hdlr)
+	t.Cleanup(srv.Close)
+	client := &HTTPClient{
+		cl:       &http.Client{Transport: &testTransport{srv}},
+		timeout:  defaultClient.timeout,
+		attempts: attempts,
+	}
+	return WithHTTPClient(context.Background(), client)
+}
+
+func TestEtcAmounts(t *testing.T) {
+	ctx := testHTTPContext(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("action") {
+		case "balance":
+			// 12.3456789 ETC (exceeds int64 in Wei)
+			w.Write([]byte(`{"message":"OK","result":"12345678900000000000","status":"1"}`))
+		case "txlist":
+			w.Write([]byte(`{"message":"OK","status":"1","result":[
+				{"hash":"0x01","timeStamp":"1700000000","value":"1500000000000000000"},
+				{"hash":"0x02","timeStamp":"1700000600","value":"25000000000000000000"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	hdlr := new(EtcChainHandler)
+	hdlr.Init(&ChainHandlerConfig{})
+
+	bal, err := hdlr.Balance(ctx, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(bal-12.3456789) > 1e-9 {
+		t.Errorf("balance %f, want 12.3456789", bal)
+	}
+	funds, err := hdlr.GetFunds(ctx, 1, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(funds) != 2 {
+		t.Fatalf("got %d funds, want 2", len(funds))
+	}
+	for i, want := range []struct {
+		seen   int64
+		amount float64
+	}{{1700000000, 1.5}, {1700000600, 25}} {
+		if f := funds[i]; f.Seen != want.seen || math.Abs(f.Amount-want.amount) > 1e-9 {
+			t.Errorf("fund %d: seen=%d, amount=%f; want %d, %f", i, f.Seen, f.Amount, want.seen, want.amount)
+		}
+	}
+}
+
+func TestPagingCap(t *testing.T) {
+	const addr = "t1Zx9aVJ2ctybCQoe6Jjvvo8TTo8HqTrtUk"
+	// service always returns full pages of transactions
+	requests := 0
+	ctx := testHTTPContext(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		txs := make([]string, limit)
+		for i := range txs {
+			txs[i] = fmt.Sprintf(`{"timestamp":1700000000,"value":1,"vout":[{"scriptPubKey":{"addresses":["%s"]}}]}`, addr)
+		}
+		w.Write([]byte("[" + strings.Join(txs, ",") + "]"))
+	})
+	// listing stops after the configured number of pages
+	hdlr := new(ZecChainHandler)
+	hdlr.Init(&ChainHandlerConfig{PageSize: 10, MaxPages: 3})
+	funds, err := hdlr.GetFunds(ctx, 1, addr, "zec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || len(funds) != 30 {
+		t.Fatalf("%d requests, %d funds; want 3/30", requests, len(funds))
+	}
+	// unlimited listing stops at the safety cap
+	requests = 0
+	hdlr.Init(&ChainHandlerConfig{PageSize: 100})
+	if _, err = hdlr.GetFunds(ctx, 1, addr, "zec"); !errors.Is(err, ErrChainTooManyTxs) {
+		t.Fatalf("got error %v, want %v", err, ErrChainTooManyTxs)
+	}
+	if requests != maxFundTxs/100 {
+		t.Fatalf("%d requests, want %d", requests, maxFundTxs/100)
+	}
+}
+
+func TestHTTPRetry(t *testing.T) {
+	// short backoff for tests
+	defer func(d time.Duration) { retryWait = d }(retryWait)
+	retryWait = time.Millisecond
+
+	// service responds with given status codes (last one repeated)
+	serve := func(codes ...int) (context.Context, *atomic.Int32) {
+		calls := new(atomic.Int32)
+		ctx := testRetryContext(t, 3, func(w http.ResponseWriter, r *http.Request) {
+			code := codes[min(int(calls.Add(1))-1, len(codes)-1)]
+			w.WriteHeader(code)
+			fmt.Fprintf(w, "status %d", code)
+		})
+		return ctx, calls
+	}
+	// transient failures are retried
+	ctx, calls := serve(503, 429, 200)
+	body, err := HTTPQuery(ctx, "https://api.test/query")
+	if err != nil || string(body) != "status 200" || calls.Load() != 3 {
+		t.Fatalf("got '%s' (%v) after %d calls, want success after 3", body, err, calls.Load())
+	}
+	// number of attempts is limited
+	ctx, calls = serve(502)
+	body, err = HTTPQuery(ctx, "https://api.test/query")
+	var se *HTTPStatusError
+	if !errors.As(err, &se) || se.Code != 502 || calls.Load() != 3 {
+		t.Fatalf("got error %v after %d calls, want HTTP 502 after 3", err, calls.Load())
+	}
+	if string(body) != "status 502" {
+		t.Fatalf("got body '%s'", body)
+	}
+	// other failures are returned immediately (with body)
+	ctx, calls = serve(404)
+	body, err = HTTPQuery(ctx, "https://api.test/query")
+	if !errors.As(err, &se) || se.Code != 404 || calls.Load() != 1 {
+		t.Fatalf("got error %v after %d calls, want HTTP 404 after 1", err, calls.Load())
+	}
+	if string(body) != "status 404" || string(se.Body) != "status 404" {
+		t.Fatalf("got body '%s'", body)
+	}
+}
+
+func TestHTTPRetryAfter(t *testing.T) {
+	defer func(d time.Duration) { retryWait = d }(retryWait)
+	retryWait = time.Millisecond
+
+	// service requests a delay before the next attempt
+	var (
+		times []time.Time
+		lock  sync.Mutex
+	)
+	ctx := testRetryContext(t, 2, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		times = append(times, time.Now())
+		first := len(times) == 1
+		lock.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	if _, err := HTTPQuery(ctx, "https://api.test/query"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(times) != 2 || times[1].Sub(times[0]) < time.Second {
+		t.Fatalf("retry after %s, want 1s", times[len(times)-1].Sub(times[0]))
+	}
+	// delays beyond the maximum wait time are not honored (query fails)
+	var calls atomic.Int32
+	ctx = testRetryContext(t, 2, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(2*maxRetryWait/time.Second)))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if _, err := HTTPQuery(ctx, "https://api.test/query"); err == nil || calls.Load() != 1 {
+		t.Fatalf("got error %v after %d calls, want failure after 1", err, calls.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		val  string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := retryAfter(tc.val); got != tc.want {
+			t.Errorf("retryAfter('%s') = %s, want %s", tc.val, got, tc.want)
+		}
+	}
+	// HTTP date in the future
+	val := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got := retryAfter(val); got < 28*time.Second || got > 30*time.Second {
+		t.Errorf("retryAfter('%s') = %s, want ~30s", val, got)
+	}
+}
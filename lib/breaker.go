@@ -0,0 +1,102 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Error codes
+var (
+	ErrHdlrUnavailable = fmt.Errorf("handler unavailable (circuit breaker open)")
+)
+
+// circuit breakers of blockchain handlers (by name)
+var chainBreakers = make(map[string]*breaker)
+
+// breaker is a circuit breaker for a (blockchain) handler: after a number
+// of consecutive failures, the handler is not called for a cool-down
+// period. A successful request resets the breaker. A nil breaker is
+// always closed (handler is always called).
+type breaker struct {
+	name     string        // name of handler
+	maxFails int           // number of failures to open breaker
+	coolDown time.Duration // time before calling the handler again
+	fails    int           // number of consecutive failures
+	until    time.Time     // breaker is open until...
+	now      func() time.Time
+	lock     sync.Mutex // serializer
+}
+
+// newBreaker creates a circuit breaker for a handler. Returns nil if
+// no (valid) failure limit is given.
+func newBreaker(name string, maxFails, coolDown int) *breaker {
+	if maxFails <= 0 {
+		return nil
+	}
+	return &breaker{
+		name:     name,
+		maxFails: maxFails,
+		coolDown: time.Duration(coolDown) * time.Second,
+		now:      time.Now,
+	}
+}
+
+// Allow returns true if the handler can be called.
+func (b *breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return !b.now().Before(b.until)
+}
+
+// Record the result of a handler call.
+func (b *breaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	// unsupported operations are no handler failures
+	if errors.Is(err, ErrNotImplemented) {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil {
+		if b.fails >= b.maxFails {
+			logger.Printf(logger.INFO, "[breaker] %s: closed (handler recovered)", b.name)
+		}
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if b.fails >= b.maxFails {
+		b.until = b.now().Add(b.coolDown)
+		logger.Printf(logger.WARN, "[breaker] %s: open after %d failures (until %s)",
+			b.name, b.fails, b.until.Format(time.RFC3339))
+	}
+}
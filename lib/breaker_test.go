@@ -0,0 +1,82 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	// no breaker without failure limit
+	if b := newBreaker("none", 0, 60); b != nil || !b.Allow() {
+		t.Fatal("nil breaker not closed")
+	}
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	b := newBreaker("test", 3, 60)
+	b.now = clock.now
+	errFail := errors.New("failed")
+
+	// breaker opens after consecutive failures only
+	b.Record(errFail)
+	b.Record(errFail)
+	b.Record(nil)
+	b.Record(errFail)
+	b.Record(errFail)
+	if !b.Allow() {
+		t.Fatal("breaker open before failure limit")
+	}
+	// unsupported operations don't count
+	b.Record(ErrNotImplemented)
+	if !b.Allow() {
+		t.Fatal("breaker open on unsupported operation")
+	}
+	b.Record(errFail)
+	if b.Allow() {
+		t.Fatal("breaker not open after failure limit")
+	}
+	// breaker stays open during cool-down
+	clock.advance(59 * time.Second)
+	if b.Allow() {
+		t.Fatal("breaker closed during cool-down")
+	}
+	// handler is called again after cool-down; another failure re-opens
+	// the breaker
+	clock.advance(time.Second)
+	if !b.Allow() {
+		t.Fatal("breaker not closed after cool-down")
+	}
+	b.Record(errFail)
+	if b.Allow() {
+		t.Fatal("breaker not re-opened after failed retry")
+	}
+	// a successful call closes the breaker
+	clock.advance(time.Minute)
+	b.Record(nil)
+	if !b.Allow() || b.fails != 0 {
+		t.Fatal("breaker not closed after recovery")
+	}
+	b.Record(errFail)
+	if !b.Allow() {
+		t.Fatal("breaker open after single failure following recovery")
+	}
+}
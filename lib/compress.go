@@ -0,0 +1,120 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Compressed wraps a HTTP handler: responses are gzip-compressed if the
+// client accepts it. Responses that are already compressed (with a
+// 'Content-Encoding' header or a compressed content type like images or
+// archives) are sent unchanged.
+func Compressed(hdlr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			hdlr.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.Close()
+		hdlr.ServeHTTP(gw, r)
+	})
+}
+
+// check if client accepts gzip-encoded responses
+func acceptsGzip(accept string) bool {
+	for _, enc := range strings.Split(accept, ",") {
+		name, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" {
+			return strings.TrimSpace(q) != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipWriter compresses the response (if applicable); the decision is
+// made on the response headers when the header is written.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer // compressor (nil = uncompressed)
+	written bool         // header written?
+}
+
+// WriteHeader decides on compression and writes the response header.
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.written {
+		return
+	}
+	w.written = true
+	hdr := w.Header()
+	if code != http.StatusNoContent && code != http.StatusNotModified &&
+		len(hdr.Get("Content-Encoding")) == 0 && compressible(hdr.Get("Content-Type")) {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write (compressed) response data.
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		if len(w.Header().Get("Content-Type")) == 0 {
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Close the compressor (flushes remaining data)
+func (w *gzipWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// check if content of given type can be compressed (compressed formats
+// like images, archives or fonts are excluded; SVG images are text).
+func compressible(ctype string) bool {
+	ctype, _, _ = strings.Cut(ctype, ";")
+	switch {
+	case ctype == "image/svg+xml":
+		return true
+	case strings.HasPrefix(ctype, "image/"),
+		strings.HasPrefix(ctype, "video/"),
+		strings.HasPrefix(ctype, "audio/"),
+		strings.HasPrefix(ctype, "font/"),
+		ctype == "application/zip",
+		ctype == "application/gzip",
+		ctype == "application/octet-stream":
+		return false
+	}
+	return true
+}
@@ -22,25 +22,99 @@ package lib
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/bfix/gospel/bitcoin/wallet"
 	"github.com/bfix/gospel/logger"
 )
 
+// Error codes
+var (
+	ErrCfgNoChain = fmt.Errorf("no blockchain handler listed")
+	ErrCfgChain   = fmt.Errorf("blockchain handler not configured for coin")
+	ErrCfgChain2  = fmt.Errorf("blockchain handler listed twice")
+)
+
+// accent colors of coins are hex RGB values ("#rrggbb")
+var rxColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
 //----------------------------------------------------------------------
 
 // CoinConfig for a supported coin (Bitcoin or Altcoin)
 type CoinConfig struct {
-	Symb       string  `json:"symb"`       // coin symbol
-	Path       string  `json:"path"`       // base derivation path like "m/44'/0'/0'/0/0"
-	Mode       string  `json:"mode"`       // address version (P2PKH, P2SH, ...)
-	Pk         string  `json:"pk"`         // public key for coin
-	Addr       string  `json:"addr"`       // address for base derivation path
-	Limit      float64 `json:"limit"`      // limit for receiving addresses
-	Explorer   string  `json:"explorer"`   // address explorer URL
-	Blockchain string  `json:"blockchain"` // blockchain handler reference
+	Symb        string      `json:"symb"`         // coin symbol
+	Path        string      `json:"path"`         // base derivation path like "m/44'/0'/0'/0/0"
+	Mode        string      `json:"mode"`         // address version (P2PKH, P2SH, ...)
+	Pk          string      `json:"pk"`           // public key for coin
+	AnyVersion  bool        `json:"anyVersion"`   // accept key version of other mode
+	Addr        string      `json:"addr"`         // address for base derivation path
+	Limit       float64     `json:"limit"`        // limit for receiving addresses
+	Explorer    string      `json:"explorer"`     // address explorer URL
+	Blockchain  string      `json:"blockchain"`   // blockchain handler reference
+	Fallback    []string    `json:"fallback"`     // alternative blockchain handlers (failover)
+	Decimals    int         `json:"decimals"`     // number of decimals to display
+	CheckScript bool        `json:"checkScript"`  // check script type of funds
+	NoCoinbase  bool        `json:"noCoinbase"`   // ignore funds from coinbase
+	HTTP        *HTTPConfig `json:"http"`         // HTTP client settings
+	Memo        bool        `json:"requiresMemo"` // transactions need memo/tag
+	RampUp      int         `json:"rampUp"`       // max. balance checks per epoch (onboarding)
+	RampEpochs  int         `json:"rampEpochs"`   // number of epochs for onboarding
+	Unconfirmed bool        `json:"unconfirmed"`  // report unconfirmed balance
+	Paused      bool        `json:"paused"`       // not accepting new transactions
+	MinAmount   float64     `json:"minAmount"`    // min. accepted amount (advisory)
+	Fee         float64     `json:"fee"`          // typical network fee (guidance)
+	TxTTL       int         `json:"txTTL"`        // Time-to-live for Tx (0 = model setting)
+	Fingerprint string      `json:"fingerprint"`  // master key fingerprint (hex; cosigner info)
+	AddrFormat  string      `json:"addrFormat"`   // display format of addresses
+	Label       string      `json:"label"`        // display name (GUI; overrides coin name)
+	Color       string      `json:"color"`        // accent color (GUI; like "#f7931a")
+}
+
+// ChainHandlers returns the names of all blockchain handlers for the coin
+// (primary handler first).
+func (c *CoinConfig) ChainHandlers() []string {
+	list := []string{c.Blockchain}
+	for _, name := range c.Fallback {
+		if !slices.Contains(list, name) {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// CheckChainOrder checks a list of blockchain handler names (for use in
+// the given order): the list must not be empty and all handlers must be
+// configured for the coin.
+func (c *CoinConfig) CheckChainOrder(names []string) error {
+	if len(names) == 0 {
+		return ErrCfgNoChain
+	}
+	all := c.ChainHandlers()
+	for i, name := range names {
+		if !slices.Contains(all, name) {
+			return fmt.Errorf("%w: '%s'", ErrCfgChain, name)
+		}
+		if slices.Contains(names[:i], name) {
+			return fmt.Errorf("%w: '%s'", ErrCfgChain2, name)
+		}
+	}
+	return nil
+}
+
+// GetDecimals returns the number of decimals used to display coin
+// amounts (defaults to 8).
+func (c *CoinConfig) GetDecimals() int {
+	if c.Decimals <= 0 {
+		return 8
+	}
+	return c.Decimals
 }
 
 // GetMode returns the numeric value of mode (P2PKH, P2SH, ...)
@@ -62,25 +136,147 @@ func (c *CoinConfig) GetXDVersion() uint32 {
 	return wallet.GetXDVersion(coin, m, wallet.NetwMain, true)
 }
 
+// CheckKeyVersion checks if the version of an extended public key matches
+// the mode of the coin: a key with the version of another mode (like a
+// 'zpub' for mode P2PKH) is a setup mistake, as the derived addresses are
+// not the addresses expected by the wallet. Versions not listed for the
+// coin (like generic 'xpub' keys for some altcoins) are accepted.
+func (c *CoinConfig) CheckKeyVersion(version uint32) error {
+	expected := c.GetXDVersion()
+	if version == expected {
+		return nil
+	}
+	coin, _ := wallet.GetCoinInfo(c.Symb)
+	for _, spec := range wallet.AddrList {
+		if spec.CoinID != coin || len(spec.Formats) <= wallet.NetwMain {
+			continue
+		}
+		format := spec.Formats[wallet.NetwMain]
+		if format == nil {
+			continue
+		}
+		for _, v := range format.Versions {
+			if v != nil && v.PubVersion == version {
+				mode := c.Mode
+				if len(mode) == 0 {
+					mode = "P2PKH"
+				}
+				return fmt.Errorf("key version %08x is not for mode %s (expected %08x)", version, mode, expected)
+			}
+		}
+	}
+	return nil
+}
+
 //----------------------------------------------------------------------
 
 // ServiceConfig for service-related settings
 type ServiceConfig struct {
-	Listen    string `json:"listen"`    // web service listener (host:port)
-	Epoch     int    `json:"epoch"`     // epoch time in seconds
-	LogFile   string `json:"logFile"`   // logfile name
-	LogLevel  string `json:"logLevel"`  // logging level
-	LogRotate int    `json:"logRotate"` // epochs between log rotation
+	Listen     string         `json:"listen"`      // web service listener (host:port)
+	Epoch      int            `json:"epoch"`       // epoch time in seconds
+	LogFile    string         `json:"logFile"`     // logfile name
+	LogLevel   string         `json:"logLevel"`    // logging level
+	LogRotate  int            `json:"logRotate"`   // epochs between log rotation
+	ApiToken   string         `json:"apiToken"`    // access token for authenticated API
+	AdminToken string         `json:"adminToken"`  // access token for admin API
+	ApiKeys    []*ApiKey      `json:"apiKeys"`     // keys for client calls (list/receive)
+	AdminNets  *AccessConfig  `json:"adminAccess"` // networks allowed for admin routes
+	NewCoins   []string       `json:"newCoins"`    // default coins for new accounts
+	MaxList    int            `json:"maxList"`     // max. number of coins in list (0 = all)
+	QRFormat   string         `json:"qrFormat"`    // image format of QR codes ("png", "jpeg")
+	Compress   bool           `json:"compress"`    // gzip-compress responses
+	TxReceived bool           `json:"txReceived"`  // report funds received per transaction
+	Refund     bool           `json:"refund"`      // capture refund addresses in /receive/
+	Snapshot   string         `json:"snapshot"`    // file name for status snapshot
+	SnapRate   int            `json:"snapRate"`    // epochs between snapshots
+	Webhook    *WebhookConfig `json:"webhook"`     // notification on incoming funds
+}
+
+// WebhookConfig for notifications on incoming funds: the event is posted
+// as JSON to the URL (signed with HMAC-SHA256 if a secret is set).
+type WebhookConfig struct {
+	URL     string `json:"url"`     // webhook URL
+	Secret  string `json:"secret"`  // HMAC secret (optional)
+	Retries int    `json:"retries"` // number of retries on failure
+}
+
+// ApiKey restricts client calls (creating transactions) to a set of
+// accounts and coins. An empty list of accounts or coins allows all.
+type ApiKey struct {
+	Key      string   `json:"key"`      // API key
+	Accounts []string `json:"accounts"` // allowed account labels
+	Coins    []string `json:"coins"`    // allowed coin symbols
+}
+
+// Allows returns true if the key is valid for account and coin. An empty
+// account or coin is not checked.
+func (k *ApiKey) Allows(accnt, coin string) bool {
+	return (len(accnt) == 0 || len(k.Accounts) == 0 || slices.Contains(k.Accounts, accnt)) &&
+		(len(coin) == 0 || len(k.Coins) == 0 || slices.Contains(k.Coins, coin))
+}
+
+//----------------------------------------------------------------------
+
+// TracingConfig for the export of traces to an OpenTelemetry collector
+// (OTLP over HTTP). Tracing is disabled without endpoint.
+type TracingConfig struct {
+	Endpoint string            `json:"endpoint"` // collector URL (like "http://localhost:4318")
+	Service  string            `json:"service"`  // service name (default: "bitbank-relay")
+	Interval int               `json:"interval"` // seconds between exports (default: 10)
+	Headers  map[string]string `json:"headers"`  // additional HTTP headers (like authentication)
+}
+
+//----------------------------------------------------------------------
+
+// DeriveConfig for a remote address derivation service. If an URL is
+// given, addresses are requested from the service at that URL; the
+// derivation service itself only uses the token.
+type DeriveConfig struct {
+	URL   string `json:"url"`   // base URL of derivation service
+	Token string `json:"token"` // access token
+}
+
+//----------------------------------------------------------------------
+
+// HTTPConfig for HTTP clients used by blockchain handlers
+type HTTPConfig struct {
+	Timeout  int    `json:"timeout"`  // request timeout (in seconds)
+	Insecure bool   `json:"insecure"` // skip TLS certificate verification
+	Proxy    string `json:"proxy"`    // proxy URL
+	Attempts int    `json:"attempts"` // max. attempts per request (default: 3)
 }
 
 //----------------------------------------------------------------------
 
 // ModelConfig for model-related settings.
 type ModelConfig struct {
-	DbEngine    string    `json:"dbEngine"`    // mode (mysql, sqlite3, ...)
-	DbConnect   string    `json:"dbConnect"`   // database connect string
-	BalanceWait []float64 `json:"balanceWait"` // wait parameters [min, factor, max]
-	TxTTL       int       `json:"txTTL"`       // Time-to-live for Tx
+	DbEngine    string        `json:"dbEngine"`      // mode (mysql, sqlite3, ...)
+	DbConnect   string        `json:"dbConnect"`     // database connect string
+	BalanceWait []float64     `json:"balanceWait"`   // wait parameters [min(s), factor, max(s)]
+	BalanceHold int           `json:"balanceHold"`   // time to reuse a balance check
+	StaleCheck  int           `json:"staleCheck"`    // time after which a pending check is stuck (s)
+	FirstCheck  int           `json:"firstCheck"`    // delay of first check of new addresses (s)
+	MinClose    float64       `json:"minCloseValue"` // min. fiat value for closing addresses
+	TxTTL       int           `json:"txTTL"`         // Time-to-live for Tx
+	TxGrace     int           `json:"txGrace"`       // Grace period for expired Tx
+	MaxClose    int           `json:"maxClose"`      // max. expired Tx closed per epoch (0 = all)
+	AddrPolicy  string        `json:"addrPolicy"`    // address selection policy
+	Backup      *BackupConfig `json:"backup"`        // periodic backup (SQLite3 only)
+	Rates       *RatesConfig  `json:"rates"`         // retention of daily rates
+}
+
+// BackupConfig for periodic backups of SQLite3 databases
+type BackupConfig struct {
+	Dir  string `json:"dir"`  // directory for backup files
+	Rate int    `json:"rate"` // epochs between backups
+	Keep int    `json:"keep"` // number of backups kept (0 = all)
+}
+
+// RatesConfig for the retention of daily exchange rates: older rates are
+// downsampled to weekly rates.
+type RatesConfig struct {
+	Keep int `json:"keep"` // number of days daily rates are kept (0 = all)
+	Rate int `json:"rate"` // epochs between prunes (0 = no periodic pruning)
 }
 
 //----------------------------------------------------------------------
@@ -89,6 +285,9 @@ type ModelConfig struct {
 type MarketHandlerConfig struct {
 	RateLimits []int  `json:"rateLimits"` // rate limits
 	ApiKey     string `json:"apikey"`     // authentication
+	Reserve    int64  `json:"reserve"`    // pause if credits drop to reserve
+	ResetTime  int    `json:"resetTime"`  // pause on exhausted credits (in seconds)
+	Priority   int    `json:"priority"`   // order of market handlers (lowest first)
 }
 
 // ChainHandlerConfig to sezup blockchain-retrieval handlers
@@ -96,12 +295,24 @@ type ChainHandlerConfig struct {
 	RateLimits []int   `json:"rateLimits"` // rate limits
 	CoolTime   float64 `json:"coolTime"`   // cool time between requests
 	ApiKey     string  `json:"apiKey"`     // authentication
+	BreakAfter int     `json:"breakAfter"` // consecutive failures to stop calls
+	BreakTime  int     `json:"breakTime"`  // cool-down (in seconds) after failures
+	PageSize   int     `json:"pageSize"`   // transactions per request (listing funds)
+	MaxPages   int     `json:"maxPages"`   // max. number of requests (listing funds)
+	Host       string  `json:"host"`       // server host (Electrum)
+	Port       int     `json:"port"`       // server port (Electrum)
+	TLS        bool    `json:"tls"`        // use TLS connection (Electrum)
+	Insecure   bool    `json:"insecure"`   // skip TLS certificate verification
 }
 
 type MarketConfig struct {
-	Fiat    string                          `json:"fiat"`    // Fiat base currency
-	Rescan  int                             `json:"rescan"`  // rescan time interval (in epochs)
-	Service map[string]*MarketHandlerConfig `json:"service"` // narket services
+	Fiat     string                          `json:"fiat"`         // Fiat base currency
+	Rescan   int                             `json:"rescan"`       // rescan time interval (in epochs)
+	Interval int                             `json:"interval"`     // update interval (in seconds)
+	Estimate bool                            `json:"estimate"`     // estimate missing rates via BTC
+	MaxDev   float64                         `json:"maxDeviation"` // max. factor between new and last rate
+	StaleAge int64                           `json:"staleAge"`     // max. age of rates (in seconds; 0 = unchecked)
+	Service  map[string]*MarketHandlerConfig `json:"service"`      // narket services
 }
 
 // HandlerConfig holds all handler-related configurations
@@ -114,10 +325,209 @@ type HandlerConfig struct {
 
 // Config holds overall configuration settings
 type Config struct {
-	Service *ServiceConfig `json:"service"` // web service configuration
-	Model   *ModelConfig   `json:"model"`   // model configuration
-	Handler *HandlerConfig `json:"handler"` // handler configuration
-	Coins   []*CoinConfig  `json:"coins"`   // list of known coins
+	Service *ServiceConfig    `json:"service"` // web service configuration
+	Model   *ModelConfig      `json:"model"`   // model configuration
+	Handler *HandlerConfig    `json:"handler"` // handler configuration
+	Coins   []*CoinConfig     `json:"coins"`   // list of known coins
+	Aliases map[string]string `json:"aliases"` // alternative coin symbols
+	Derive  *DeriveConfig     `json:"derive"`  // remote address derivation
+	Tracing *TracingConfig    `json:"tracing"` // export of traces
+	Network string            `json:"network"` // coin network (default: "main")
+}
+
+// NetworkID returns the numeric ID of the configured coin network (see
+// GetNetwork); the main network is used if no network is configured.
+func (cfg *Config) NetworkID() int {
+	if len(cfg.Network) == 0 {
+		return GetNetwork("main")
+	}
+	return GetNetwork(cfg.Network)
+}
+
+// Validate checks the configuration for semantic problems (like missing
+// settings, invalid address modes or unknown handlers). All problems found
+// are returned as one (joined) error.
+func (cfg *Config) Validate() error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if cfg.NetworkID() < 0 {
+		addErr("invalid network '%s'", cfg.Network)
+	}
+	// service and model settings
+	if cfg.Service == nil {
+		addErr("missing service configuration")
+	} else {
+		if cfg.Service.Epoch <= 0 {
+			addErr("service: invalid epoch %d", cfg.Service.Epoch)
+		}
+		if wh := cfg.Service.Webhook; wh != nil {
+			if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				addErr("service: invalid webhook URL '%s'", wh.URL)
+			}
+			if wh.Retries < 0 {
+				addErr("service: invalid webhook retries %d", wh.Retries)
+			}
+		}
+		switch cfg.Service.QRFormat {
+		case "", "png", "jpeg":
+		default:
+			addErr("service: invalid QR code format '%s'", cfg.Service.QRFormat)
+		}
+		if _, err := NewAllowlist(cfg.Service.AdminNets); err != nil {
+			addErr("service: admin access: %s", err.Error())
+		}
+	}
+	if cfg.Model == nil {
+		addErr("missing model configuration")
+	} else {
+		if len(cfg.Model.DbEngine) == 0 || len(cfg.Model.DbConnect) == 0 {
+			addErr("model: missing database engine or connect string")
+		}
+		if _, err := GetAddressSelector(cfg.Model.AddrPolicy); err != nil {
+			addErr("model: %s", err.Error())
+		}
+		if b := cfg.Model.Backup; b != nil && len(b.Dir) == 0 {
+			addErr("model: missing backup directory")
+		}
+		if r := cfg.Model.Rates; r != nil && (r.Keep < 0 || r.Rate < 0) {
+			addErr("model: invalid rates retention")
+		}
+		if cfg.Model.MinClose < 0 {
+			addErr("model: invalid minCloseValue %f", cfg.Model.MinClose)
+		}
+		if cfg.Model.MaxClose < 0 {
+			addErr("model: invalid maxClose %d", cfg.Model.MaxClose)
+		}
+		if cfg.Model.StaleCheck < 0 {
+			addErr("model: invalid staleCheck %d", cfg.Model.StaleCheck)
+		}
+		if cfg.Model.FirstCheck < 0 {
+			addErr("model: invalid firstCheck %d", cfg.Model.FirstCheck)
+		}
+		if bw := cfg.Model.BalanceWait; len(bw) != 3 {
+			addErr("model: balanceWait needs three values [min, factor, max]")
+		} else if bw[0] < 1 || bw[1] < 1 || bw[2] < bw[0] {
+			addErr("model: invalid balanceWait %v (need min >= 1, factor >= 1, max >= min)", bw)
+		}
+	}
+	// handler settings
+	if cfg.Handler == nil {
+		addErr("missing handler configuration")
+	} else {
+		for name := range cfg.Handler.Blockchain {
+			if !IsChainHandler(name) {
+				addErr("handler: unknown blockchain handler '%s'", name)
+			}
+			if hc := cfg.Handler.Blockchain[name]; IsElectrumHandler(name) && hc != nil &&
+				(len(hc.Host) == 0 || hc.Port <= 0 || hc.Port > 65535) {
+				addErr("handler: missing/invalid server for blockchain handler '%s'", name)
+			}
+		}
+		if cfg.Handler.Market == nil {
+			addErr("handler: missing market configuration")
+		} else {
+			if len(cfg.Handler.Market.Fiat) == 0 {
+				addErr("handler: missing fiat currency")
+			}
+			if cfg.Handler.Market.Interval <= 0 && cfg.Handler.Market.Rescan <= 0 {
+				addErr("handler: missing market update interval")
+			}
+			if md := cfg.Handler.Market.MaxDev; md != 0 && md <= 1 {
+				addErr("handler: invalid maxDeviation %f", md)
+			}
+			if cfg.Handler.Market.StaleAge < 0 {
+				addErr("handler: invalid staleAge %d", cfg.Handler.Market.StaleAge)
+			}
+			if len(cfg.Handler.Market.Service) == 0 {
+				addErr("handler: no market handler configured")
+			}
+			for name, hdlr := range cfg.Handler.Market.Service {
+				if !IsMarketHandler(name) {
+					addErr("handler: unknown market handler '%s'", name)
+				}
+				if hdlr.Reserve < 0 || hdlr.ResetTime < 0 {
+					addErr("handler: invalid credit settings for market handler '%s'", name)
+				}
+			}
+		}
+	}
+	// coin settings
+	if len(cfg.Coins) == 0 {
+		addErr("no coins configured")
+	}
+	symbs := make(map[string]bool)
+	for i, coin := range cfg.Coins {
+		if len(coin.Symb) == 0 {
+			addErr("coin #%d: missing symbol", i+1)
+			continue
+		}
+		if symbs[coin.Symb] {
+			addErr("coin '%s': duplicate symbol", coin.Symb)
+		}
+		symbs[coin.Symb] = true
+		if len(coin.Path) == 0 {
+			addErr("coin '%s': missing derivation path", coin.Symb)
+		}
+		// (empty mode is allowed: coin default)
+		if len(coin.Mode) > 0 && coin.GetMode() < 0 {
+			addErr("coin '%s': invalid mode '%s'", coin.Symb, coin.Mode)
+		}
+		if cfg.Handler != nil {
+			for _, name := range coin.ChainHandlers() {
+				if _, ok := cfg.Handler.Blockchain[name]; !ok {
+					addErr("coin '%s': blockchain handler '%s' not configured", coin.Symb, name)
+				}
+			}
+		}
+		if coin.Unconfirmed && !IsUnconfirmedHandler(coin.Blockchain) {
+			addErr("coin '%s': no unconfirmed balance from handler '%s'", coin.Symb, coin.Blockchain)
+		}
+		if coin.TxTTL < 0 || coin.MinAmount < 0 || coin.Fee < 0 {
+			addErr("coin '%s': negative txTTL, minAmount or fee", coin.Symb)
+		}
+		if !ValidAddrFormat(coin.Symb, coin.AddrFormat) {
+			addErr("coin '%s': unknown address format '%s'", coin.Symb, coin.AddrFormat)
+		}
+		if len(coin.Label) > 31 {
+			addErr("coin '%s': label too long (max. 31 chars)", coin.Symb)
+		}
+		if len(coin.Color) > 0 && !rxColor.MatchString(coin.Color) {
+			addErr("coin '%s': invalid color '%s' (like '#f7931a')", coin.Symb, coin.Color)
+		}
+	}
+	// tracing endpoint must be a HTTP(S) URL
+	if t := cfg.Tracing; t != nil && len(t.Endpoint) > 0 {
+		if u, err := url.Parse(t.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			addErr("tracing: invalid endpoint '%s'", t.Endpoint)
+		}
+	}
+	// aliases must refer to configured coins
+	for alias, symb := range cfg.Aliases {
+		if !symbs[symb] {
+			addErr("alias '%s': unknown coin '%s'", alias, symb)
+		}
+	}
+	// default coins for new accounts must be configured
+	if cfg.Service != nil {
+		for i, key := range cfg.Service.ApiKeys {
+			if len(key.Key) == 0 {
+				addErr("service: API key #%d is empty", i+1)
+			}
+			for _, symb := range key.Coins {
+				if !symbs[symb] {
+					addErr("service: unknown coin '%s' for API key #%d", symb, i+1)
+				}
+			}
+		}
+		for _, symb := range cfg.Service.NewCoins {
+			if !symbs[symb] {
+				addErr("service: unknown coin '%s' in newCoins", symb)
+			}
+		}
+	}
+	return errors.Join(errs...)
 }
 
 //----------------------------------------------------------------------
@@ -148,6 +558,118 @@ func ReadConfig(rdr io.Reader) (*Config, error) {
 	return cfg, nil
 }
 
+// environment variables for configuration settings (see ApplyEnv)
+var envSettings = map[string]func(cfg *Config) *string{
+	"RELAY_SERVICE_LISTEN": func(cfg *Config) *string {
+		if cfg.Service == nil {
+			return nil
+		}
+		return &cfg.Service.Listen
+	},
+	"RELAY_SERVICE_LOGLEVEL": func(cfg *Config) *string {
+		if cfg.Service == nil {
+			return nil
+		}
+		return &cfg.Service.LogLevel
+	},
+	"RELAY_SERVICE_APITOKEN": func(cfg *Config) *string {
+		if cfg.Service == nil {
+			return nil
+		}
+		return &cfg.Service.ApiToken
+	},
+	"RELAY_SERVICE_ADMINTOKEN": func(cfg *Config) *string {
+		if cfg.Service == nil {
+			return nil
+		}
+		return &cfg.Service.AdminToken
+	},
+	"RELAY_SERVICE_WEBHOOK_SECRET": func(cfg *Config) *string {
+		if cfg.Service == nil || cfg.Service.Webhook == nil {
+			return nil
+		}
+		return &cfg.Service.Webhook.Secret
+	},
+	"RELAY_MODEL_DBENGINE": func(cfg *Config) *string {
+		if cfg.Model == nil {
+			return nil
+		}
+		return &cfg.Model.DbEngine
+	},
+	"RELAY_MODEL_DBCONNECT": func(cfg *Config) *string {
+		if cfg.Model == nil {
+			return nil
+		}
+		return &cfg.Model.DbConnect
+	},
+	"RELAY_HANDLER_MARKET_FIAT": func(cfg *Config) *string {
+		if cfg.Handler == nil || cfg.Handler.Market == nil {
+			return nil
+		}
+		return &cfg.Handler.Market.Fiat
+	},
+	"RELAY_DERIVE_URL": func(cfg *Config) *string {
+		if cfg.Derive == nil {
+			cfg.Derive = new(DeriveConfig)
+		}
+		return &cfg.Derive.URL
+	},
+	"RELAY_DERIVE_TOKEN": func(cfg *Config) *string {
+		if cfg.Derive == nil {
+			cfg.Derive = new(DeriveConfig)
+		}
+		return &cfg.Derive.Token
+	},
+}
+
+// ApplyEnv overrides configuration settings with the values of environment
+// variables (if set). Besides the variables listed in 'envSettings', the
+// API keys of handlers can be set:
+//   - RELAY_HANDLER_MARKET_APIKEY: API key of all market handlers
+//   - RELAY_HANDLER_CHAIN_<NAME>_APIKEY: API key of a blockchain handler
+//     (name in upper case with non-alphanumeric characters replaced by '_',
+//     like 'BLOCKCHAIR_COM')
+//
+// Returns the names of the applied variables (not their values).
+func (cfg *Config) ApplyEnv() (applied []string) {
+	set := func(name string, field *string) {
+		if val, ok := os.LookupEnv(name); ok && field != nil {
+			*field = val
+			applied = append(applied, name)
+		}
+	}
+	for name, field := range envSettings {
+		if _, ok := os.LookupEnv(name); ok {
+			set(name, field(cfg))
+		}
+	}
+	if cfg.Handler != nil {
+		if cfg.Handler.Market != nil {
+			for _, hdlr := range cfg.Handler.Market.Service {
+				set("RELAY_HANDLER_MARKET_APIKEY", &hdlr.ApiKey)
+			}
+		}
+		for name, hdlr := range cfg.Handler.Blockchain {
+			set("RELAY_HANDLER_CHAIN_"+envName(name)+"_APIKEY", &hdlr.ApiKey)
+		}
+	}
+	slices.Sort(applied)
+	return slices.Compact(applied)
+}
+
+// convert handler name to part of environment variable name
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 // WriteConfigFile to store configuration to file
 func WriteConfigFile(fname string, cfg *Config) error {
 	f, err := os.Create(fname)
@@ -167,3 +689,64 @@ func WriteConfig(wrt io.Writer, cfg *Config) error {
 	_, err = wrt.Write(data)
 	return err
 }
+
+// placeholder for redacted secrets
+const redacted = "***"
+
+// WriteConfigRedacted writes the configuration with all secrets (like API
+// keys, access tokens or tracing headers) replaced by "***". The redacted
+// configuration can be shared safely (e.g. attached to a bug report); the
+// configuration itself is not changed.
+func WriteConfigRedacted(wrt io.Writer, cfg *Config) error {
+	// deep copy of configuration
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	cc := new(Config)
+	if err = json.Unmarshal(data, cc); err != nil {
+		return err
+	}
+	// redact secrets
+	redact := func(s *string) {
+		if len(*s) > 0 {
+			*s = redacted
+		}
+	}
+	if cc.Service != nil {
+		redact(&cc.Service.ApiToken)
+		redact(&cc.Service.AdminToken)
+		for _, key := range cc.Service.ApiKeys {
+			if key != nil {
+				redact(&key.Key)
+			}
+		}
+		if cc.Service.Webhook != nil {
+			redact(&cc.Service.Webhook.Secret)
+		}
+	}
+	if cc.Handler != nil {
+		if cc.Handler.Market != nil {
+			for _, hdlr := range cc.Handler.Market.Service {
+				if hdlr != nil {
+					redact(&hdlr.ApiKey)
+				}
+			}
+		}
+		for _, hdlr := range cc.Handler.Blockchain {
+			if hdlr != nil {
+				redact(&hdlr.ApiKey)
+			}
+		}
+	}
+	if cc.Tracing != nil {
+		for name, val := range cc.Tracing.Headers {
+			redact(&val)
+			cc.Tracing.Headers[name] = val
+		}
+	}
+	if cc.Derive != nil {
+		redact(&cc.Derive.Token)
+	}
+	return WriteConfig(wrt, cc)
+}
@@ -0,0 +1,531 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/wallet"
+)
+
+//----------------------------------------------------------------------
+// Electrum servers (ElectrumX, Fulcrum, ...)
+//----------------------------------------------------------------------
+
+// Error codes
+var (
+	ErrElectrumNoServer = errors.New("no Electrum server configured")
+	ErrElectrumAddr     = errors.New("address not supported by Electrum handler")
+	ErrElectrumTx       = errors.New("invalid raw transaction")
+)
+
+// ElectrumError is an error returned by an Electrum server
+type ElectrumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns a human-readable error message
+func (e *ElectrumError) Error() string {
+	return fmt.Sprintf("electrum: %s (%d)", e.Message, e.Code)
+}
+
+// default time-out for Electrum requests (if context has no deadline)
+const electrumTimeout = 30 * time.Second
+
+// max. number of cached (confirmed) transactions
+const electrumTxCache = 10000
+
+// IsElectrumHandler returns true if the blockchain handler name refers to
+// an Electrum server. Multiple servers (for different coins) can be
+// configured as "electrum:<label>".
+func IsElectrumHandler(name string) bool {
+	return name == "electrum" || strings.HasPrefix(name, "electrum:")
+}
+
+// ElectrumChainHandler handles blockchain operations for all coins
+// supported by an (user-supplied) Electrum server. The server is queried
+// over a persistent TCP (or TLS) connection that is re-established if
+// it fails.
+type ElectrumChainHandler struct {
+	server string            // server address (host:port)
+	tlsCfg *tls.Config       // TLS settings (nil for plain TCP)
+	conn   net.Conn          // connection to server
+	rdr    *bufio.Reader     // buffered reader for responses
+	id     int               // last request identifier
+	times  map[int]int64     // block times (by height)
+	txs    map[string]*rawTx // cached confirmed transactions
+	lock   sync.Mutex        // serialize operations
+}
+
+// Init a new Electrum chain handler instance
+func (hdlr *ElectrumChainHandler) Init(cfg *ChainHandlerConfig) {
+	hdlr.server = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	if cfg.TLS {
+		hdlr.tlsCfg = &tls.Config{
+			ServerName:         cfg.Host,
+			InsecureSkipVerify: cfg.Insecure,
+		}
+	}
+	hdlr.times = make(map[int]int64)
+	hdlr.txs = make(map[string]*rawTx)
+}
+
+// Supports returns true for coins with Base58/SegWit addresses.
+func (hdlr *ElectrumChainHandler) Supports(coin string) bool {
+	coinID, _ := wallet.GetCoinInfo(coin)
+	for _, spec := range wallet.AddrList {
+		if spec.CoinID == coinID {
+			return spec.Conv == nil
+		}
+	}
+	return false
+}
+
+// Balance gets the total amount received on an address (confirmed
+// transactions). The balance reported by the Electrum server is not used
+// as it is reduced by spent outputs.
+func (hdlr *ElectrumChainHandler) Balance(ctx context.Context, addr, coin string) (float64, error) {
+	funds, err := hdlr.GetFunds(ctx, 0, addr, coin)
+	if err != nil {
+		return -1, err
+	}
+	val := 0.
+	for _, f := range funds {
+		val += f.Amount
+	}
+	return val, nil
+}
+
+// Unconfirmed returns the unconfirmed (mempool) balance of an address.
+func (hdlr *ElectrumChainHandler) Unconfirmed(ctx context.Context, addr, coin string) (float64, error) {
+	// only handle one call at a time
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+
+	sh, err := scriptHash(addr, coin)
+	if err != nil {
+		return -1, err
+	}
+	var bal struct {
+		Confirmed   int64 `json:"confirmed"`
+		Unconfirmed int64 `json:"unconfirmed"`
+	}
+	if err = hdlr.call(ctx, &bal, "blockchain.scripthash.get_balance", sh); err != nil {
+		return -1, err
+	}
+	// unconfirmed spends are reported as negative balance
+	return float64(max(bal.Unconfirmed, 0)) / 1e8, nil
+}
+
+// GetFunds returns incoming (confirmed) transactions for an address.
+func (hdlr *ElectrumChainHandler) GetFunds(ctx context.Context, addrId int64, addr, coin string) ([]*Fund, error) {
+	// only handle one call at a time
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+
+	// get transaction history of address
+	script, err := addressScript(addr, coin)
+	if err != nil {
+		return nil, err
+	}
+	var hist []struct {
+		TxHash string `json:"tx_hash"`
+		Height int    `json:"height"`
+	}
+	if err = hdlr.call(ctx, &hist, "blockchain.scripthash.get_history", scriptHashOf(script)); err != nil {
+		return nil, err
+	}
+	// process all confirmed transactions
+	funds := make([]*Fund, 0)
+	for _, h := range hist {
+		if h.Height <= 0 {
+			continue
+		}
+		tx, err := hdlr.transaction(ctx, h.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		seen, err := hdlr.blockTime(ctx, h.Height)
+		if err != nil {
+			return nil, err
+		}
+		// find received funds in transaction outputs
+		for _, out := range tx.outs {
+			if !bytes.Equal(out.script, script) {
+				continue
+			}
+			f := &Fund{
+				Seen:     seen,
+				Addr:     addrId,
+				Amount:   float64(out.value) / 1e8,
+				Script:   scriptTypeFromHex(hex.EncodeToString(out.script)),
+				Coinbase: tx.coinbase,
+			}
+			funds = append(funds, f)
+		}
+	}
+	return funds, nil
+}
+
+// get (cached) transaction
+func (hdlr *ElectrumChainHandler) transaction(ctx context.Context, txid string) (*rawTx, error) {
+	if tx, ok := hdlr.txs[txid]; ok {
+		return tx, nil
+	}
+	var data string
+	if err := hdlr.call(ctx, &data, "blockchain.transaction.get", txid); err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := parseRawTx(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(hdlr.txs) >= electrumTxCache {
+		hdlr.txs = make(map[string]*rawTx)
+	}
+	hdlr.txs[txid] = tx
+	return tx, nil
+}
+
+// get (cached) timestamp of block at given height
+func (hdlr *ElectrumChainHandler) blockTime(ctx context.Context, height int) (int64, error) {
+	if ts, ok := hdlr.times[height]; ok {
+		return ts, nil
+	}
+	var data string
+	if err := hdlr.call(ctx, &data, "blockchain.block.header", height); err != nil {
+		return 0, err
+	}
+	hdr, err := hex.DecodeString(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(hdr) < 80 {
+		return 0, fmt.Errorf("electrum: invalid block header at height %d", height)
+	}
+	ts := int64(binary.LittleEndian.Uint32(hdr[68:72]))
+	hdlr.times[height] = ts
+	return ts, nil
+}
+
+//----------------------------------------------------------------------
+// Electrum protocol (JSON-RPC over newline-delimited stream)
+//----------------------------------------------------------------------
+
+// call a method on the Electrum server and decode the result. A failed
+// connection is re-established once.
+func (hdlr *ElectrumChainHandler) call(ctx context.Context, result any, method string, params ...any) (err error) {
+	for retry := 0; retry < 2; retry++ {
+		if hdlr.conn == nil {
+			if err = hdlr.connect(ctx); err != nil {
+				return
+			}
+		}
+		var data json.RawMessage
+		if data, err = hdlr.exchange(ctx, method, params); err == nil {
+			return json.Unmarshal(data, result)
+		}
+		if _, ok := err.(*ElectrumError); ok {
+			return
+		}
+		// connection failed
+		hdlr.close()
+	}
+	return
+}
+
+// connect to Electrum server and negotiate protocol version
+func (hdlr *ElectrumChainHandler) connect(ctx context.Context) (err error) {
+	if len(hdlr.server) == 0 {
+		return ErrElectrumNoServer
+	}
+	dialer := &net.Dialer{Timeout: electrumTimeout}
+	if hdlr.tlsCfg != nil {
+		td := &tls.Dialer{NetDialer: dialer, Config: hdlr.tlsCfg}
+		hdlr.conn, err = td.DialContext(ctx, "tcp", hdlr.server)
+	} else {
+		hdlr.conn, err = dialer.DialContext(ctx, "tcp", hdlr.server)
+	}
+	if err != nil {
+		hdlr.conn = nil
+		return
+	}
+	hdlr.rdr = bufio.NewReader(hdlr.conn)
+	if _, err = hdlr.exchange(ctx, "server.version", []any{"bitbank-relay", "1.4"}); err != nil {
+		hdlr.close()
+	}
+	return
+}
+
+// close connection to Electrum server
+func (hdlr *ElectrumChainHandler) close() {
+	if hdlr.conn != nil {
+		hdlr.conn.Close()
+		hdlr.conn = nil
+	}
+}
+
+// exchange a request/response with the Electrum server
+func (hdlr *ElectrumChainHandler) exchange(ctx context.Context, method string, params []any) (json.RawMessage, error) {
+	// set time-out for exchange
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(electrumTimeout)
+	}
+	if err := hdlr.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// send request
+	hdlr.id++
+	req, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      hdlr.id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = hdlr.conn.Write(append(req, '\n')); err != nil {
+		return nil, err
+	}
+	// read responses until the matching one is received (skip notifications)
+	for {
+		line, err := hdlr.rdr.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		var resp struct {
+			ID     int             `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *ElectrumError  `json:"error"`
+		}
+		if err = json.Unmarshal(line, &resp); err != nil {
+			return nil, err
+		}
+		if resp.ID != hdlr.id {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	}
+}
+
+//----------------------------------------------------------------------
+// Addresses and scripts
+//----------------------------------------------------------------------
+
+// scriptHash returns the Electrum script hash of an address: the reversed
+// SHA256 hash of the output script (in hex).
+func scriptHash(addr, coin string) (string, error) {
+	script, err := addressScript(addr, coin)
+	if err != nil {
+		return "", err
+	}
+	return scriptHashOf(script), nil
+}
+
+// scriptHashOf returns the Electrum script hash of an output script.
+func scriptHashOf(script []byte) string {
+	h := sha256.Sum256(script)
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+	return hex.EncodeToString(h[:])
+}
+
+// addressScript returns the output script for an address of given coin.
+// Supported are Base58 addresses (P2PKH, P2SH) and SegWit v0 addresses
+// (P2WPKH, P2WSH).
+func addressScript(addr, coin string) ([]byte, error) {
+	coinID, _ := wallet.GetCoinInfo(coin)
+	for _, spec := range wallet.AddrList {
+		if spec.CoinID != coinID || spec.Conv != nil {
+			continue
+		}
+		for _, format := range spec.Formats {
+			if format == nil {
+				continue
+			}
+			// SegWit addresses (Bech32)
+			if lc := strings.ToLower(addr); len(format.Bech32) > 0 && strings.HasPrefix(lc, format.Bech32+"1") {
+				return segwitScript(lc, format.Bech32)
+			}
+			// Base58 addresses
+			data, err := bitcoin.Base58Decode(addr)
+			if err != nil || len(data) != 25 || len(format.Versions) <= wallet.AddrP2SH {
+				continue
+			}
+			if !bytes.Equal(bitcoin.Hash256(data[:21])[:4], data[21:]) {
+				return nil, ErrElectrumAddr
+			}
+			if v := format.Versions[wallet.AddrP2PKH]; v != nil && int(data[0]) == int(v.Version) {
+				script := append([]byte{0x76, 0xa9, 0x14}, data[1:21]...)
+				return append(script, 0x88, 0xac), nil
+			}
+			if v := format.Versions[wallet.AddrP2SH]; v != nil && int(data[0]) == int(v.Version) {
+				script := append([]byte{0xa9, 0x14}, data[1:21]...)
+				return append(script, 0x87), nil
+			}
+		}
+	}
+	return nil, ErrElectrumAddr
+}
+
+// segwitScript returns the output script for a SegWit v0 address.
+func segwitScript(addr, hrp string) ([]byte, error) {
+	const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	enc := addr[len(hrp)+1:]
+	if len(enc) < 8 {
+		return nil, ErrElectrumAddr
+	}
+	vals := make([]byte, len(enc))
+	for i, c := range enc {
+		pos := strings.IndexRune(charset, c)
+		if pos < 0 {
+			return nil, ErrElectrumAddr
+		}
+		vals[i] = byte(pos)
+	}
+	// check checksum and witness version
+	n := len(vals) - 6
+	if !bytes.Equal(wallet.Bech32CRC(hrp, vals[:n]), vals[n:]) || vals[0] != 0 {
+		return nil, ErrElectrumAddr
+	}
+	// convert 5-bit groups to witness program
+	var (
+		prog []byte
+		acc  uint
+		bits uint
+	)
+	for _, v := range vals[1:n] {
+		acc = acc<<5 | uint(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			prog = append(prog, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 || (len(prog) != 20 && len(prog) != 32) {
+		return nil, ErrElectrumAddr
+	}
+	return append([]byte{0x00, byte(len(prog))}, prog...), nil
+}
+
+//----------------------------------------------------------------------
+// Raw transactions
+//----------------------------------------------------------------------
+
+// rawTx holds the relevant data of a raw transaction
+type rawTx struct {
+	coinbase bool        // coinbase transaction?
+	outs     []*rawTxOut // transaction outputs
+}
+
+// rawTxOut is a transaction output
+type rawTxOut struct {
+	value  int64  // value (in satoshi)
+	script []byte // output script
+}
+
+// parseRawTx parses the inputs and outputs of a raw transaction.
+func parseRawTx(data []byte) (tx *rawTx, err error) {
+	rdr := bytes.NewReader(data)
+	read := func(n uint64) []byte {
+		if err != nil || n > uint64(rdr.Len()) {
+			err = ErrElectrumTx
+			return make([]byte, min(n, 32))
+		}
+		buf := make([]byte, n)
+		_, err = io.ReadFull(rdr, buf)
+		return buf
+	}
+	varInt := func() uint64 {
+		b := read(1)
+		if err != nil {
+			return 0
+		}
+		switch b[0] {
+		case 0xfd:
+			return uint64(binary.LittleEndian.Uint16(read(2)))
+		case 0xfe:
+			return uint64(binary.LittleEndian.Uint32(read(4)))
+		case 0xff:
+			return binary.LittleEndian.Uint64(read(8))
+		}
+		return uint64(b[0])
+	}
+	// skip version and (optional) SegWit marker
+	read(4)
+	nIn := varInt()
+	if err == nil && nIn == 0 {
+		read(1)
+		nIn = varInt()
+	}
+	// parse inputs
+	tx = new(rawTx)
+	for i := uint64(0); i < nIn && err == nil; i++ {
+		prev := read(32)
+		idx := read(4)
+		read(varInt())
+		read(4)
+		if i == 0 && err == nil {
+			tx.coinbase = bytes.Equal(prev, make([]byte, 32)) &&
+				binary.LittleEndian.Uint32(idx) == 0xffffffff
+		}
+	}
+	// parse outputs
+	nOut := varInt()
+	for i := uint64(0); i < nOut && err == nil; i++ {
+		out := &rawTxOut{
+			value: int64(binary.LittleEndian.Uint64(read(8))),
+		}
+		out.script = read(varInt())
+		tx.outs = append(tx.outs, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
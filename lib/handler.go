@@ -22,16 +22,34 @@ package lib
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bfix/gospel/bitcoin"
 	"github.com/bfix/gospel/bitcoin/wallet"
+	"github.com/bfix/gospel/logger"
 )
 
 var (
 	// HdlrList is a list of registered handlers
 	HdlrList = make(map[string]*Handler)
+
+	// alternative coin symbols (lowercase) used by clients
+	coinAliases = make(map[string]string)
+)
+
+// Error codes
+var (
+	ErrHdlrAddrNetwork = fmt.Errorf("address does not match coin/network")
+	ErrHdlrNoKey       = fmt.Errorf("no public key for address derivation")
+	ErrHdlrNoUnconf    = fmt.Errorf("no unconfirmed balance for coin")
 )
 
 // Handler to handle coin accounts (in BIP44/49 wallets)
@@ -44,20 +62,60 @@ type Handler struct {
 	pathTpl  string           // path template for indexing addresses
 	limit    float64          // auto-close balance on address
 	explorer string           // Explorer URL for address
-	chain    ChainHandler     // blockchain handler for coin
+	cfg      *CoinConfig      // coin configuration
+	all      []*chainBackend  // configured blockchain handlers for coin
+	chains   []*chainBackend  // blockchain handlers for coin (in order of use)
+	chLock   sync.RWMutex     // lock for order of blockchain handlers
 	market   MarketHandler    // market handler for coin
+	script   string           // expected script type of funds (if checked)
+	noCB     bool             // ignore funds from coinbase transactions
+	client   *HTTPClient      // HTTP client for blockchain handler
+	memo     bool             // transactions require memo/destination tag
+	derive   *DeriveConfig    // remote derivation service (if defined)
+	unconf   bool             // report unconfirmed balance
+	ucache   unconfCache      // cached unconfirmed balances (per address)
+	ucLock   sync.Mutex       // lock for cached unconfirmed balances
+}
+
+// unconfCache holds the unconfirmed balances of addresses
+type unconfCache map[string]unconfBalance
+
+// unconfBalance is a cached unconfirmed balance of an address
+type unconfBalance struct {
+	balance float64   // unconfirmed balance
+	expires time.Time // end of validity
+}
+
+// chainBackend is a blockchain handler used by a coin handler
+type chainBackend struct {
+	name    string       // name of blockchain handler
+	chain   ChainHandler // blockchain handler instance
+	breaker *breaker     // circuit breaker of blockchain handler
+	unsupp  bool         // coin not supported by blockchain handler
 }
 
 // NewHandler creates a new handler instance for the given coin on
 // a network (main/test/reg) if applicable
 func NewHandler(coin *CoinConfig, network int) (*Handler, error) {
 
-	// compute base account address
-	pk, err := wallet.ParseExtendedPublicKey(coin.Pk)
-	if err != nil {
-		return nil, err
+	// compute base account address (no local derivation without public
+	// key; addresses are derived by a remote service)
+	var tree *wallet.HDPublic
+	if len(coin.Pk) > 0 {
+		pk, err := wallet.ParseExtendedPublicKey(coin.Pk)
+		if err != nil {
+			return nil, err
+		}
+		// check key version against mode (unless overridden)
+		if err = coin.CheckKeyVersion(pk.Data.Version); err != nil {
+			if !coin.AnyVersion {
+				return nil, fmt.Errorf("coin %s: %s", coin.Symb, err.Error())
+			}
+			logger.Printf(logger.WARN, "[handler] %s: %s", coin.Symb, err.Error())
+		}
+		pk.Data.Version = coin.GetXDVersion()
+		tree = wallet.NewHDPublic(pk, coin.Path)
 	}
-	pk.Data.Version = coin.GetXDVersion()
 
 	// compute path template for indexed addreses
 	path := coin.Path
@@ -68,30 +126,78 @@ func NewHandler(coin *CoinConfig, network int) (*Handler, error) {
 
 	// get coin identifier and handlers
 	coinID, _ := wallet.GetCoinInfo(coin.Symb)
-	chainHdlr, ok := baseChainHdlrs[coin.Blockchain]
-	if !ok {
-		return nil, fmt.Errorf("no blockchain handler for coin %s", coin.Symb)
+	var chains []*chainBackend
+	for _, name := range coin.ChainHandlers() {
+		chainHdlr, ok := chainHandler(name)
+		if !ok {
+			return nil, fmt.Errorf("no blockchain handler '%s' for coin %s", name, coin.Symb)
+		}
+		// check if blockchain handler supports the coin
+		cs, ok := chainHdlr.(CoinSupporter)
+		chains = append(chains, &chainBackend{
+			name:    name,
+			chain:   chainHdlr,
+			breaker: chainBreakers[name],
+			unsupp:  ok && !cs.Supports(coin.Symb),
+		})
 	}
 	var marketHdlr MarketHandler = nil
 
+	// get expected script type for incoming funds (if checked)
+	script := ""
+	if coin.CheckScript {
+		if script = coin.Mode; len(script) == 0 {
+			script = "P2PKH"
+		}
+		if strings.HasSuffix(script, "inP2SH") {
+			script = "P2SH"
+		}
+	}
+
+	// get HTTP client for blockchain handler
+	client, err := NewHTTPClient(coin.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
 	// assemble handler for given coin
 	return &Handler{
 		coin:     coinID,
 		symb:     coin.Symb,
 		mode:     coin.GetMode(),
 		netw:     network,
-		tree:     wallet.NewHDPublic(pk, coin.Path),
+		tree:     tree,
 		pathTpl:  path,
 		limit:    coin.Limit,
 		explorer: coin.Explorer,
-		chain:    chainHdlr,
+		cfg:      coin,
+		all:      chains,
+		chains:   chains,
 		market:   marketHdlr,
+		script:   script,
+		noCB:     coin.NoCoinbase,
+		client:   client,
+		memo:     coin.Memo,
+		unconf:   coin.Unconfirmed,
 	}, nil
 }
 
-// GetAddress returns the address for a given index in the account
+// GetAddress returns the address for a given index in the account. If a
+// remote derivation service is used, the address is requested from that
+// service; otherwise it is derived locally.
 func (hdlr *Handler) GetAddress(idx int) (string, error) {
+	if hdlr.derive != nil {
+		return hdlr.remoteAddress(idx)
+	}
+	return hdlr.DeriveAddress(idx)
+}
 
+// DeriveAddress derives the address for a given index from the public key
+// of the coin account.
+func (hdlr *Handler) DeriveAddress(idx int) (string, error) {
+	if hdlr.tree == nil {
+		return "", ErrHdlrNoKey
+	}
 	// get extended public key for indexed address
 	epk, err := hdlr.tree.Public(fmt.Sprintf(hdlr.pathTpl, idx))
 	if err != nil {
@@ -106,19 +212,320 @@ func (hdlr *Handler) GetAddress(idx int) (string, error) {
 	}
 
 	// generate address
-	return wallet.MakeAddress(pk, hdlr.coin, hdlr.mode, hdlr.netw)
+	addr, err := wallet.MakeAddress(pk, hdlr.coin, hdlr.mode, hdlr.netw)
+	if err != nil {
+		return "", err
+	}
+	// check address against coin/network
+	if !checkAddress(addr, hdlr.coin, hdlr.mode, hdlr.netw) {
+		return "", ErrHdlrAddrNetwork
+	}
+	// ETH-like addresses with checksum (EIP-55)
+	if isEthAddress(addr) {
+		return eip55Address(addr)
+	}
+	return addr, nil
+}
+
+// DeriveResponse is the response of a remote derivation service
+type DeriveResponse struct {
+	Addr  string `json:"addr,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// remoteAddress requests the address for a given index from the remote
+// derivation service.
+func (hdlr *Handler) remoteAddress(idx int) (string, error) {
+	// assemble request
+	ctx, cancel := context.WithTimeout(context.Background(), defaultClient.timeout)
+	defer cancel()
+	query := fmt.Sprintf("%s/derive?c=%s&i=%d", strings.TrimRight(hdlr.derive.URL, "/"), url.QueryEscape(hdlr.symb), idx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, query, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(hdlr.derive.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+hdlr.derive.Token)
+	}
+	// send request and parse response
+	resp, err := defaultClient.cl.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("derivation service: %s", resp.Status)
+	}
+	dr := new(DeriveResponse)
+	if err = json.NewDecoder(resp.Body).Decode(dr); err != nil {
+		return "", err
+	}
+	if len(dr.Error) > 0 {
+		return "", fmt.Errorf("derivation service: %s", dr.Error)
+	}
+	// check address against coin/network
+	if !checkAddress(dr.Addr, hdlr.coin, hdlr.mode, hdlr.netw) {
+		return "", ErrHdlrAddrNetwork
+	}
+	return dr.Addr, nil
+}
+
+// checkAddress returns true if the address prefix (version byte or Bech32
+// human-readable part) matches the coin, address mode and network.
+// Addresses with coin-specific encodings (like ETH or BCH) are not checked.
+func checkAddress(addr string, coin, mode, netw int) bool {
+	for _, spec := range wallet.AddrList {
+		if spec.CoinID != coin {
+			continue
+		}
+		if spec.Conv != nil {
+			return true
+		}
+		if netw < 0 || netw >= len(spec.Formats) || spec.Formats[netw] == nil {
+			return false
+		}
+		format := spec.Formats[netw]
+		// SegWit addresses (Bech32)
+		if mode == wallet.AddrP2WPKH || mode == wallet.AddrP2WSH {
+			return strings.HasPrefix(addr, format.Bech32+"1")
+		}
+		// Base58 addresses: check version prefix
+		if mode < 0 || mode >= len(format.Versions) || format.Versions[mode] == nil {
+			return false
+		}
+		prefix := int(format.Versions[mode].Version)
+		data, err := bitcoin.Base58Decode(addr)
+		if err != nil || len(data) < 25 {
+			return false
+		}
+		if prefix > 255 {
+			return len(data) == 26 && int(data[0])<<8|int(data[1]) == prefix
+		}
+		return len(data) == 25 && int(data[0]) == prefix
+	}
+	return false
+}
+
+// ValidAddress returns true if the address is valid for the coin and
+// network of the handler (in any address mode). Base58 and SegWit addresses
+// are fully checked (including checksum); addresses with coin-specific
+// encodings (like ETH or BCH) are not checked.
+func (hdlr *Handler) ValidAddress(addr string) bool {
+	for _, spec := range wallet.AddrList {
+		if spec.CoinID != hdlr.coin {
+			continue
+		}
+		if spec.Conv != nil {
+			return len(addr) > 0
+		}
+		if _, err := addressScript(addr, hdlr.symb); err != nil {
+			return false
+		}
+		for mode := wallet.AddrP2PKH; mode <= wallet.AddrP2WSH; mode++ {
+			if checkAddress(addr, hdlr.coin, mode, hdlr.netw) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// RequiresMemo returns true if transactions for the coin need a memo
+// (destination tag) to identify the receiver.
+func (hdlr *Handler) RequiresMemo() bool {
+	return hdlr.memo
+}
+
+// Supported returns true if a blockchain handler supports the coin.
+func (hdlr *Handler) Supported() bool {
+	for _, be := range hdlr.all {
+		if !be.unsupp {
+			return true
+		}
+	}
+	return false
+}
+
+// Config returns the configuration of the coin.
+func (hdlr *Handler) Config() *CoinConfig {
+	return hdlr.cfg
+}
+
+// ChainOrder returns the names of the blockchain handlers for the coin
+// (in order of use).
+func (hdlr *Handler) ChainOrder() (names []string) {
+	for _, be := range hdlr.backends() {
+		names = append(names, be.name)
+	}
+	return
+}
+
+// SetChainOrder sets the order of blockchain handlers used for the coin:
+// if a handler fails, the next handler in the list is used. Handlers not
+// listed are not used. All handlers must be configured for the coin.
+func (hdlr *Handler) SetChainOrder(names []string) error {
+	if err := hdlr.cfg.CheckChainOrder(names); err != nil {
+		return err
+	}
+	var order []*chainBackend
+	for _, name := range names {
+		for _, be := range hdlr.all {
+			if be.name == name {
+				order = append(order, be)
+			}
+		}
+	}
+	changed := !slices.Equal(names, hdlr.ChainOrder())
+	hdlr.chLock.Lock()
+	hdlr.chains = order
+	hdlr.chLock.Unlock()
+	if changed {
+		logger.Printf(logger.INFO, "[handler] %s: using blockchain handlers %s", hdlr.symb, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// return current list of blockchain handlers
+func (hdlr *Handler) backends() []*chainBackend {
+	hdlr.chLock.RLock()
+	defer hdlr.chLock.RUnlock()
+	return hdlr.chains
+}
+
+// call blockchain handlers (in order of use) until a call succeeds. Handlers
+// that don't support the coin (or operation) or are unavailable (circuit
+// breaker) are skipped.
+func (hdlr *Handler) failover(ctx context.Context, op string, call func(ctx context.Context, chain ChainHandler) error) error {
+	err := ErrNotImplemented
+	for _, be := range hdlr.backends() {
+		if be.unsupp {
+			continue
+		}
+		// skip call if blockchain handler is unavailable
+		if !be.breaker.Allow() {
+			if err == ErrNotImplemented {
+				err = ErrHdlrUnavailable
+			}
+			continue
+		}
+		cctx, span := StartSpan(ctx, op, Attr("coin", hdlr.symb), Attr("handler", be.name))
+		res := call(withCoin(WithHTTPClient(cctx, hdlr.client), hdlr.symb), be.chain)
+		span.End(res)
+		if errors.Is(res, ErrNotImplemented) {
+			continue
+		}
+		recordResult(be.name, res)
+		be.breaker.Record(res)
+		if res == nil {
+			return nil
+		}
+		Logf(ctx, logger.DBG, "[handler] %s: %s failed on '%s': %s", hdlr.symb, op, be.name, res.Error())
+		err = res
+	}
+	return err
 }
 
 // GetBalance returns the balance for a given address
-func (hdlr *Handler) GetBalance(ctx context.Context, addr string) (float64, error) {
-	// call balance function
-	return hdlr.chain.Balance(ctx, addr, hdlr.symb)
+func (hdlr *Handler) GetBalance(ctx context.Context, addr string) (balance float64, err error) {
+	err = hdlr.failover(ctx, "chain.balance", func(ctx context.Context, chain ChainHandler) (err error) {
+		balance, err = chain.Balance(ctx, addr, hdlr.symb)
+		return
+	})
+	return
+}
+
+// HasUnconfirmed returns true if the handler reports unconfirmed balances.
+func (hdlr *Handler) HasUnconfirmed() bool {
+	if !hdlr.unconf {
+		return false
+	}
+	for _, be := range hdlr.backends() {
+		if _, ok := be.chain.(UnconfirmedHandler); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// time-to-live of cached unconfirmed balances and max. number of entries
+var (
+	unconfTTL = 30 * time.Second
+	unconfMax = 10000
+)
+
+// GetUnconfirmed returns the unconfirmed (mempool) balance of an address.
+// Balances are cached for a short time, so polling clients don't cause a
+// blockchain handler request each time.
+func (hdlr *Handler) GetUnconfirmed(ctx context.Context, addr string) (balance float64, err error) {
+	if !hdlr.HasUnconfirmed() {
+		return 0, ErrHdlrNoUnconf
+	}
+	// check cache
+	now := time.Now()
+	hdlr.ucLock.Lock()
+	if ub, ok := hdlr.ucache[addr]; ok && now.Before(ub.expires) {
+		hdlr.ucLock.Unlock()
+		return ub.balance, nil
+	}
+	hdlr.ucLock.Unlock()
+
+	// query blockchain handlers
+	err = hdlr.failover(ctx, "chain.unconfirmed", func(ctx context.Context, chain ChainHandler) (err error) {
+		uh, ok := chain.(UnconfirmedHandler)
+		if !ok {
+			return ErrNotImplemented
+		}
+		balance, err = uh.Unconfirmed(ctx, addr, hdlr.symb)
+		return
+	})
+	if err != nil {
+		return
+	}
+	// cache balance (drop expired entries if the cache is full)
+	hdlr.ucLock.Lock()
+	defer hdlr.ucLock.Unlock()
+	if hdlr.ucache == nil {
+		hdlr.ucache = make(unconfCache)
+	}
+	if len(hdlr.ucache) >= unconfMax {
+		for a, ub := range hdlr.ucache {
+			if !now.Before(ub.expires) {
+				delete(hdlr.ucache, a)
+			}
+		}
+		if len(hdlr.ucache) >= unconfMax {
+			hdlr.ucache = make(unconfCache)
+		}
+	}
+	hdlr.ucache[addr] = unconfBalance{balance: balance, expires: now.Add(unconfTTL)}
+	return
 }
 
 // GetTxList returns a list of transaction for an address
 func (hdlr *Handler) GetFunds(ctx context.Context, addrId int64, addr string) ([]*Fund, error) {
-	// call reporting function
-	return hdlr.chain.GetFunds(ctx, addrId, addr, hdlr.symb)
+	var funds []*Fund
+	err := hdlr.failover(ctx, "chain.funds", func(ctx context.Context, chain ChainHandler) (err error) {
+		funds, err = chain.GetFunds(ctx, addrId, addr, hdlr.symb)
+		return
+	})
+	if err != nil || (len(hdlr.script) == 0 && !hdlr.noCB) {
+		return funds, err
+	}
+	list := make([]*Fund, 0, len(funds))
+	for _, f := range funds {
+		// skip funds from coinbase transactions
+		if hdlr.noCB && f.Coinbase {
+			continue
+		}
+		// flag funds with unexpected script type
+		if len(hdlr.script) > 0 && len(f.Script) > 0 && f.Script != hdlr.script {
+			Logf(ctx, logger.WARN, "Funds on '%s' with script type %s (expected %s)", addr, f.Script, hdlr.script)
+			f.Mismatch = true
+		}
+		list = append(list, f)
+	}
+	return list, nil
 }
 
 //----------------------------------------------------------------------
@@ -126,20 +533,8 @@ func (hdlr *Handler) GetFunds(ctx context.Context, addrId int64, addr string) ([
 
 func InitHandlers(cfg *Config, mdl *Model) (coins []string, err error) {
 
-	// initialize shared handler instances:
-	// ------------------------------------
-	// (1) blockchain handlers
-	for name, hdlrCfg := range cfg.Handler.Blockchain {
-		if hdlr, ok := baseChainHdlrs[name]; ok {
-			hdlr.Init(hdlrCfg)
-		}
-	}
-	// (2) market handlers
-	for name, hdlrCfg := range cfg.Handler.Market.Service {
-		if hdlr, ok := baseMarketHdlrs[name]; ok {
-			hdlr.Init(hdlrCfg)
-		}
-	}
+	// initialize shared handler instances
+	InitSharedHandlers(cfg.Handler)
 
 	// load actual coin handlers; assemble list of coin symbols
 	for _, coin := range cfg.Coins {
@@ -147,37 +542,111 @@ func InitHandlers(cfg *Config, mdl *Model) (coins []string, err error) {
 		if _, err = mdl.GetCoin(coin.Symb); err != nil {
 			return
 		}
+		// use configured display name and color (GUI)
+		if err = mdl.SetCoinDisplay(coin.Symb, coin.Label, coin.Color); err != nil {
+			return
+		}
 		// add to list of coins
 		coins = append(coins, coin.Symb)
 		// get coin handler
 		var hdlr *Handler
-		if hdlr, err = NewHandler(coin, wallet.NetwMain); err != nil {
+		if hdlr, err = NewHandler(coin, cfg.NetworkID()); err != nil {
 			return
 		}
+		if !hdlr.Supported() {
+			logger.Printf(logger.WARN, "[handler] %s: coin not supported by blockchain handlers %v (balances are not checked)",
+				coin.Symb, coin.ChainHandlers())
+		}
+		if cfg.Derive != nil && len(cfg.Derive.URL) > 0 {
+			hdlr.derive = cfg.Derive
+		}
+
 		// verify handler
 		var addr string
 		if addr, err = hdlr.GetAddress(0); err != nil {
 			return
 		}
-		if addr != coin.Addr {
+		if !SameAddress(addr, coin.Addr) {
 			err = fmt.Errorf("addr mismatch: %s != %s", addr, coin.Addr)
 			return
 		}
 		// save handler
 		HdlrList[coin.Symb] = hdlr
 	}
+	// register coin aliases
+	for alias, symb := range cfg.Aliases {
+		coinAliases[strings.ToLower(alias)] = symb
+	}
+	// use stored order of blockchain handlers
+	err = ApplyChainOrders(mdl)
 	return
 }
 
+// ApplyChainOrders sets the order of blockchain handlers for all coins as
+// stored in the model (operator changes at runtime). Coins without (or
+// with an invalid) stored order use the configured order.
+func ApplyChainOrders(mdl *Model) error {
+	orders, err := mdl.GetChainOrders()
+	if err != nil {
+		return err
+	}
+	for symb, hdlr := range HdlrList {
+		names, ok := orders[symb]
+		if ok {
+			if err = hdlr.SetChainOrder(names); err == nil {
+				continue
+			}
+			logger.Printf(logger.WARN, "[handler] %s: stored blockchain handlers: %s", symb, err.Error())
+		}
+		hdlr.SetChainOrder(hdlr.cfg.ChainHandlers())
+	}
+	return nil
+}
+
+// CoinSymbol resolves an alternative coin symbol (like 'xbt' for 'btc')
+// to the canonical coin symbol. Aliases are matched case-insensitive;
+// unknown symbols are returned unchanged.
+func CoinSymbol(symb string) string {
+	if canon, ok := coinAliases[strings.ToLower(symb)]; ok {
+		return canon
+	}
+	return symb
+}
+
+// InitSharedHandlers initializes the shared handler instances (used by
+// all coins) from the handler configuration.
+func InitSharedHandlers(cfg *HandlerConfig) {
+	// (1) blockchain handlers
+	for name, hdlrCfg := range cfg.Blockchain {
+		if hdlr, ok := chainHandler(name); ok {
+			hdlr.Init(hdlrCfg)
+			chainBreakers[name] = newBreaker(name, hdlrCfg.BreakAfter, hdlrCfg.BreakTime)
+		}
+	}
+	// (2) market handlers
+	if cfg.Market == nil {
+		return
+	}
+	estimateRates = cfg.Market.Estimate
+	maxDeviation = cfg.Market.MaxDev
+	for name, hdlrCfg := range cfg.Market.Service {
+		if hdlr, ok := baseMarketHdlrs[name]; ok {
+			hdlr.Init(hdlrCfg)
+		}
+	}
+	useMarketHandlers(cfg.Market.Service)
+}
+
 //----------------------------------------------------------------------
 // helper functions
 
-// GetNetwork returns the numeric coin network ID
+// GetNetwork returns the numeric coin network ID. Signet and testnet4 use
+// the same address formats as testnet (and map to it).
 func GetNetwork(netw string) int {
 	switch strings.ToLower(netw) {
 	case "main":
 		return wallet.NetwMain
-	case "test":
+	case "test", "signet", "testnet4":
 		return wallet.NetwTest
 	case "reg":
 		return wallet.NetwReg
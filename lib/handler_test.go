@@ -0,0 +1,161 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/bitcoin/wallet"
+)
+
+// stub blockchain handler with unconfirmed balances (counts requests)
+type stubChainHandler struct {
+	calls   int
+	balance float64
+}
+
+func (hdlr *stubChainHandler) Init(cfg *ChainHandlerConfig) {}
+
+func (hdlr *stubChainHandler) Balance(ctx context.Context, addr, coin string) (float64, error) {
+	return 0, ErrNotImplemented
+}
+
+func (hdlr *stubChainHandler) GetFunds(ctx context.Context, addrId int64, addr, coin string) ([]*Fund, error) {
+	return nil, ErrNotImplemented
+}
+
+func (hdlr *stubChainHandler) Unconfirmed(ctx context.Context, addr, coin string) (float64, error) {
+	hdlr.calls++
+	return hdlr.balance, nil
+}
+
+func TestUnconfirmedCache(t *testing.T) {
+	stub := &stubChainHandler{balance: 0.5}
+	hdlr := &Handler{
+		symb:   "btc",
+		unconf: true,
+		chains: []*chainBackend{{name: "stub", chain: stub}},
+	}
+	ctx := context.Background()
+	get := func(addr string, want float64, calls int) {
+		t.Helper()
+		bal, err := hdlr.GetUnconfirmed(ctx, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bal != want || stub.calls != calls {
+			t.Errorf("%s: balance %f after %d requests, want %f after %d", addr, bal, stub.calls, want, calls)
+		}
+	}
+	// repeated polls are served from cache (per address)
+	get("addr1", 0.5, 1)
+	stub.balance = 0.7
+	get("addr1", 0.5, 1)
+	get("addr2", 0.7, 2)
+
+	// expired entries are refreshed
+	saved := unconfTTL
+	unconfTTL = time.Millisecond
+	t.Cleanup(func() { unconfTTL = saved })
+	get("addr3", 0.7, 3)
+	time.Sleep(2 * time.Millisecond)
+	stub.balance = 0.9
+	get("addr3", 0.9, 4)
+}
+
+// BIP32 test vector 1 (master public key)
+const testXpub = "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+
+func TestDeriveAddressNetwork(t *testing.T) {
+	pk, err := wallet.ParseExtendedPublicKey(testXpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coin, _ := wallet.GetCoinInfo("btc")
+	for _, tc := range []struct {
+		mode     int
+		netw     int
+		prefixes []string
+	}{
+		{wallet.AddrP2PKH, wallet.NetwMain, []string{"1"}},
+		{wallet.AddrP2PKH, wallet.NetwTest, []string{"m", "n"}},
+		{wallet.AddrP2WPKH, wallet.NetwMain, []string{"bc1"}},
+		{wallet.AddrP2WPKH, wallet.NetwTest, []string{"tb1"}},
+	} {
+		hdlr := &Handler{
+			coin:    coin,
+			mode:    tc.mode,
+			netw:    tc.netw,
+			tree:    wallet.NewHDPublic(pk, "m"),
+			pathTpl: "m/0/%d",
+		}
+		addr, err := hdlr.DeriveAddress(0)
+		if err != nil {
+			t.Fatalf("mode %d, network %d: %s", tc.mode, tc.netw, err.Error())
+		}
+		ok := false
+		for _, prefix := range tc.prefixes {
+			ok = ok || strings.HasPrefix(addr, prefix)
+		}
+		if !ok {
+			t.Errorf("mode %d, network %d: unexpected address %s", tc.mode, tc.netw, addr)
+		}
+		// address must not validate on the other network
+		if checkAddress(addr, coin, tc.mode, 1-tc.netw) {
+			t.Errorf("mode %d, network %d: %s valid on other network", tc.mode, tc.netw, addr)
+		}
+	}
+}
+
+// BIP84 test vector (account 0)
+const testZpub = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+
+func TestKeyVersion(t *testing.T) {
+	for _, tc := range []struct {
+		pk, mode string
+		anyVers  bool
+		ok       bool
+	}{
+		{testXpub, "P2PKH", false, true},
+		{testXpub, "", false, true}, // default mode P2PKH
+		{testZpub, "P2WPKH", false, true},
+		{testZpub, "P2PKH", false, false},
+		{testZpub, "", false, false},
+		{testXpub, "P2WPKH", false, false},
+		{testZpub, "P2PKH", true, true}, // mismatch accepted
+	} {
+		coin := &CoinConfig{
+			Symb:       "btc",
+			Path:       "m/84'/0'/0'",
+			Mode:       tc.mode,
+			Pk:         tc.pk,
+			AnyVersion: tc.anyVers,
+			Blockchain: "mempool.space",
+		}
+		_, err := NewHandler(coin, wallet.NetwMain)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s... with mode '%s' (anyVersion=%v): err=%v", tc.pk[:4], tc.mode, tc.anyVers, err)
+		}
+	}
+}
@@ -22,74 +22,260 @@ package lib
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bfix/gospel/logger"
 )
 
 // GetMarketData returns the current rates for given currencies.
-func GetMarketData(ctx context.Context, mdl *Model, fiat string, date int64, coins []string) (map[string]float64, error) {
-	// we only have one handler at the moment...
-	hdlr, ok := baseMarketHdlrs["coinapi.io"]
-	if !ok {
-		return nil, fmt.Errorf("no market handler found")
-	}
+func GetMarketData(ctx context.Context, mdl *Model, fiat string, date int64, coins []string) (rates map[string]float64, err error) {
+	// trace market query
+	ctx, span := StartSpan(ctx, "market.rates", Attr("fiat", fiat), Attr("coins", strings.Join(coins, ",")))
+	defer func() { span.End(err) }()
+
 	// check if current or historical rates are requested
 	if date < 0 {
 		// fetch current rates
-		rates, err := hdlr.CurrentRates(ctx, fiat, coins)
+		rates, err = currentRates(ctx, fiat, coins)
+		if errors.Is(err, ErrMarketPaused) {
+			// use stored rates while all handlers are paused
+			logger.Println(logger.DBG, "Market handlers paused: using stored rates")
+			return storedRates(mdl, fiat, coins), nil
+		}
 		if err != nil {
 			return nil, err
 		}
 		// update rates in coin and rates tables
 		logger.Printf(logger.INFO, "Updating market data (%d entries)", len(rates))
 		dt := time.Now().Format("2006-01-02")
+		force := forceRates.Swap(false)
 		for coin, rate := range rates {
 			logger.Printf(logger.DBG, "    * %s: %f", coin, rate)
-			if err := mdl.UpdateRate(dt, coin, fiat, rate); err != nil {
+			if !force {
+				if last, ok := saneRate(mdl, coin, rate); !ok {
+					// keep last rate (and don't estimate a replacement)
+					rates[coin] = last
+					continue
+				}
+			}
+			if err := mdl.UpdateRate(dt, coin, fiat, rate, false); err != nil {
 				logger.Println(logger.ERROR, "UpdateRate: "+err.Error())
 			}
 		}
+		// estimate rates for coins without direct fiat pair
+		if estimateRates {
+			for coin, rate := range estimatedRates(ctx, mdl, dt, fiat, coins, rates) {
+				logger.Printf(logger.DBG, "    * %s: %f (estimated)", coin, rate)
+				if !force {
+					if last, ok := saneRate(mdl, coin, rate); !ok {
+						rates[coin] = last
+						continue
+					}
+				}
+				if err := mdl.UpdateRate(dt, coin, fiat, rate, true); err != nil {
+					logger.Println(logger.ERROR, "UpdateRate: "+err.Error())
+				}
+				rates[coin] = rate
+			}
+		}
 		return rates, nil
 	}
-	// retrieve historical rates
+	// retrieve historical rates (concurrently for all coins)
+	return historicalRates(ctx, mdl, date, fiat, coins), nil
+}
+
+// storedRates returns the last known rates of coins from the rates table
+// (or the coin table). Coins without a stored rate are not included.
+func storedRates(mdl *Model, fiat string, coins []string) map[string]float64 {
+	dt := time.Now().Format("2006-01-02")
 	rates := make(map[string]float64)
 	for _, coin := range coins {
-		// check rates table first
-		dt := time.Unix(date, 0).Format("2006-01-02")
-		rate, err := mdl.GetRate(dt, coin, fiat)
-		if err != nil {
-			logger.Println(logger.ERROR, "GetRate: "+err.Error())
+		if rate, err := mdl.GetRate(dt, coin, fiat); err == nil && rate > 0 {
+			rates[coin] = rate
 			continue
 		}
-		if rate < 0 {
-			// not in rates table: query market handler.
-			if rate, err = hdlr.HistoricalRate(ctx, date, fiat, coin); err != nil {
-				logger.Println(logger.ERROR, "HistoricalRate: "+err.Error())
-				continue
+		if ci, err := mdl.GetCoin(coin); err == nil && ci.Rate > 0 {
+			rates[coin] = ci.Rate
+		}
+	}
+	return rates
+}
+
+// estimate missing rates via BTC (coin->BTC->fiat)?
+var estimateRates = false
+
+// max. factor a new rate may deviate from the last rate (0 = unchecked)
+var maxDeviation = 0.
+
+// accept the next rates unchecked?
+var forceRates atomic.Bool
+
+// ForceRates disables the sanity check of rates for the next market update
+// (like after a real price change beyond the allowed deviation).
+func ForceRates() {
+	forceRates.Store(true)
+}
+
+// saneRate returns true if a new (current) rate for a coin does not deviate
+// from the last known rate by more than the configured factor. Rejected
+// rates are logged; the last rate is returned to be kept instead.
+func saneRate(mdl *Model, coin string, rate float64) (float64, bool) {
+	if maxDeviation <= 0 {
+		return rate, true
+	}
+	ci, err := mdl.GetCoin(coin)
+	if err != nil || ci.Rate <= 0 {
+		// no last rate to compare with
+		return rate, true
+	}
+	if rate <= 0 || rate > ci.Rate*maxDeviation || rate < ci.Rate/maxDeviation {
+		logger.Printf(logger.WARN, "Rejected rate for %s: %f (last rate %f)", coin, rate, ci.Rate)
+		return ci.Rate, false
+	}
+	return rate, true
+}
+
+// estimatedRates returns estimated rates for coins that have no (direct)
+// fiat rate: the rate is computed from the coin price in BTC and the BTC
+// price in fiat (taken from the current or stored rates).
+func estimatedRates(ctx context.Context, mdl *Model, dt, fiat string, coins []string, rates map[string]float64) map[string]float64 {
+	// collect coins without rate
+	var missing []string
+	for _, coin := range coins {
+		if coin != "btc" && rates[coin] <= 0 {
+			missing = append(missing, coin)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	// get BTC rate in fiat
+	btcRate, ok := rates["btc"]
+	if !ok || btcRate <= 0 {
+		var err error
+		if btcRate, err = mdl.GetRate(dt, "btc", fiat); err != nil || btcRate <= 0 {
+			logger.Printf(logger.WARN, "No BTC rate in %s: can't estimate rates", fiat)
+			return nil
+		}
+	}
+	// get coin prices in BTC
+	btcRates, err := currentRates(ctx, "BTC", missing)
+	if errors.Is(err, ErrMarketPaused) {
+		return nil
+	}
+	if err != nil {
+		logger.Println(logger.ERROR, "Estimating rates: "+err.Error())
+		return nil
+	}
+	list := make(map[string]float64)
+	for coin, rate := range btcRates {
+		if rate > 0 {
+			list[coin] = rate * btcRate
+		}
+	}
+	return list
+}
+
+// maximum number of concurrent historical rate lookups
+const maxRateWorkers = 4
+
+// historicalRates returns the exchange rates of coins for a given date.
+// Rates are taken from the rates table (which acts as a cache for the
+// market handler); missing rates are queried from the market handler and
+// stored in the table. Coins without a rate are not included in the result.
+func historicalRates(ctx context.Context, mdl *Model, date int64, fiat string, coins []string) map[string]float64 {
+	dt := time.Unix(date, 0).Format("2006-01-02")
+	rates := make(map[string]float64)
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	sem := make(chan struct{}, maxRateWorkers)
+	for _, coin := range coins {
+		wg.Add(1)
+		go func(coin string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// check rates table first
+			rate, err := mdl.GetRate(dt, coin, fiat)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				logger.Println(logger.ERROR, "GetRate: "+err.Error())
+				return
 			}
-			// add rate to table
-			if err = mdl.SetRate(dt, coin, fiat, rate); err != nil {
+			if rate < 0 {
+				// not in rates table: query market handler.
+				rate, err = marketQuery(func(hdlr MarketHandler) (float64, error) {
+					return hdlr.HistoricalRate(ctx, date, fiat, coin)
+				})
+				if errors.Is(err, ErrMarketPaused) {
+					return
+				}
+				if err != nil {
+					logger.Println(logger.ERROR, "HistoricalRate: "+err.Error())
+					return
+				}
+				// add rate to table
+				if err = mdl.SetRate(dt, coin, fiat, rate); err != nil {
+					logger.Println(logger.ERROR, "SetRate: "+err.Error())
+				}
+			}
+			lock.Lock()
+			rates[coin] = rate
+			lock.Unlock()
+		}(coin)
+	}
+	wg.Wait()
+	return rates
+}
+
+// UpdateFiatRates retrieves the current rates for additional fiat currencies
+// (used by accounts with their own fiat currency). The rates are only stored
+// in the rates table; the coin rates (in default fiat currency) are left
+// untouched.
+func UpdateFiatRates(ctx context.Context, mdl *Model, fiats []string, coins []string) error {
+	dt := time.Now().Format("2006-01-02")
+	for _, fiat := range fiats {
+		// fetch current rates
+		rates, err := currentRates(ctx, fiat, coins)
+		if errors.Is(err, ErrMarketPaused) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// update rates table
+		logger.Printf(logger.INFO, "Updating market data for %s (%d entries)", fiat, len(rates))
+		for coin, rate := range rates {
+			if err := mdl.SetRate(dt, coin, fiat, rate); err != nil {
 				logger.Println(logger.ERROR, "SetRate: "+err.Error())
 			}
 		}
-		rates[coin] = rate
 	}
-	return rates, nil
+	return nil
 }
 
 //======================================================================
 // Market handlers
 //======================================================================
 
+// ErrMarketPaused is returned by market handlers that don't accept
+// requests for a while (like after exhausting their credits).
+var ErrMarketPaused = fmt.Errorf("market handler paused")
+
 // MarketHandler retrieves (historical) exchange rates for coins
 type MarketHandler interface {
 	Init(cfg *MarketHandlerConfig)
@@ -101,24 +287,134 @@ var (
 	// map of base market handlers
 	baseMarketHdlrs = map[string]MarketHandler{
 		"coinapi.io": new(CoinapiMarketHandler),
+		"coingecko":  new(CoinGeckoMarketHandler),
 	}
+
+	// names of the market handlers in use (in order of priority)
+	marketHdlrs []string
 )
 
+// IsMarketHandler returns true if a market handler with given name exists.
+func IsMarketHandler(name string) bool {
+	_, ok := baseMarketHdlrs[name]
+	return ok
+}
+
+// MarketKeyRequired returns true if the named market handler can't be
+// used without an API key.
+func MarketKeyRequired(name string) bool {
+	return name != "coingecko"
+}
+
+// useMarketHandlers sets the order of the configured market services: a
+// lower priority value is tried first; services with the same priority
+// are ordered by name.
+func useMarketHandlers(services map[string]*MarketHandlerConfig) {
+	marketHdlrs = marketHdlrs[:0]
+	for name := range services {
+		if IsMarketHandler(name) {
+			marketHdlrs = append(marketHdlrs, name)
+		}
+	}
+	slices.SortFunc(marketHdlrs, func(a, b string) int {
+		if d := services[a].Priority - services[b].Priority; d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+}
+
+// marketQuery runs a query on the market handlers in order of priority
+// and returns the first successful result. If all handlers fail, the
+// errors are returned (ErrMarketPaused if all handlers are paused).
+func marketQuery[T any](query func(hdlr MarketHandler) (T, error)) (res T, err error) {
+	if len(marketHdlrs) == 0 {
+		err = fmt.Errorf("no market handler found")
+		return
+	}
+	var errs []error
+	paused := 0
+	for _, name := range marketHdlrs {
+		if res, err = query(baseMarketHdlrs[name]); err == nil {
+			recordResult(name, nil)
+			return
+		}
+		if errors.Is(err, ErrMarketPaused) {
+			paused++
+			continue
+		}
+		recordResult(name, err)
+		logger.Printf(logger.WARN, "Market handler '%s' failed: %s", name, err.Error())
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	if paused == len(marketHdlrs) {
+		err = ErrMarketPaused
+		return
+	}
+	err = errors.Join(errs...)
+	return
+}
+
+// currentRates returns the current rates from the market handlers.
+func currentRates(ctx context.Context, fiat string, coins []string) (map[string]float64, error) {
+	return marketQuery(func(hdlr MarketHandler) (map[string]float64, error) {
+		return hdlr.CurrentRates(ctx, fiat, coins)
+	})
+}
+
 //----------------------------------------------------------------------
 // CoinAPI.io
 //----------------------------------------------------------------------
 
 // CoinapiMarketHandler handles exchange rate requests
 type CoinapiMarketHandler struct {
-	credits int64      // number of credits available
-	apiKey  string     // API key for access
-	lock    sync.Mutex // serializer
+	credits int64         // number of credits available
+	reserve int64         // pause if credits drop to reserve
+	reset   time.Duration // duration of pause
+	paused  time.Time     // paused until
+	apiKey  string        // API key for access
+	lock    sync.Mutex    // guards credits and pause state
 }
 
+// default pause on exhausted credits
+const coinapiResetTime = time.Hour
+
 // Init handler from configuration
 func (hdlr *CoinapiMarketHandler) Init(cfg *MarketHandlerConfig) {
 	hdlr.apiKey = cfg.ApiKey
 	hdlr.credits = 10
+	hdlr.reserve = cfg.Reserve
+	hdlr.reset = coinapiResetTime
+	if cfg.ResetTime > 0 {
+		hdlr.reset = time.Duration(cfg.ResetTime) * time.Second
+	}
+}
+
+// available returns an error if the handler is paused.
+func (hdlr *CoinapiMarketHandler) available() error {
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+	if time.Now().Before(hdlr.paused) {
+		return ErrMarketPaused
+	}
+	return nil
+}
+
+// updateCredits extracts the available credits from a response and
+// pauses the handler if the credits drop to the reserve.
+func (hdlr *CoinapiMarketHandler) updateCredits(resp *http.Response) {
+	credits, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Remaining"), 10, 64)
+	if err != nil {
+		return
+	}
+	hdlr.lock.Lock()
+	defer hdlr.lock.Unlock()
+	hdlr.credits = credits
+	if credits <= hdlr.reserve {
+		hdlr.paused = time.Now().Add(hdlr.reset)
+		logger.Printf(logger.WARN, "coinapi.io: %d credits left - pausing market requests until %s",
+			credits, hdlr.paused.Format(time.RFC3339))
+	}
 }
 
 // CurrentRates returns the current exchange rates for a given list of coins.
@@ -127,45 +423,24 @@ func (hdlr *CoinapiMarketHandler) CurrentRates(
 	fiat string,
 	coins []string) (map[string]float64, error) {
 
-	// serialize requests
-	hdlr.lock.Lock()
-	defer hdlr.lock.Unlock()
-
-	// handle all coins at once (current exchange rate)
-	query := fmt.Sprintf("https://rest.coinapi.io/v1/exchangerate/%s", fiat)
-	client := &http.Client{}
-	toCtx, cancel := context.WithTimeout(ctx, time.Minute)
-	defer cancel()
-	req, err := http.NewRequestWithContext(toCtx, "GET", query, nil)
-	if err != nil {
+	if err := hdlr.available(); err != nil {
 		return nil, err
 	}
+
+	// handle all coins at once (current exchange rate)
 	q := url.Values{}
 	q.Add("filter_asset_id", strings.Join(coins, ","))
-	req.Header.Set("Accepts", "application/json")
-	req.Header.Add("X-CoinAPI-Key", hdlr.apiKey)
-	req.URL.RawQuery = q.Encode()
-
-	// send query and receive response
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	// extract available credits
-	hdlr.credits, _ = strconv.ParseInt(resp.Header.Get("X-RateLimit-Remaining"), 10, 64)
-
-	// parse response
 	data := new(CoinapiMarketMultiResponse)
-	if err := json.Unmarshal(body, &data); err != nil {
+	if err := hdlr.query(ctx, "/exchangerate/"+fiat, q, data); err != nil {
 		return nil, err
 	}
-	// assemble result
+	// assemble result (skip invalid rates)
 	list := make(map[string]float64)
 	for _, rate := range data.Rates {
+		if rate.Rate <= 0 {
+			logger.Printf(logger.WARN, "coinapi.io: invalid rate %f for '%s'", rate.Rate, rate.Coin)
+			continue
+		}
 		list[strings.ToLower(rate.Coin)] = 1. / rate.Rate
 	}
 	return list, nil
@@ -178,40 +453,56 @@ func (hdlr *CoinapiMarketHandler) HistoricalRate(
 	fiat string,
 	coin string) (float64, error) {
 
-	// serialize requests
-	hdlr.lock.Lock()
-	defer hdlr.lock.Unlock()
+	if err := hdlr.available(); err != nil {
+		return -1, err
+	}
 
+	// query rate
+	q := url.Values{}
+	q.Add("time", time.Unix(date, 0).UTC().Format("2006-01-02T15:04:05Z"))
+	data := new(CoinapiMarketResponse)
+	if err := hdlr.query(ctx, "/exchangerate/"+strings.ToUpper(coin)+"/"+fiat, q, data); err != nil {
+		return -1, err
+	}
+	if data.Rate <= 0 {
+		return -1, fmt.Errorf("coinapi.io: no %s rate for '%s'", fiat, coin)
+	}
+	return data.Rate, nil
+}
+
+// query coinapi.io endpoint and parse the JSON response. Responses with
+// a status other than 2xx are returned as HTTPStatusError.
+func (hdlr *CoinapiMarketHandler) query(ctx context.Context, path string, q url.Values, data any) error {
 	// assemble query
-	query := fmt.Sprintf("https://rest.coinapi.io/v1/exchangerate/%s/%s?time=%s",
-		strings.ToUpper(coin), fiat, time.Unix(date, 0).Format("2006-01-02T15:04:05Z"))
+	query := "https://rest.coinapi.io/v1" + path
 	client := &http.Client{}
 	toCtx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 	req, err := http.NewRequestWithContext(toCtx, "GET", query, nil)
 	if err != nil {
-		return -1, err
+		return err
 	}
 	req.Header.Set("Accepts", "application/json")
 	req.Header.Add("X-CoinAPI-Key", hdlr.apiKey)
+	req.URL.RawQuery = q.Encode()
 
 	// send query and receive response
 	resp, err := client.Do(req)
 	if err != nil {
-		return -1, err
+		return err
 	}
+	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return -1, err
+		return err
 	}
 	// extract available credits
-	hdlr.credits, _ = strconv.ParseInt(resp.Header.Get("X-RateLimit-Remaining"), 10, 64)
-	// parse response
-	data := new(CoinapiMarketResponse)
-	if err := json.Unmarshal(body, &data); err != nil {
-		return -1, err
+	hdlr.updateCredits(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPStatusError{Code: resp.StatusCode, Status: resp.Status, Body: body}
 	}
-	return data.Rate, nil
+	// parse response
+	return json.Unmarshal(body, data)
 }
 
 // CoinapiMarketMultiResponse is a response for mult-coin queries
@@ -231,3 +522,141 @@ type CoinapiMarketResponse struct {
 	Fiat string  `json:"asset_id_base"`
 	Rate float64 `json:"rate"`
 }
+
+//----------------------------------------------------------------------
+// CoinGecko
+//----------------------------------------------------------------------
+
+// CoinGeckoMarketHandler handles exchange rate requests using the (free)
+// CoinGecko API. An API key ("demo" key) is optional.
+type CoinGeckoMarketHandler struct {
+	apiKey string // API key for access (optional)
+}
+
+var (
+	// map coin ticker into coin id used by CoinGecko
+	cgCoinMap = map[string]string{
+		"bch":  "bitcoin-cash",
+		"btc":  "bitcoin",
+		"btg":  "bitcoin-gold",
+		"dash": "dash",
+		"dgb":  "digibyte",
+		"doge": "dogecoin",
+		"etc":  "ethereum-classic",
+		"eth":  "ethereum",
+		"ltc":  "litecoin",
+		"nmc":  "namecoin",
+		"vtc":  "vertcoin",
+		"zec":  "zcash",
+	}
+)
+
+// Init handler from configuration
+func (hdlr *CoinGeckoMarketHandler) Init(cfg *MarketHandlerConfig) {
+	hdlr.apiKey = cfg.ApiKey
+}
+
+// CurrentRates returns the current exchange rates for a given list of coins.
+// Coins unknown to CoinGecko are not included in the result.
+func (hdlr *CoinGeckoMarketHandler) CurrentRates(
+	ctx context.Context,
+	fiat string,
+	coins []string) (map[string]float64, error) {
+
+	// map coins to CoinGecko ids
+	ids := make(map[string]string)
+	var list []string
+	for _, coin := range coins {
+		if id, ok := cgCoinMap[strings.ToLower(coin)]; ok {
+			ids[id] = strings.ToLower(coin)
+			list = append(list, id)
+		}
+	}
+	if len(list) == 0 {
+		return map[string]float64{}, nil
+	}
+	// handle all coins at once (current exchange rate)
+	q := url.Values{}
+	q.Add("ids", strings.Join(list, ","))
+	q.Add("vs_currencies", strings.ToLower(fiat))
+	data := make(map[string]map[string]float64)
+	if err := hdlr.query(ctx, "/simple/price", q, &data); err != nil {
+		return nil, err
+	}
+	// assemble result
+	rates := make(map[string]float64)
+	for id, prices := range data {
+		if rate, ok := prices[strings.ToLower(fiat)]; ok && rate > 0 {
+			rates[ids[id]] = rate
+		}
+	}
+	return rates, nil
+}
+
+// HistoricalRate returns the exchange rates for a given date and coin.
+func (hdlr *CoinGeckoMarketHandler) HistoricalRate(
+	ctx context.Context,
+	date int64,
+	fiat string,
+	coin string) (float64, error) {
+
+	id, ok := cgCoinMap[strings.ToLower(coin)]
+	if !ok {
+		return -1, fmt.Errorf("coin '%s' unknown to CoinGecko", coin)
+	}
+	q := url.Values{}
+	q.Add("date", time.Unix(date, 0).UTC().Format("02-01-2006"))
+	q.Add("localization", "false")
+	data := new(CoinGeckoHistoryResponse)
+	if err := hdlr.query(ctx, "/coins/"+id+"/history", q, data); err != nil {
+		return -1, err
+	}
+	rate, ok := data.MarketData.Prices[strings.ToLower(fiat)]
+	if !ok || rate <= 0 {
+		return -1, fmt.Errorf("no %s rate for '%s'", fiat, coin)
+	}
+	return rate, nil
+}
+
+// query CoinGecko API endpoint and parse the JSON response
+func (hdlr *CoinGeckoMarketHandler) query(ctx context.Context, path string, q url.Values, data any) error {
+	// assemble query
+	query := "https://api.coingecko.com/api/v3" + path
+	client := &http.Client{}
+	toCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(toCtx, "GET", query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accepts", "application/json")
+	if len(hdlr.apiKey) > 0 {
+		req.Header.Add("x-cg-demo-api-key", hdlr.apiKey)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	// send query and receive response
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CoinGecko: %s", resp.Status)
+	}
+	// parse response
+	return json.Unmarshal(body, data)
+}
+
+// CoinGeckoHistoryResponse is a response for historical coin data
+type CoinGeckoHistoryResponse struct {
+	ID         string `json:"id"`
+	Symbol     string `json:"symbol"`
+	MarketData struct {
+		Prices map[string]float64 `json:"current_price"`
+	} `json:"market_data"`
+}
@@ -0,0 +1,194 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stub market handler (counts requests per coin and concurrent requests)
+type stubMarketHandler struct {
+	lock    sync.Mutex
+	calls   map[string]int
+	active  int
+	maxSeen int
+
+	current map[string]map[string]float64 // current rates (per fiat)
+	queried map[string][]string           // coins of current rate requests (per fiat)
+}
+
+func (hdlr *stubMarketHandler) Init(cfg *MarketHandlerConfig) {
+	hdlr.calls = make(map[string]int)
+	hdlr.current = make(map[string]map[string]float64)
+	hdlr.queried = make(map[string][]string)
+}
+
+func (hdlr *stubMarketHandler) CurrentRates(ctx context.Context, fiat string, coins []string) (map[string]float64, error) {
+	hdlr.queried[fiat] = append(hdlr.queried[fiat], coins...)
+	rates := make(map[string]float64)
+	for _, coin := range coins {
+		if rate, ok := hdlr.current[fiat][coin]; ok {
+			rates[coin] = rate
+		}
+	}
+	return rates, nil
+}
+
+func (hdlr *stubMarketHandler) HistoricalRate(ctx context.Context, date int64, fiat string, coin string) (float64, error) {
+	hdlr.lock.Lock()
+	hdlr.calls[coin]++
+	hdlr.active++
+	hdlr.maxSeen = max(hdlr.maxSeen, hdlr.active)
+	hdlr.lock.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	hdlr.lock.Lock()
+	hdlr.active--
+	hdlr.lock.Unlock()
+	if coin == "bad" {
+		return -1, fmt.Errorf("no rate for '%s'", coin)
+	}
+	return float64(len(coin)) * 100, nil
+}
+
+// use the stub as the only market handler
+func useStubMarket(t *testing.T) *stubMarketHandler {
+	t.Helper()
+	stub := new(stubMarketHandler)
+	stub.Init(nil)
+	baseMarketHdlrs["stub"] = stub
+	saved := marketHdlrs
+	marketHdlrs = []string{"stub"}
+	t.Cleanup(func() {
+		delete(baseMarketHdlrs, "stub")
+		marketHdlrs = saved
+	})
+	return stub
+}
+
+func TestHistoricalRates(t *testing.T) {
+	mdl := newTestModel(t)
+	stub := useStubMarket(t)
+
+	coins := []string{"btc", "eth", "ltc", "doge", "dash", "zec", "bch", "etc", "dgb", "nmc", "bad"}
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Unix()
+	rates := historicalRates(context.Background(), mdl, date, "EUR", coins)
+
+	// all coins with a rate are in the result (exactly once)
+	if len(rates) != len(coins)-1 {
+		t.Fatalf("got %d rates, want %d: %v", len(rates), len(coins)-1, rates)
+	}
+	for _, coin := range coins {
+		rate, ok := rates[coin]
+		if coin == "bad" {
+			if ok {
+				t.Errorf("unexpected rate for '%s'", coin)
+			}
+			continue
+		}
+		if want := float64(len(coin)) * 100; rate != want {
+			t.Errorf("%s: rate %f, want %f", coin, rate, want)
+		}
+		if n := stub.calls[coin]; n != 1 {
+			t.Errorf("%s: %d requests, want 1", coin, n)
+		}
+	}
+	// requests are concurrent, but bounded
+	if stub.maxSeen < 2 || stub.maxSeen > maxRateWorkers {
+		t.Errorf("%d concurrent requests, want 2..%d", stub.maxSeen, maxRateWorkers)
+	}
+
+	// second run is served from the rates table
+	rates = historicalRates(context.Background(), mdl, date, "EUR", coins)
+	if len(rates) != len(coins)-1 {
+		t.Fatalf("got %d cached rates, want %d", len(rates), len(coins)-1)
+	}
+	for _, coin := range coins {
+		want := 1
+		if coin == "bad" {
+			want = 2
+		}
+		if n := stub.calls[coin]; n != want {
+			t.Errorf("%s: %d requests, want %d", coin, n, want)
+		}
+	}
+}
+
+func TestRejectedRate(t *testing.T) {
+	mdl := newTestModel(t)
+	stub := useStubMarket(t)
+	savedDev, savedEst := maxDeviation, estimateRates
+	maxDeviation, estimateRates = 10, true
+	t.Cleanup(func() { maxDeviation, estimateRates = savedDev, savedEst })
+
+	testExec(t, mdl.inst,
+		"insert into coin(symbol,label,rate) values('btc','Bitcoin',50000)",
+		"insert into coin(symbol,label,rate) values('eth','Ethereum',3000)",
+		"insert into coin(symbol,label,rate) values('dgb','DigiByte',0.01)",
+	)
+	// outlier for ETH (coin-per-fiat instead of fiat-per-coin); no direct
+	// fiat rate for DGB (estimated via BTC)
+	stub.current["EUR"] = map[string]float64{"btc": 51000, "eth": 1. / 3000}
+	stub.current["BTC"] = map[string]float64{"eth": 0.06, "dgb": 2e-7}
+	coins := []string{"btc", "eth", "dgb"}
+
+	rates, err := GetMarketData(context.Background(), mdl, "EUR", -1, coins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// outlier is rejected (and not replaced by an estimate)
+	if rates["eth"] != 3000 {
+		t.Errorf("eth: rate %f, want 3000 (last rate)", rates["eth"])
+	}
+	if ci, _ := mdl.GetCoin("eth"); ci.Rate != 3000 {
+		t.Errorf("eth: stored rate %f, want 3000", ci.Rate)
+	}
+	for _, coin := range stub.queried["BTC"] {
+		if coin == "eth" {
+			t.Error("eth: rejected rate estimated via BTC")
+		}
+	}
+	// sane rates are updated
+	if rates["btc"] != 51000 {
+		t.Errorf("btc: rate %f, want 51000", rates["btc"])
+	}
+	if want := 2e-7 * 51000; math.Abs(rates["dgb"]-want) > 1e-12 {
+		t.Errorf("dgb: rate %f, want %f (estimated)", rates["dgb"], want)
+	}
+
+	// forced update accepts the outlier
+	ForceRates()
+	if rates, err = GetMarketData(context.Background(), mdl, "EUR", -1, coins); err != nil {
+		t.Fatal(err)
+	}
+	if rates["eth"] != 1./3000 {
+		t.Errorf("eth: forced rate %f, want %f", rates["eth"], 1./3000)
+	}
+	if ci, _ := mdl.GetCoin("eth"); ci.Rate != 1./3000 {
+		t.Errorf("eth: stored forced rate %f, want %f", ci.Rate, 1./3000)
+	}
+}
@@ -0,0 +1,90 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the relay (registered in the default Prometheus registry and
+// exposed by the web service under '/metrics'). All metrics are labeled
+// by coin symbol.
+var (
+	metricTxCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "transactions_created_total",
+		Help:      "Number of transactions created.",
+	}, []string{"coin"})
+
+	metricChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "balance_checks_total",
+		Help:      "Number of balance checks performed by the balancer.",
+	}, []string{"coin"})
+
+	metricCheckFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "balance_check_failures_total",
+		Help:      "Number of failed balance checks.",
+	}, []string{"coin"})
+
+	metricQueryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "relay",
+		Name:      "explorer_request_duration_seconds",
+		Help:      "Latency of blockchain explorer API requests (including retries).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"coin"})
+)
+
+// CountTransaction records a new transaction for a coin.
+func CountTransaction(coin string) {
+	metricTxCreated.WithLabelValues(coin).Inc()
+}
+
+// record a balance check (and its failure)
+func countCheck(coin string, err error) {
+	metricChecks.WithLabelValues(coin).Inc()
+	if err != nil {
+		metricCheckFailures.WithLabelValues(coin).Inc()
+	}
+}
+
+// context key for coin symbol (metrics label of explorer queries)
+type coinKey struct{}
+
+// withCoin returns a context carrying the coin symbol.
+func withCoin(ctx context.Context, coin string) context.Context {
+	return context.WithValue(ctx, coinKey{}, coin)
+}
+
+// record the latency of an explorer query for the coin of the context
+// (queries without coin, like market data, are not recorded)
+func observeQuery(ctx context.Context, start time.Time) {
+	coin, ok := ctx.Value(coinKey{}).(string)
+	if !ok {
+		return
+	}
+	metricQueryLatency.WithLabelValues(coin).Observe(time.Since(start).Seconds())
+}
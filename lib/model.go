@@ -44,13 +44,16 @@
 //
 // All 'addr' records in state (0) or (1) will have balance updates
 // at specified times. Whenever the address is requested by a client,
-// the wait time will be set to 300 seconds (5 min) and the next update
-// will happen "wait time" from now to check for incoming funds.
+// the wait time will be set to the minimum wait time (BalanceWait[0],
+// e.g. 300 seconds) and the next update will happen "wait time" from now
+// to check for incoming funds.
 //
 // If a balance update yields a new balance (higher than before), the
-// balance is updated and the new wait time is (re-)set to 300 seconds.
-// Otherwise the wait time is doubled but can't exceed a week. Based on
-// the wait time a time for the next update is calculated.
+// balance is updated and the wait time is (re-)set to the minimum wait
+// time. Otherwise the wait time is increased (by a randomized factor with
+// mean BalanceWait[1]) but can't exceed the maximum wait time configured
+// in BalanceWait[2] (in seconds). Based on the wait time a time for the
+// next update is calculated.
 //
 //----------------------------------------------------------------------
 
@@ -61,10 +64,17 @@ import (
 	"context"
 	"crypto/rand"
 	"database/sql"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	mrand "math/rand"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bfix/gospel/logger"
@@ -73,24 +83,59 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 
 	// import SQLite3 driver
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// SchemaVersion is the version of the database schema expected by the code
+// (see "meta" table in database).
+const SchemaVersion = 8
+
 // Error codes
 var (
 	ErrModelNotAvailable = fmt.Errorf("model not available")
+	ErrModelNoBackup     = fmt.Errorf("backup only available for SQLite3 databases")
 )
 
 // Model for domain logic and persistent storage
 type Model struct {
-	inst *sql.DB
-	cfg  *ModelConfig
+	inst     *sql.DB
+	cfg      *ModelConfig
+	rateLock sync.Mutex // serialize rate updates
+	sel      AddressSelector
+	clock    func() time.Time      // time source (time.Now if not set)
+	factor   func(float64) float64 // wait factor (WaitFactor if not set)
+	reqIDs   sync.Map              // request ids for next balance check (by address id)
+}
+
+// now returns the current time of the model clock. Scheduling (next
+// address check) and transaction expiry use the model clock, so it can
+// be replaced for deterministic testing.
+func (mdl *Model) now() time.Time {
+	if mdl.clock != nil {
+		return mdl.clock()
+	}
+	return time.Now()
+}
+
+// SetClock replaces the model clock (nil restores time.Now). Together
+// with SetWaitFactor, the scheduling of balance checks is deterministic.
+func (mdl *Model) SetClock(clock func() time.Time) {
+	mdl.clock = clock
+}
+
+// SetWaitFactor replaces the randomized factor for increasing the wait
+// time between balance checks (nil restores WaitFactor).
+func (mdl *Model) SetWaitFactor(factor func(f float64) float64) {
+	mdl.factor = factor
 }
 
 // Connect to model
 func Connect(cfg *ModelConfig) (mdl *Model, err error) {
 	mdl = &Model{}
 	mdl.cfg = cfg
+	if mdl.sel, err = GetAddressSelector(cfg.AddrPolicy); err != nil {
+		return
+	}
 	mdl.inst, err = sql.Open(cfg.DbEngine, cfg.DbConnect)
 	return
 }
@@ -103,6 +148,115 @@ func (mdl *Model) Close() (err error) {
 	return
 }
 
+// Backup writes a consistent copy of a SQLite3 database to a new file in
+// the given directory (the database remains usable during the backup).
+// Only the 'keep' most recent backup files are kept in the directory (all
+// if 'keep' is not positive). Returns the name of the backup file.
+func (mdl *Model) Backup(dir string, keep int) (file string, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return "", ErrModelNotAvailable
+	}
+	if mdl.cfg.DbEngine != "sqlite3" {
+		return "", ErrModelNoBackup
+	}
+	// write backup file
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	file = filepath.Join(dir, "relay-"+time.Now().Format("20060102-150405")+".db")
+	if _, err = mdl.inst.Exec("vacuum into ?", file); err != nil {
+		return
+	}
+	// remove old backups (names sort by time)
+	if keep <= 0 {
+		return
+	}
+	var files []string
+	if files, err = filepath.Glob(filepath.Join(dir, "relay-*.db")); err != nil {
+		return
+	}
+	sort.Strings(files)
+	for len(files) > keep {
+		if err = os.Remove(files[0]); err != nil {
+			return
+		}
+		files = files[1:]
+	}
+	return
+}
+
+// GetSchemaVersion returns the schema version of the connected database.
+func (mdl *Model) GetSchemaVersion() (version int, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, ErrModelNotAvailable
+	}
+	// query schema version
+	row := mdl.inst.QueryRow("select val from meta where name='schema'")
+	var val string
+	if err = row.Scan(&val); err != nil {
+		return
+	}
+	version, err = strconv.Atoi(val)
+	return
+}
+
+// Ping runs a lightweight query to check the database connection.
+func (mdl *Model) Ping() error {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	var val int
+	return mdl.inst.QueryRow("select 1").Scan(&val)
+}
+
+// SaveHandlerStats stores handler statistics in the model. A failing entry
+// is logged and skipped; the first error is returned after all entries
+// are processed.
+func (mdl *Model) SaveHandlerStats(list []*HandlerStat) (err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	for _, hs := range list {
+		if _, e := mdl.inst.Exec(
+			"replace into hdlrstat(name,success,failure,lastError,lastFail) values(?,?,?,?,?)",
+			hs.Name, hs.Success, hs.Failure, truncMsg(hs.LastError), hs.LastFail); e != nil {
+			logger.Printf(logger.ERROR, "[model] handler stats of '%s' not saved: %s", hs.Name, e.Error())
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return
+}
+
+// GetHandlerStats returns the handler statistics stored in the model.
+func (mdl *Model) GetHandlerStats() (list []*HandlerStat, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(
+		"select name,success,failure,lastError,lastFail from hdlrstat order by name"); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		hs := new(HandlerStat)
+		var lastErr sql.NullString
+		if err = rows.Scan(&hs.Name, &hs.Success, &hs.Failure, &lastErr, &hs.LastFail); err != nil {
+			return
+		}
+		hs.LastError = lastErr.String
+		list = append(list, hs)
+	}
+	return
+}
+
 //----------------------------------------------------------------------
 // Generic item
 //----------------------------------------------------------------------
@@ -167,38 +321,76 @@ func (mdl *Model) getItems(query string, args ...interface{}) (list []*Item, err
 		if err = rows.Scan(ptrs...); err != nil {
 			return
 		}
-		// assemble item
-		item := new(Item)
-		item.ID = values[0].(int64)
-		switch x := values[1].(type) {
-		case []uint8:
-			item.Name = string(x)
-		case string:
-			item.Name = x
-		}
-		item.Status = (values[2].(int64) != 0)
-		item.Dict = make(map[string]interface{})
-		for i := range values[3:] {
-			var val interface{} = nil
-			if values[3+i] != nil {
-				switch v := values[3+i].(type) {
-				case []uint8:
-					val = string(v)
-				case int32:
-					val = int64(v)
-				case float32:
-					val = float64(v)
-				default:
-					val = v
-				}
-			}
-			item.Dict[columns[3+i]] = val
+		var item *Item
+		if item, err = newItem(columns, values); err != nil {
+			return
 		}
 		list = append(list, item)
 	}
 	return
 }
 
+// assemble item from scanned column values (column types depend on the
+// database driver: strings can be returned as byte arrays)
+func newItem(columns []string, values []interface{}) (item *Item, err error) {
+	item = new(Item)
+	if item.ID, err = asInt64(values[0]); err != nil {
+		return
+	}
+	switch x := values[1].(type) {
+	case []uint8:
+		item.Name = string(x)
+	case string:
+		item.Name = x
+	}
+	var status int64
+	if status, err = asInt64(values[2]); err != nil {
+		return
+	}
+	item.Status = (status != 0)
+	item.Dict = make(map[string]interface{})
+	for i := range values[3:] {
+		var val interface{} = nil
+		if values[3+i] != nil {
+			switch v := values[3+i].(type) {
+			case []uint8:
+				val = string(v)
+			case int32:
+				val = int64(v)
+			case float32:
+				val = float64(v)
+			default:
+				val = v
+			}
+		}
+		item.Dict[columns[3+i]] = val
+	}
+	return
+}
+
+// convert a scanned column value to an integer. Depending on the database
+// driver integers can be returned as numeric values or as byte arrays.
+func asInt64(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case int32:
+		return int64(x), nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case []uint8:
+		return strconv.ParseInt(string(x), 10, 64)
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected column type %T", v)
+}
+
 //----------------------------------------------------------------------
 // Coin-related methods
 //----------------------------------------------------------------------
@@ -210,6 +402,35 @@ type CoinInfo struct {
 	Label  string  `json:"label"` // Full coin name
 	Logo   string  `json:"logo"`  // SVG-encoded coin logo
 	Rate   float64 `json:"rate"`  // price of coin in fiat currency
+	Est    bool    `json:"est"`   // rate is estimated (via BTC)
+
+	// presentation (optional)
+	Display string `json:"display,omitempty"` // short display name
+	Color   string `json:"color,omitempty"`   // accent color (like "#f7931a")
+
+	// coin status (only in coin lists for accounts)
+	Status    string  `json:"status,omitempty"`    // "enabled" or "paused"
+	MinAmount float64 `json:"minAmount,omitempty"` // min. accepted amount
+	Fee       float64 `json:"fee,omitempty"`       // typical network fee
+}
+
+// setStatus sets the status of a coin from its configuration.
+func (ci *CoinInfo) setStatus(cfg *CoinConfig) {
+	ci.Status = "enabled"
+	if cfg.Paused {
+		ci.Status = "paused"
+	}
+	ci.MinAmount = cfg.MinAmount
+	ci.Fee = cfg.Fee
+}
+
+// Name returns the name of the coin for display (display name if set,
+// full coin name otherwise).
+func (ci *CoinInfo) Name() string {
+	if len(ci.Display) > 0 {
+		return ci.Display
+	}
+	return ci.Label
 }
 
 // AccCoinInfo holds information about a coin and the
@@ -228,7 +449,7 @@ func (mdl *Model) GetCoins(account string) ([]*CoinInfo, error) {
 		return nil, ErrModelNotAvailable
 	}
 	// select coins for given account
-	rows, err := mdl.inst.Query("select coinId,coin,label,logo,rate from v_coin_accnt where account=?", account)
+	rows, err := mdl.inst.Query("select coinId,coin,label,logo,rate,coalesce(display,''),coalesce(color,'') from v_coin_accnt where account=?", account)
 	if err != nil {
 		return nil, err
 	}
@@ -236,9 +457,12 @@ func (mdl *Model) GetCoins(account string) ([]*CoinInfo, error) {
 	list := make([]*CoinInfo, 0)
 	for rows.Next() {
 		e := new(CoinInfo)
-		if err = rows.Scan(&e.ID, &e.Symbol, &e.Label, &e.Logo, &e.Rate); err != nil {
+		if err = rows.Scan(&e.ID, &e.Symbol, &e.Label, &e.Logo, &e.Rate, &e.Display, &e.Color); err != nil {
 			return nil, err
 		}
+		if hdlr, ok := HdlrList[e.Symbol]; ok && hdlr.Config() != nil {
+			e.setStatus(hdlr.Config())
+		}
 		list = append(list, e)
 	}
 	return list, nil
@@ -251,11 +475,11 @@ func (mdl *Model) GetCoinInfo(coinID int64) (*CoinInfo, error) {
 		return nil, ErrModelNotAvailable
 	}
 	// select coin for given ID
-	row := mdl.inst.QueryRow("select symbol,label,logo,rate from coin where id=?", coinID)
+	row := mdl.inst.QueryRow("select symbol,label,logo,rate,coalesce(display,''),coalesce(color,'') from coin where id=?", coinID)
 	e := new(CoinInfo)
 	e.ID = coinID
 	var logo sql.NullString
-	err := row.Scan(&e.Symbol, &e.Label, &logo, &e.Rate)
+	err := row.Scan(&e.Symbol, &e.Label, &logo, &e.Rate, &e.Display, &e.Color)
 	if logo.Valid {
 		e.Logo = logo.String
 	}
@@ -269,11 +493,11 @@ func (mdl *Model) GetCoin(symb string) (ci *CoinInfo, err error) {
 		return nil, ErrModelNotAvailable
 	}
 	// select coin information
-	row := mdl.inst.QueryRow("select id,label,logo,rate from coin where symbol=?", symb)
+	row := mdl.inst.QueryRow("select id,label,logo,rate,coalesce(display,''),coalesce(color,'') from coin where symbol=?", symb)
 	ci = new(CoinInfo)
 	ci.Symbol = symb
 	var logo sql.NullString
-	err = row.Scan(&ci.ID, &ci.Label, &logo, &ci.Rate)
+	err = row.Scan(&ci.ID, &ci.Label, &logo, &ci.Rate, &ci.Display, &ci.Color)
 	if logo.Valid {
 		ci.Logo = logo.String
 	}
@@ -308,6 +532,9 @@ func (mdl *Model) GetAccumulatedCoin(coin int64) (aci []*AccCoinInfo, err error)
 			c.label as label,
 			c.logo as logo,
 			c.rate as rate,
+			c.est as est,
+			coalesce(c.display,'') as display,
+			coalesce(c.color,'') as color,
 			coalesce(sum(a.balance),0) as total,
 			coalesce(sum(a.refCnt),0) as refs
 		from coin c
@@ -326,7 +553,7 @@ func (mdl *Model) GetAccumulatedCoin(coin int64) (aci []*AccCoinInfo, err error)
 	for rows.Next() {
 		// get basic coin info
 		ci := new(AccCoinInfo)
-		if err = rows.Scan(&ci.ID, &ci.Symbol, &ci.Label, &ci.Logo, &ci.Rate, &ci.Total, &ci.NumTx); err != nil {
+		if err = rows.Scan(&ci.ID, &ci.Symbol, &ci.Label, &ci.Logo, &ci.Rate, &ci.Est, &ci.Display, &ci.Color, &ci.Total, &ci.NumTx); err != nil {
 			return
 		}
 		// get account items
@@ -377,6 +604,69 @@ func (mdl *Model) SetCoinLogo(coin, logo string) error {
 	return err
 }
 
+// SetCoinDisplay sets the display name and accent color of a coin (as
+// configured). Empty values reset the setting.
+func (mdl *Model) SetCoinDisplay(coin, display, color string) error {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	var disp, col sql.NullString
+	if len(display) > 0 {
+		disp = sql.NullString{String: display, Valid: true}
+	}
+	if len(color) > 0 {
+		col = sql.NullString{String: color, Valid: true}
+	}
+	_, err := mdl.inst.Exec("update coin set display=?,color=? where symbol=?", disp, col, coin)
+	return err
+}
+
+// GetChainOrders returns the stored order of blockchain handlers (by coin
+// symbol). Coins without a stored order are not listed.
+func (mdl *Model) GetChainOrders() (orders map[string][]string, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query("select symbol,chains from coin where chains is not null"); err != nil {
+		return
+	}
+	defer rows.Close()
+	orders = make(map[string][]string)
+	for rows.Next() {
+		var symb, chains string
+		if err = rows.Scan(&symb, &chains); err != nil {
+			return
+		}
+		orders[symb] = strings.Split(chains, ",")
+	}
+	return
+}
+
+// SetChainOrder stores the order of blockchain handlers for a coin. An
+// empty list resets the order to the configured default.
+func (mdl *Model) SetChainOrder(coin string, names []string) (err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	var chains sql.NullString
+	if len(names) > 0 {
+		chains = sql.NullString{String: strings.Join(names, ","), Valid: true}
+	}
+	var res sql.Result
+	if res, err = mdl.inst.Exec("update coin set chains=? where symbol=?", chains, coin); err != nil {
+		return
+	}
+	var n int64
+	if n, err = res.RowsAffected(); err == nil && n == 0 {
+		err = ErrMdlUnknownCoin
+	}
+	return
+}
+
 //----------------------------------------------------------------------
 // Address-related methods
 //----------------------------------------------------------------------
@@ -394,12 +684,8 @@ func (mdl *Model) getUnusedAddress(mdltx *sql.Tx, coin, account string) (addr st
 	if mdl.inst == nil {
 		return "", ErrModelNotAvailable
 	}
-	// do we have a unused address for given coin? if so, use that address.
-	row := mdltx.QueryRow(
-		"select val from v_addr where stat=0 and coin=? and account=?",
-		coin, account)
-	err = row.Scan(&addr)
-	if err == nil || err != sql.ErrNoRows {
+	// select an existing address (depending on address policy)
+	if addr, err = mdl.sel.Select(mdltx, coin, account, mdl.now().Unix(), int64(mdl.cfg.TxGrace)); err != nil || len(addr) > 0 {
 		return
 	}
 	//  no old address found: generate a new one
@@ -410,7 +696,7 @@ func (mdl *Model) getUnusedAddress(mdltx *sql.Tx, coin, account string) (addr st
 	}
 	// get coin id
 	var coinID int64
-	row = mdltx.QueryRow("select id from coin where symbol=?", coin)
+	row := mdltx.QueryRow("select id from coin where symbol=?", coin)
 	err = row.Scan(&coinID)
 	if err != nil {
 		return
@@ -436,64 +722,115 @@ func (mdl *Model) getUnusedAddress(mdltx *sql.Tx, coin, account string) (addr st
 	if addr, err = hdlr.GetAddress(idx); err != nil {
 		return
 	}
+	// (first balance check is delayed: nothing to check yet)
+	delay := int64(mdl.cfg.FirstCheck)
+	if delay == 0 {
+		delay = int64(mdl.cfg.BalanceWait[0])
+	}
 	_, err = mdltx.Exec(
-		"insert into addr(coin,accnt,idx,val,waitCheck) values(?,?,?,?,?)",
-		coinID, accntID, idx, addr, mdl.cfg.BalanceWait[0])
+		"insert into addr(coin,accnt,idx,val,waitCheck,nextCheck) values(?,?,?,?,?,?)",
+		coinID, accntID, idx, addr, mdl.cfg.BalanceWait[0], mdl.now().Unix()+delay)
 	logger.Printf(logger.INFO, "[addr] New address '%s' for account '%s'", addr, account)
 	return
 }
 
-// PendingAddresses returns a list of non-locked addresses that are due for
-// balance update.
-func (mdl *Model) PendingAddresses() ([]int64, error) {
+// PendingAddresses returns lists of non-locked addresses that are due for
+// balance update (per coin symbol; most overdue addresses first).
+func (mdl *Model) PendingAddresses() (map[string][]int64, error) {
 	// check for valid repository
 	if mdl.inst == nil {
 		return nil, ErrModelNotAvailable
 	}
 	// get list of pending addresses
-	now := time.Now().Unix()
-	rows, err := mdl.inst.Query("select id from addr where stat<2 and (?-nextCheck)>=0", now)
+	now := mdl.now().Unix()
+	rows, err := mdl.inst.Query(
+		"select a.id,c.symbol from addr a join coin c on c.id=a.coin "+
+			"where a.stat<2 and (?-a.nextCheck)>=0 order by a.nextCheck", now)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	res := make([]int64, 0)
-	var ID int64
+	res := make(map[string][]int64)
+	var (
+		ID   int64
+		coin string
+	)
 	for rows.Next() {
-		if err = rows.Scan(&ID); err != nil {
+		if err = rows.Scan(&ID, &coin); err != nil {
 			return nil, err
 		}
-		res = append(res, ID)
+		res[coin] = append(res[coin], ID)
 	}
 	return res, nil
 }
 
+// CoinOnboarded returns the time (Unix epoch) a coin was first handled by
+// the service. The time is recorded in the meta table on first call.
+func (mdl *Model) CoinOnboarded(coin string) (ts int64, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, ErrModelNotAvailable
+	}
+	// query onboarding time
+	name := "onboard:" + coin
+	var val string
+	row := mdl.inst.QueryRow("select val from meta where name=?", name)
+	if err = row.Scan(&val); err == nil {
+		return strconv.ParseInt(val, 10, 64)
+	}
+	if err != sql.ErrNoRows {
+		return
+	}
+	// record onboarding time
+	ts = mdl.now().Unix()
+	_, err = mdl.inst.Exec("insert into meta(name,val) values(?,?)", name, strconv.FormatInt(ts, 10))
+	return
+}
+
 // NextUpdate calculates the time for the next update and the associated
 // wait time depending on the reset flag. If reset, the wait time starts
-// at 5 minutes (300 sec), otherwise it is doubled before calculating the
-// next update time.
+// at the minimum wait time (BalanceWait[0]), otherwise it is increased
+// (capped at BalanceWait[2]) before calculating the next update time.
 func (mdl *Model) NextUpdate(ID int64, reset bool) error {
 	// check for valid repository
 	if mdl.inst == nil {
 		return ErrModelNotAvailable
 	}
-	// set next wait time; wait time is randomized
-	f := mdl.cfg.BalanceWait[1]
-	r := mrand.NormFloat64()*(0.25*f) + f
-	if r < 1.0 {
-		r = 1.0
+	// get current wait time
+	var wait int64
+	row := mdl.inst.QueryRow("select waitCheck from addr where id=?", ID)
+	if err := row.Scan(&wait); err != nil {
+		return err
 	}
-	wt := fmt.Sprintf("least(%f*waitCheck,%d)", r, int(mdl.cfg.BalanceWait[2]))
-	if reset {
-		wt = fmt.Sprintf("%d", int(mdl.cfg.BalanceWait[0]))
+	// set next wait time; wait time is randomized
+	factor := mdl.factor
+	if factor == nil {
+		factor = WaitFactor
 	}
-	now := time.Now().Unix()
+	wt := NextWait(mdl.cfg.BalanceWait, wait, reset, factor(mdl.cfg.BalanceWait[1]))
+	now := mdl.now().Unix()
 	_, err := mdl.inst.Exec(
-		"update addr set lastCheck=?,waitCheck="+wt+
-			",nextCheck=nextCheck+"+wt+" where id=?", now, ID)
+		"update addr set lastCheck=?,waitCheck=?,nextCheck=nextCheck+? where id=?", now, wt, wt, ID)
 	return err
 }
 
+// WaitFactor returns a randomized factor (normal distribution around the
+// mean 'f'; at least 1) for increasing the wait time between checks.
+func WaitFactor(f float64) float64 {
+	return max(mrand.NormFloat64()*(0.25*f)+f, 1.0)
+}
+
+// NextWait returns the next wait time (in seconds) between balance checks
+// for the parameters [min, factor, max]: if reset, it is the minimum wait
+// time; otherwise the current wait time is increased by factor 'r' (capped
+// at the maximum wait time).
+func NextWait(bw []float64, wait int64, reset bool, r float64) int64 {
+	if reset {
+		return int64(bw[0])
+	}
+	return min(int64(r*float64(wait)), int64(bw[2]))
+}
+
 // CloseAddress closes an address; no further usage (except spending)
 func (mdl *Model) CloseAddress(ID int64) error {
 	// check for valid repository
@@ -501,7 +838,7 @@ func (mdl *Model) CloseAddress(ID int64) error {
 		return ErrModelNotAvailable
 	}
 	// close address in model
-	_, err := mdl.inst.Exec("update addr set stat=1, validTo=now() where id=?", ID)
+	_, err := mdl.inst.Exec("update addr set stat=1, validTo=? where id=?", mdl.now().Unix(), ID)
 	return err
 }
 
@@ -523,7 +860,7 @@ func (mdl *Model) SyncAddress(ID int64) error {
 		return ErrModelNotAvailable
 	}
 	// enforce update now
-	now := time.Now().Unix()
+	now := mdl.now().Unix()
 	_, err := mdl.inst.Exec("update addr set nextCheck=? where id=?", now, ID)
 	return err
 }
@@ -552,6 +889,81 @@ func (mdl *Model) GetAddressID(addr string) (id int64, err error) {
 	return
 }
 
+// GetAddressByValue returns information about all address entries with a
+// given address value (an address can be valid for more than one coin).
+func (mdl *Model) GetAddressByValue(addr string) (list []*AddrInfo, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	// query IDs (ETH-like addresses are compared case-insensitive)
+	query := "select id from addr where val=?"
+	if isEthAddress(addr) {
+		query = "select id from addr where lower(val)=?"
+		addr = strings.ToLower(addr)
+	}
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(query, addr); err != nil {
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	// get address information
+	list = make([]*AddrInfo, 0)
+	for _, id := range ids {
+		var ai []*AddrInfo
+		if ai, err = mdl.GetAddresses(id, 0, 0, true); err != nil {
+			return
+		}
+		list = append(list, ai...)
+	}
+	return
+}
+
+// AddrIndex holds information about an allocated address index
+type AddrIndex struct {
+	ID      int64   `json:"id"`      // id of address entry
+	Idx     int     `json:"idx"`     // derivation index
+	Val     string  `json:"value"`   // address value
+	Status  int     `json:"status"`  // address status
+	Balance float64 `json:"balance"` // address balance
+	Account string  `json:"account"` // account label
+}
+
+// GetAddressIndices returns all addresses of a coin ordered by their
+// derivation index (including closed and removed addresses).
+func (mdl *Model) GetAddressIndices(coin int64) (list []*AddrIndex, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	// get addresses ordered by index
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(
+		"select a.id,a.idx,a.val,a.stat,a.balance,coalesce(c.label,'') "+
+			"from addr a left join account c on c.id=a.accnt "+
+			"where a.coin=? order by a.idx", coin); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ai := new(AddrIndex)
+		if err = rows.Scan(&ai.ID, &ai.Idx, &ai.Val, &ai.Status, &ai.Balance, &ai.Account); err != nil {
+			return
+		}
+		list = append(list, ai)
+	}
+	return
+}
+
 // AddrInfo holds information about an address
 type AddrInfo struct {
 	ID         int64   `json:"id"`         // id of address entry
@@ -575,6 +987,12 @@ type AddrInfo struct {
 
 // GetAddress returns a list of active adresses
 func (mdl *Model) GetAddresses(id, accnt, coin int64, all bool) (ai []*AddrInfo, err error) {
+	return mdl.GetAddressesAbove(id, accnt, coin, all, 0)
+}
+
+// GetAddressesAbove returns a list of adresses with a fiat value of at
+// least minValue (addresses with dust balances are filtered out).
+func (mdl *Model) GetAddressesAbove(id, accnt, coin int64, all bool, minValue float64) (ai []*AddrInfo, err error) {
 	// check for valid repository
 	if mdl.inst == nil {
 		return nil, ErrModelNotAvailable
@@ -602,6 +1020,14 @@ func (mdl *Model) GetAddresses(id, accnt, coin int64, all bool) (ai []*AddrInfo,
 			addClause(accnt, "accntId")
 		}
 	}
+	var args []interface{}
+	if minValue > 0 {
+		if len(clause) > 0 {
+			clause += " and"
+		}
+		clause += " balance*rate >= ?"
+		args = append(args, minValue)
+	}
 	// assemble SELECT statement
 	query := "select id,coin,coinName,val,balance,rate,stat,account,accountName," +
 		"cnt,lastCheck,nextCheck,waitCheck,lastTx,validFrom,validTo from v_addr"
@@ -612,7 +1038,7 @@ func (mdl *Model) GetAddresses(id, accnt, coin int64, all bool) (ai []*AddrInfo,
 
 	// get information about active addresses
 	var rows *sql.Rows
-	if rows, err = mdl.inst.Query(query); err != nil {
+	if rows, err = mdl.inst.Query(query, args...); err != nil {
 		return nil, err
 	}
 	defer rows.Close()
@@ -673,11 +1099,38 @@ func (mdl *Model) UpdateBalance(ID int64, balance float64) error {
 	return err
 }
 
+// SetBalance sets the balance of an address as a manual override by an
+// operator (e.g. to correct a drift). Unlike UpdateBalance (used by the
+// balancer), no incoming funds are recorded; the override is logged.
+func (mdl *Model) SetBalance(ID int64, balance float64) error {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	if balance < 0 {
+		return fmt.Errorf("invalid balance %f", balance)
+	}
+	// get current balance of address
+	var addr string
+	var old float64
+	row := mdl.inst.QueryRow("select val,balance from addr where id=?", ID)
+	if err := row.Scan(&addr, &old); err != nil {
+		return err
+	}
+	// set new balance
+	if _, err := mdl.inst.Exec("update addr set balance=? where id=?", balance, ID); err != nil {
+		return err
+	}
+	logger.Printf(logger.WARN, "Manual balance override for '%s': %f => %f", addr, old, balance)
+	return nil
+}
+
 // Incoming is an incoming transaction
 type Incoming struct {
 	Date    string
 	Account string
 	Coin    string
+	Symbol  string
 	Amount  float64
 	Value   float64
 }
@@ -689,35 +1142,132 @@ func (mdl *Model) Incoming(ID int64, amount float64) error {
 		return ErrModelNotAvailable
 	}
 	// insert funding statement
-	now := time.Now().Unix()
+	now := mdl.now().Unix()
 	_, err := mdl.inst.Exec("insert into incoming(firstSeen,addr,amount) values(?,?,?)", now, ID, amount)
 	return err
 }
 
 // ListIncoming returns a list of recent incoming funds.
 func (mdl *Model) ListIncoming(n int) (list []*Incoming, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
 	var rows *sql.Rows
-	if rows, err = mdl.inst.Query(
-		"select firstSeen,account,coin,amount,val from v_incoming order by firstSeen desc limit ?", n); err != nil {
+	if rows, err = mdl.inst.Query(`
+		select i.firstSeen, p.name, c.label, c.symbol, i.amount, c.rate*i.amount
+		from incoming i, addr a, account p, coin c
+		where i.addr = a.id and a.accnt = p.id and a.coin = c.id
+		order by i.firstSeen desc limit ?`, n); err != nil {
 		return
 	}
+	defer rows.Close()
+	list = make([]*Incoming, 0)
 	for rows.Next() {
 		i := new(Incoming)
 		var dt int64
-		if err = rows.Scan(&dt, &i.Account, &i.Coin, &i.Amount, &i.Value); err != nil {
+		if err = rows.Scan(&dt, &i.Account, &i.Coin, &i.Symbol, &i.Amount, &i.Value); err != nil {
 			return
 		}
 		i.Date = time.Unix(dt, 0).Format("2006-01-02 15:04:05")
 		list = append(list, i)
 	}
+	err = rows.Err()
+	return
+}
+
+// Revenue is the fiat value of incoming funds in a period (bucket)
+type Revenue struct {
+	Period string  `json:"period"`           // period ("YYYY-MM-DD" or ISO week "YYYY-Www")
+	Count  int     `json:"count"`            // number of incoming funds
+	Value  float64 `json:"value"`            // total value (in fiat currency)
+	NoRate int     `json:"noRate,omitempty"` // number of funds without rate (not in value)
+}
+
+// Error codes (revenue-related)
+var (
+	ErrMdlInvalidBucket = fmt.Errorf("invalid bucket")
+)
+
+// IncomingByPeriod returns the fiat value of incoming funds in the time
+// range [from,to] summed up per day ("day") or ISO week ("week"). The rate
+// of the day funds were first seen is used. If 'current' is set (the fiat
+// currency is the currency of the coin rates), the current coin rate is
+// used if no rate is available for that day; otherwise funds without rate
+// are not included in the value (but counted in NoRate).
+func (mdl *Model) IncomingByPeriod(from, to int64, bucket string, fiat string, current bool) (list []*Revenue, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	if bucket != "day" && bucket != "week" {
+		return nil, ErrMdlInvalidBucket
+	}
+	// date expression (depending on database engine); weeks are computed
+	// from days (ISO weeks on all engines)
+	day := "date_format(from_unixtime(i.firstSeen),'%Y-%m-%d')"
+	if mdl.cfg.DbEngine == "sqlite3" {
+		day = "strftime('%Y-%m-%d',i.firstSeen,'unixepoch')"
+	}
+	rate := "r.rate"
+	if current {
+		rate = "coalesce(r.rate,c.rate)"
+	}
+	// query summed values per day
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(`
+		select
+			`+day+` as period,
+			count(*) as cnt,
+			sum(i.amount*`+rate+`) as val,
+			sum(case when `+rate+` is null then 1 else 0 end) as norate
+		from incoming i
+		inner join addr a on a.id = i.addr
+		inner join coin c on c.id = a.coin
+		left join rates r on r.coin = c.symbol and r.fiat = ? and r.dt = `+day+`
+		where i.firstSeen between ? and ?
+		group by period
+		order by period`, fiat, from, to); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rev := new(Revenue)
+		var val sql.NullFloat64
+		if err = rows.Scan(&rev.Period, &rev.Count, &val, &rev.NoRate); err != nil {
+			return
+		}
+		rev.Value = val.Float64
+		// roll up days into weeks
+		if bucket == "week" {
+			var t time.Time
+			if t, err = time.Parse("2006-01-02", rev.Period); err != nil {
+				return
+			}
+			year, week := t.ISOWeek()
+			rev.Period = fmt.Sprintf("%04d-W%02d", year, week)
+			if n := len(list); n > 0 && list[n-1].Period == rev.Period {
+				last := list[n-1]
+				last.Count += rev.Count
+				last.Value += rev.Value
+				last.NoRate += rev.NoRate
+				continue
+			}
+		}
+		list = append(list, rev)
+	}
+	err = rows.Err()
 	return
 }
 
 // Fund represents an entry in the 'incoming' table (incoming fund)
 type Fund struct {
-	Seen   int64
-	Addr   int64
-	Amount float64
+	Seen     int64
+	Addr     int64
+	Amount   float64
+	Script   string // script type of output (if known)
+	Mismatch bool   // script type does not match address mode
+	Coinbase bool   // funds from a coinbase transaction
 }
 
 // GetFunds return a list of funds for given address
@@ -741,23 +1291,63 @@ func (mdl *Model) GetFunds(addr int64) (list []*Fund, err error) {
 	return
 }
 
-//----------------------------------------------------------------------
-// Assignement-related methods.
-//----------------------------------------------------------------------
-
-// CountAssignments returns the number of assignments between coins and
-// accounts. An ID of "0" means "all".
-func (mdl *Model) CountAssignments(coin, accnt int64) int {
-	// assemble WHERE clause
-	clause := ""
-	addClause := func(id int64, field string) {
-		if id != 0 {
-			if len(clause) > 0 {
-				clause += " and"
-			}
-			clause += fmt.Sprintf(" %s=%d", field, id)
-		}
-	}
+// ReplaceFunds replaces all entries in the 'incoming' table for an address
+// in the time range [from,to] with the given list of funds (funds outside
+// the time range are ignored). Returns the number of inserted entries.
+// Replacing the entries (instead of adding them) makes the operation
+// idempotent.
+func (mdl *Model) ReplaceFunds(addr, from, to int64, funds []*Fund) (n int, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, ErrModelNotAvailable
+	}
+	// start repository transaction
+	var mdltx *sql.Tx
+	if mdltx, err = mdl.inst.BeginTx(context.Background(), nil); err != nil {
+		return
+	}
+	// remove existing entries
+	if _, err = mdltx.Exec(
+		"delete from incoming where addr=? and firstSeen>=? and firstSeen<=?",
+		addr, from, to); err != nil {
+		mdltx.Rollback()
+		return
+	}
+	// insert funds
+	for _, f := range funds {
+		if f.Seen < from || f.Seen > to {
+			continue
+		}
+		if _, err = mdltx.Exec(
+			"insert into incoming(firstSeen,addr,amount) values(?,?,?)",
+			f.Seen, addr, f.Amount); err != nil {
+			mdltx.Rollback()
+			return 0, err
+		}
+		n++
+	}
+	// commit repository transaction
+	err = mdltx.Commit()
+	return
+}
+
+//----------------------------------------------------------------------
+// Assignement-related methods.
+//----------------------------------------------------------------------
+
+// CountAssignments returns the number of assignments between coins and
+// accounts. An ID of "0" means "all".
+func (mdl *Model) CountAssignments(coin, accnt int64) int {
+	// assemble WHERE clause
+	clause := ""
+	addClause := func(id int64, field string) {
+		if id != 0 {
+			if len(clause) > 0 {
+				clause += " and"
+			}
+			clause += fmt.Sprintf(" %s=%d", field, id)
+		}
+	}
 	addClause(coin, "coin")
 	addClause(accnt, "accnt")
 
@@ -789,18 +1379,124 @@ func (mdl *Model) ChangeAssignment(coin, accnt int64, add bool) (err error) {
 // Account-related methods
 //----------------------------------------------------------------------
 
+// Error codes (account-related)
+var (
+	ErrMdlAccntConflict = fmt.Errorf("both accounts have open addresses for coins")
+	ErrMdlAccntSame     = fmt.Errorf("source and target account are the same")
+)
+
+// ReassignAddresses moves all addresses (with their balances and history)
+// and coin assignments of an account to another account. If 'archive' is
+// set, the source account no longer accepts coins (the account record is
+// kept). The accounts must not both have open addresses for the same coin
+// (the address selection expects at most one open address per coin and
+// account). Returns the number of moved addresses.
+func (mdl *Model) ReassignAddresses(fromID, toID int64, archive bool) (n int64, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, ErrModelNotAvailable
+	}
+	if fromID == toID {
+		return 0, ErrMdlAccntSame
+	}
+	// start repository transaction
+	var mdltx *sql.Tx
+	if mdltx, err = mdl.inst.BeginTx(context.Background(), nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			mdltx.Rollback()
+		}
+	}()
+	// check for conflicting open addresses
+	var rows *sql.Rows
+	if rows, err = mdltx.Query(`
+		select distinct c.symbol from addr a
+		inner join coin c on c.id = a.coin
+		where a.accnt = ? and a.stat = 0 and a.coin in (
+			select coin from addr where accnt = ? and stat = 0)`, fromID, toID); err != nil {
+		return
+	}
+	var coins []string
+	for rows.Next() {
+		var coin string
+		if err = rows.Scan(&coin); err != nil {
+			rows.Close()
+			return
+		}
+		coins = append(coins, coin)
+	}
+	rows.Close()
+	if len(coins) > 0 {
+		err = fmt.Errorf("%w: %s", ErrMdlAccntConflict, strings.Join(coins, ","))
+		return
+	}
+	// move addresses
+	var res sql.Result
+	if res, err = mdltx.Exec("update addr set accnt=? where accnt=?", toID, fromID); err != nil {
+		return
+	}
+	if n, err = res.RowsAffected(); err != nil {
+		return
+	}
+	// add coin assignments of source to target
+	if _, err = mdltx.Exec(`
+		insert into accept(accnt,coin)
+		select ?, coin from accept
+		where accnt = ? and coin not in (select coin from accept where accnt = ?)`,
+		toID, fromID, toID); err != nil {
+		return
+	}
+	// archive source account (remove coin assignments)
+	if archive {
+		if _, err = mdltx.Exec("delete from accept where accnt=?", fromID); err != nil {
+			return
+		}
+	}
+	// commit repository transaction
+	err = mdltx.Commit()
+	return
+}
+
 // AccntInfo holds information about an account in the model.
 type AccntInfo struct {
-	ID    int64   `json:"id"`    // Id of account record
-	Label string  `json:"label"` // account label
-	Name  string  `json:"name"`  // account name
-	Total float64 `json:"total"` // total balance of account (in fiat currency)
-	NumTx int64   `json:"numTx"` // number of transactions for account
-	Coins []*Item `json:"coins"` // (assigned) coins
+	ID     int64   `json:"id"`               // Id of account record
+	Label  string  `json:"label"`            // account label
+	Name   string  `json:"name"`             // account name
+	Fiat   string  `json:"fiat"`             // fiat currency of account (empty for default)
+	Total  float64 `json:"total"`            // total balance of account (in fiat currency)
+	NoRate bool    `json:"noRate,omitempty"` // total excludes coins without rate
+	NumTx  int64   `json:"numTx"`            // number of transactions for account
+	Coins  []*Item `json:"coins"`            // (assigned) coins
 }
 
-// GetAccounts list all accounts with their total balance (in fiat currency)
+// GetAccounts list all accounts with their total balance (in fiat currency).
+// If an account has its own fiat currency, the latest rates for that
+// currency from the rates table are used; the current coin rate (in
+// default fiat currency) is used otherwise. Coins without a rate in the
+// fiat currency of the account are not included in the total (the account
+// is flagged) and have no rate in the coin list.
 func (mdl *Model) GetAccounts(id int64) (accnts []*AccntInfo, err error) {
+	if id != 0 {
+		return mdl.getAccounts("where account.id=?", id)
+	}
+	return mdl.getAccounts("")
+}
+
+// SearchAccounts returns all accounts where label or name contain the
+// query string (case-insensitive on most databases).
+func (mdl *Model) SearchAccounts(query string) ([]*AccntInfo, error) {
+	// escape wildcards in query string
+	query = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(query)
+	pattern := "%" + query + "%"
+	return mdl.getAccounts(
+		"where account.label like ? escape '!' or account.name like ? escape '!'",
+		pattern, pattern)
+}
+
+// get list of accounts matching the (optional) where clause
+func (mdl *Model) getAccounts(where string, args ...interface{}) (accnts []*AccntInfo, err error) {
 	// check for valid repository
 	if mdl.inst == nil {
 		return nil, ErrModelNotAvailable
@@ -811,16 +1507,22 @@ func (mdl *Model) GetAccounts(id int64) (accnts []*AccntInfo, err error) {
 			account.id as id,
 			account.label as label,
 			account.name as name,
-			sum(addr.balance*coin.rate) as total,
-			sum(addr.refCnt) as refs
+			account.fiat as fiat,
+			sum(addr.balance*(case when account.fiat is null then coin.rate else r.rate end)) as total,
+			sum(addr.refCnt) as refs,
+			sum(case when addr.balance > 0 and account.fiat is not null and r.rate is null
+				then 1 else 0 end) as norate
 		from account
 		left join addr on addr.accnt=account.id and addr.stat < 2
 		left join coin on addr.coin=coin.id
+		left join rates r on r.coin=coin.symbol and r.fiat=account.fiat
+			and r.dt=(select max(dt) from rates where coin=r.coin and fiat=r.fiat)
+		` + where + `
 		group by account.id`
 
 	// select account information
 	var rows *sql.Rows
-	if rows, err = mdl.inst.Query(query); err != nil {
+	if rows, err = mdl.inst.Query(query, args...); err != nil {
 		return
 	}
 	defer rows.Close()
@@ -828,15 +1530,16 @@ func (mdl *Model) GetAccounts(id int64) (accnts []*AccntInfo, err error) {
 		// parse basic information
 		ai := new(AccntInfo)
 		var (
-			total sql.NullFloat64
-			refs  sql.NullInt64
+			fiat   sql.NullString
+			total  sql.NullFloat64
+			refs   sql.NullInt64
+			norate sql.NullInt64
 		)
-		if err = rows.Scan(&ai.ID, &ai.Label, &ai.Name, &total, &refs); err != nil {
+		if err = rows.Scan(&ai.ID, &ai.Label, &ai.Name, &fiat, &total, &refs, &norate); err != nil {
 			return
 		}
-		// filter for ID
-		if id != 0 && ai.ID != id {
-			continue
+		if fiat.Valid {
+			ai.Fiat = fiat.String
 		}
 		ai.Total = 0
 		if total.Valid {
@@ -846,20 +1549,23 @@ func (mdl *Model) GetAccounts(id int64) (accnts []*AccntInfo, err error) {
 		if refs.Valid {
 			ai.NumTx = refs.Int64
 		}
+		ai.NoRate = norate.Valid && norate.Int64 > 0
 		// get associated coins for account
 		if ai.Coins, err = mdl.getItems(`
 			select
   				coin.id as id,
-  				coin.label as name,
+  				coalesce(coin.display,coin.label) as name,
   				(coin.id in (select coin from accept where accnt=?)) as status,
-  				coin.rate as rate,
+  				(case when ? is null then coin.rate else r.rate end) as rate,
   				sum(addr.balance) as balance,
 				count(addr.id) as addrs,
 				coin.symbol as symbol,
 				coin.logo as logo
 			from coin
 			left join addr on addr.coin = coin.id and addr.stat < 2 and addr.accnt = ?
-			group by coin.id`, ai.ID, ai.ID); err != nil {
+			left join rates r on r.coin = coin.symbol and r.fiat = ?
+				and r.dt = (select max(dt) from rates where coin=r.coin and fiat=r.fiat)
+			group by coin.id`, ai.ID, fiat, ai.ID, fiat); err != nil {
 			return
 		}
 		// sort coins by descending fiat balance
@@ -874,8 +1580,8 @@ func (mdl *Model) GetAccounts(id int64) (accnts []*AccntInfo, err error) {
 			if xj != nil {
 				bj = xj.(float64)
 			}
-			ri := ai.Coins[i].Dict["rate"].(float64)
-			rj := ai.Coins[j].Dict["rate"].(float64)
+			ri, _ := ai.Coins[i].Dict["rate"].(float64)
+			rj, _ := ai.Coins[j].Dict["rate"].(float64)
 			return rj*bj < ri*bi
 		})
 		// add to list
@@ -888,6 +1594,70 @@ func (mdl *Model) GetAccounts(id int64) (accnts []*AccntInfo, err error) {
 	return
 }
 
+// AccntTotal holds the total funds received by an account (in fiat
+// currency) with a breakdown per coin.
+type AccntTotal struct {
+	Fiat  string       `json:"fiat"`  // fiat currency
+	Total float64      `json:"total"` // total funds (in fiat currency)
+	Coins []*CoinTotal `json:"coins"` // per-coin totals
+}
+
+// CoinTotal holds the funds received for a coin.
+type CoinTotal struct {
+	Symbol  string  `json:"symbol"`           // coin symbol
+	Balance float64 `json:"balance"`          // total funds (in coins)
+	Rate    float64 `json:"rate"`             // exchange rate
+	Value   float64 `json:"value"`            // total funds (in fiat currency)
+	NoRate  bool    `json:"noRate,omitempty"` // no rate in fiat currency
+}
+
+// GetAccountTotal returns the total funds received by an account in the
+// given fiat currency. The latest rates for the fiat currency from the rates
+// table are used; coins without a rate in the fiat currency are flagged and
+// not included in the total.
+func (mdl *Model) GetAccountTotal(accntID int64, fiat string) (at *AccntTotal, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	// query per-coin totals
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(`
+		select
+			coin.symbol as symbol,
+			sum(addr.balance) as balance,
+			r.rate as rate
+		from addr
+		inner join coin on coin.id = addr.coin
+		left join rates r on r.coin = coin.symbol and r.fiat = ?
+			and r.dt = (select max(dt) from rates where coin=r.coin and fiat=r.fiat)
+		where addr.accnt = ?
+		group by coin.id`, fiat, accntID); err != nil {
+		return
+	}
+	defer rows.Close()
+	at = &AccntTotal{
+		Fiat:  fiat,
+		Coins: make([]*CoinTotal, 0),
+	}
+	for rows.Next() {
+		ct := new(CoinTotal)
+		var rate sql.NullFloat64
+		if err = rows.Scan(&ct.Symbol, &ct.Balance, &rate); err != nil {
+			return
+		}
+		ct.Rate, ct.NoRate = rate.Float64, !rate.Valid
+		ct.Value = ct.Balance * ct.Rate
+		at.Total += ct.Value
+		at.Coins = append(at.Coins, ct)
+	}
+	// sort coins by descending fiat value
+	sort.Slice(at.Coins, func(i, j int) bool {
+		return at.Coins[j].Value < at.Coins[i].Value
+	})
+	return
+}
+
 // GetAccountID returns repository ID of an account record.
 func (mdl *Model) GetAccountID(label string) (accnt int64, err error) {
 	// check for valid repository
@@ -900,15 +1670,72 @@ func (mdl *Model) GetAccountID(label string) (accnt int64, err error) {
 	return
 }
 
-// NewAccount creates a new account with given label and name.
-func (mdl *Model) NewAccount(label, name string) error {
+// NewAccount creates a new account with given label and name that accepts
+// the listed coins (database IDs). An empty fiat currency means the account
+// uses the default fiat currency. The account and its coin assignments are
+// created in a single database transaction. Returns the database ID of the
+// new account.
+func (mdl *Model) NewAccount(label, name, fiat string, coins []int64) (id int64, err error) {
 	// check for valid repository
 	if mdl.inst == nil {
-		return ErrModelNotAvailable
+		return 0, ErrModelNotAvailable
 	}
+	var fc sql.NullString
+	if len(fiat) > 0 {
+		fc.String = strings.ToUpper(fiat)
+		fc.Valid = true
+	}
+	// start repository transaction
+	var mdltx *sql.Tx
+	if mdltx, err = mdl.inst.BeginTx(context.Background(), nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			mdltx.Rollback()
+		}
+	}()
 	// insert new record into model
-	_, err := mdl.inst.Exec("insert into account(label,name) values(?,?)", label, name)
-	return err
+	var res sql.Result
+	if res, err = mdltx.Exec("insert into account(label,name,fiat) values(?,?,?)", label, name, fc); err != nil {
+		return
+	}
+	if id, err = res.LastInsertId(); err != nil {
+		return
+	}
+	// assign coins to account
+	for _, coin := range coins {
+		if _, err = mdltx.Exec("insert into accept(coin,accnt) values(?,?)", coin, id); err != nil {
+			return
+		}
+	}
+	// commit repository transaction
+	err = mdltx.Commit()
+	return
+}
+
+// GetAccountFiats returns a list of all fiat currencies used by accounts
+// that differ from the default fiat currency.
+func (mdl *Model) GetAccountFiats(fiat string) (list []string, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	// query distinct currencies
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(
+		"select distinct fiat from account where fiat is not null and fiat<>?", fiat); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fc string
+		if err = rows.Scan(&fc); err != nil {
+			return
+		}
+		list = append(list, fc)
+	}
+	return
 }
 
 //----------------------------------------------------------------------
@@ -924,16 +1751,49 @@ type Transaction struct {
 	Status    int    `json:"status"`
 	ValidFrom int64  `json:"validFrom"`
 	ValidTo   int64  `json:"validTo"`
+	Late      bool   `json:"late"`
+	Memo      int64  `json:"memo,omitempty"`
+	Refund    string `json:"refund,omitempty"`
 }
 
-// NewTransaction creates a new pending transaction for a given coin/account pair
-func (mdl *Model) NewTransaction(coin, account string) (tx *Transaction, err error) {
+// Error codes
+var (
+	ErrMdlInvalidRefund = fmt.Errorf("invalid refund address for coin")
+	ErrMdlCoinPaused    = fmt.Errorf("coin is not accepting payments")
+)
+
+// NewTransaction creates a new pending transaction for a given coin/account
+// pair. If the coin requires a memo (destination tag), a random numeric memo
+// is generated for the transaction. An optional refund address of the
+// customer is stored with the transaction (record-keeping only); it must be
+// a valid address for the coin. The request id of the context (if any) is
+// handed to the next balance check of the address for log correlation.
+func (mdl *Model) NewTransaction(ctx context.Context, coin, account string, withMemo bool, refund string) (tx *Transaction, err error) {
 	// check for valid repository
 	if mdl.inst == nil {
 		return nil, ErrModelNotAvailable
 	}
-	// start repository transaction
-	ctx := context.Background()
+	// check if coin accepts new transactions; get time-to-live
+	ttl := mdl.cfg.TxTTL
+	if hdlr, ok := HdlrList[coin]; ok && hdlr.Config() != nil {
+		if hdlr.Config().Paused {
+			return nil, ErrMdlCoinPaused
+		}
+		if hdlr.Config().TxTTL > 0 {
+			ttl = hdlr.Config().TxTTL
+		}
+	}
+	// check refund address
+	var refAddr sql.NullString
+	if len(refund) > 0 {
+		if hdlr, ok := HdlrList[coin]; !ok || !hdlr.ValidAddress(refund) {
+			return nil, ErrMdlInvalidRefund
+		}
+		refAddr = sql.NullString{String: refund, Valid: true}
+	}
+	// start repository transaction (traced)
+	ctx, span := StartSpan(ctx, "model.newTransaction", Attr("coin", coin), Attr("account", account))
+	defer func() { span.End(err) }()
 	var mdltx *sql.Tx
 	if mdltx, err = mdl.inst.BeginTx(ctx, nil); err != nil {
 		return
@@ -946,7 +1806,7 @@ func (mdl *Model) NewTransaction(coin, account string) (tx *Transaction, err err
 	}
 
 	// initialize values
-	now := time.Now().Unix()
+	now := mdl.now().Unix()
 	idData := make([]byte, 32)
 	rand.Read(idData)
 
@@ -956,7 +1816,15 @@ func (mdl *Model) NewTransaction(coin, account string) (tx *Transaction, err err
 		Addr:      addr,
 		Status:    0,
 		ValidFrom: now,
-		ValidTo:   now + int64(mdl.cfg.TxTTL),
+		ValidTo:   now + int64(ttl),
+		Refund:    refund,
+	}
+	var memo sql.NullInt64
+	if withMemo {
+		memoData := make([]byte, 4)
+		rand.Read(memoData)
+		tx.Memo = int64(binary.BigEndian.Uint32(memoData)&0x7fffffff) + 1
+		memo = sql.NullInt64{Int64: tx.Memo, Valid: true}
 	}
 	var addrID int64
 	var accnt sql.NullString
@@ -970,8 +1838,8 @@ func (mdl *Model) NewTransaction(coin, account string) (tx *Transaction, err err
 	}
 	// insert transaction into model
 	if _, err = mdltx.Exec(
-		"insert into tx(txid,addr,validFrom,validTo) values(?,?,?,?)",
-		tx.ID, addrID, tx.ValidFrom, tx.ValidTo); err != nil {
+		"insert into tx(txid,addr,validFrom,validTo,memo,refund) values(?,?,?,?,?,?)",
+		tx.ID, addrID, tx.ValidFrom, tx.ValidTo, memo, refAddr); err != nil {
 		mdltx.Rollback()
 		return
 	}
@@ -981,15 +1849,21 @@ func (mdl *Model) NewTransaction(coin, account string) (tx *Transaction, err err
 		return
 	}
 	// commit repository transaction
-	err = mdltx.Commit()
+	if err = mdltx.Commit(); err == nil {
+		if id := RequestID(ctx); len(id) > 0 {
+			mdl.reqIDs.Store(addrID, id)
+		}
+	}
 	return
 }
 
-// GetTransactions returns a list of Tx instances for a given address
-func (mdl *Model) GetTransactions(addrId, accntId, coinId int64) (txs []*Transaction, err error) {
+// GetTransactions returns a list of Tx instances for a given address,
+// account and/or coin. The list is paginated (if "limit" is greater than
+// zero); "total" is the number of all matching transactions.
+func (mdl *Model) GetTransactions(addrId, accntId, coinId int64, limit, offset int) (txs []*Transaction, total int, err error) {
 	// check for valid repository
 	if mdl.inst == nil {
-		return nil, ErrModelNotAvailable
+		return nil, 0, ErrModelNotAvailable
 	}
 	// assemble WHERE clause
 	clause := ""
@@ -1005,12 +1879,20 @@ func (mdl *Model) GetTransactions(addrId, accntId, coinId int64) (txs []*Transac
 	addClause(accntId, "accntId")
 	addClause(coinId, "coinId")
 
-	// assemble SELECT statement
-	query := "select txid,addr,coin,account,stat,validFrom,validTo from v_tx"
 	if len(clause) > 0 {
-		query += " where" + clause
+		clause = " where" + clause
+	}
+	// count matching transactions
+	row := mdl.inst.QueryRow("select count(*) from v_tx" + clause)
+	if err = row.Scan(&total); err != nil {
+		return
 	}
+	// assemble SELECT statement
+	query := "select txid,addr,coin,account,stat,validFrom,validTo,memo,refund from v_tx" + clause
 	query += " order by validFrom desc"
+	if limit > 0 {
+		query += fmt.Sprintf(" limit %d offset %d", limit, offset)
+	}
 
 	// query model for transactions of given address
 	var rows *sql.Rows
@@ -1022,7 +1904,7 @@ func (mdl *Model) GetTransactions(addrId, accntId, coinId int64) (txs []*Transac
 	// assemble list
 	for rows.Next() {
 		tx := new(Transaction)
-		if err = rows.Scan(&tx.ID, &tx.Addr, &tx.Coin, &tx.Accnt, &tx.Status, &tx.ValidFrom, &tx.ValidTo); err != nil {
+		if err = rows.Scan(&tx.ID, &tx.Addr, &tx.Coin, &tx.Accnt, &tx.Status, &tx.ValidFrom, &tx.ValidTo, &tx.Memo, &tx.Refund); err != nil {
 			return
 		}
 		txs = append(txs, tx)
@@ -1039,22 +1921,133 @@ func (mdl *Model) GetTransaction(txid string) (tx *Transaction, err error) {
 	// get information about transaction from model
 	tx = new(Transaction)
 	tx.ID = txid
+	var addrID int64
 	row := mdl.inst.QueryRow(
-		"select addr,coin,account,stat,validFrom,validTo from v_tx where txid=?", txid)
-	err = row.Scan(&tx.Addr, &tx.Coin, &tx.Accnt, &tx.Status, &tx.ValidFrom, &tx.ValidTo)
+		"select t.addrId,t.addr,c.symbol,t.account,t.stat,t.validFrom,t.validTo,t.memo,t.refund"+
+			" from v_tx t inner join coin c on c.id=t.coinId where t.txid=?", txid)
+	if err = row.Scan(&addrID, &tx.Addr, &tx.Coin, &tx.Accnt, &tx.Status, &tx.ValidFrom, &tx.ValidTo, &tx.Memo, &tx.Refund); err != nil {
+		return
+	}
+	// check for late funds (received in grace period after expiration)
+	var n int
+	row = mdl.inst.QueryRow(
+		"select count(*) from incoming where addr=? and firstSeen>? and firstSeen<=?",
+		addrID, tx.ValidTo, tx.ValidTo+int64(mdl.cfg.TxGrace))
+	if err = row.Scan(&n); err != nil {
+		return
+	}
+	tx.Late = (n > 0)
 	return
 }
 
-// GetExpiredTransactions collects transactions that have expired.
+// TxDetail holds all information about a transaction (for diagnostics).
+type TxDetail struct {
+	Tx          *Transaction `json:"tx"`          // transaction
+	Symbol      string       `json:"symbol"`      // coin symbol
+	Balance     float64      `json:"balance"`     // current address balance
+	AddrStatus  int          `json:"addrStatus"`  // address status
+	Funds       []*TxFund    `json:"funds"`       // funds in validity window
+	Fiat        string       `json:"fiat"`        // fiat currency of rates
+	RateCreated float64      `json:"rateCreated"` // coin rate at creation (-1 = unknown)
+	RateCurrent float64      `json:"rateCurrent"` // current coin rate
+}
+
+// TxFund is incoming funds on the address of a transaction
+type TxFund struct {
+	Seen   int64   `json:"seen"`   // time funds first seen
+	Amount float64 `json:"amount"` // amount of funds
+	Late   bool    `json:"late"`   // received in grace period
+}
+
+// GetTransactionDetail returns the full information about a transaction:
+// its status and address, the funds received on the address within the
+// validity window (including the grace period) and the coin rates (in
+// given fiat currency) at creation time and now.
+func (mdl *Model) GetTransactionDetail(txid, fiat string) (td *TxDetail, err error) {
+	// get transaction
+	td = &TxDetail{Fiat: fiat}
+	if td.Tx, err = mdl.GetTransaction(txid); err != nil {
+		return nil, err
+	}
+	// get address and coin information
+	var addrID int64
+	row := mdl.inst.QueryRow(`
+		select a.id, a.balance, a.stat, c.symbol, c.rate from v_tx t
+		inner join addr a on a.id = t.addrId
+		inner join coin c on c.id = t.coinId
+		where t.txid = ?`, txid)
+	if err = row.Scan(&addrID, &td.Balance, &td.AddrStatus, &td.Symbol, &td.RateCurrent); err != nil {
+		return nil, err
+	}
+	// get funds in validity window
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(
+		"select firstSeen,amount from incoming where addr=? and firstSeen>=? and firstSeen<=? order by firstSeen",
+		addrID, td.Tx.ValidFrom, td.Tx.ValidTo+int64(mdl.cfg.TxGrace)); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f := new(TxFund)
+		if err = rows.Scan(&f.Seen, &f.Amount); err != nil {
+			return nil, err
+		}
+		f.Late = f.Seen > td.Tx.ValidTo
+		td.Funds = append(td.Funds, f)
+	}
+	// get rate at creation (from rates table)
+	dt := time.Unix(td.Tx.ValidFrom, 0).Format("2006-01-02")
+	if td.RateCreated, err = mdl.GetRate(dt, td.Symbol, fiat); err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// GetTxReceived returns the amount of funds received by the address of a
+// transaction within the validity window of the transaction (including the
+// grace period). If addresses are reused, the stored balance accumulates
+// payments of multiple transactions; the received amount only includes
+// funds first seen during the transaction.
+func (mdl *Model) GetTxReceived(txid string) (amount float64, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, ErrModelNotAvailable
+	}
+	row := mdl.inst.QueryRow(`
+		select coalesce(sum(i.amount),0) from incoming i
+		inner join v_tx t on t.addrId = i.addr
+		where t.txid = ? and i.firstSeen >= t.validFrom and i.firstSeen <= t.validTo + ?`,
+		txid, mdl.cfg.TxGrace)
+	err = row.Scan(&amount)
+	return
+}
+
+// GetExpiredTransactions collects open transactions that have expired.
 // Returns a mapping between transaction and associated address.
 func (mdl *Model) GetExpiredTransactions() (map[int64]int64, error) {
+	// collect expired transactions
+	t := mdl.now().Unix()
+	return mdl.getTransactions("select id,addr from tx where stat=0 and validTo<?", t)
+}
+
+// GetLateTransactions collects transactions that have expired but are
+// still in the grace period (their addresses are still watched).
+// Returns a mapping between transaction and associated address.
+func (mdl *Model) GetLateTransactions() (map[int64]int64, error) {
+	// collect transactions in grace period (closed or not)
+	t := mdl.now().Unix()
+	return mdl.getTransactions(
+		"select id,addr from tx where validTo<? and validTo>=?",
+		t, t-int64(mdl.cfg.TxGrace))
+}
+
+// get a mapping between transaction and associated address from query.
+func (mdl *Model) getTransactions(query string, args ...interface{}) (map[int64]int64, error) {
 	// check for valid repository
 	if mdl.inst == nil {
 		return nil, ErrModelNotAvailable
 	}
-	// collect expired transactions
-	t := time.Now().Unix()
-	rows, err := mdl.inst.Query("select id,addr from tx where stat=0 and validTo<?", t)
+	rows, err := mdl.inst.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1082,19 +2075,130 @@ func (mdl *Model) CloseTransaction(txID int64) error {
 	return err
 }
 
+// CloseExpiredTransactions closes transactions that have expired in a
+// single database transaction (funds received in the grace period are
+// still attributed to closed transactions). If the number of transactions
+// closed per call is limited (model setting "maxClose"), the oldest
+// transactions are closed first; the remaining backlog is closed in later
+// calls. Returns the number of closed transactions, the number of expired
+// transactions left open and the (unique) list of associated addresses.
+func (mdl *Model) CloseExpiredTransactions() (n, left int64, addrIds []int64, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, 0, nil, ErrModelNotAvailable
+	}
+	// start repository transaction
+	var mdltx *sql.Tx
+	if mdltx, err = mdl.inst.BeginTx(context.Background(), nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			mdltx.Rollback()
+		}
+	}()
+	// collect expired transactions (oldest first)
+	t := mdl.now().Unix()
+	query := "select id,addr from tx where stat=0 and validTo<? order by validTo,id"
+	if mdl.cfg.MaxClose > 0 {
+		query += fmt.Sprintf(" limit %d", mdl.cfg.MaxClose)
+	}
+	var rows *sql.Rows
+	if rows, err = mdltx.Query(query, t); err != nil {
+		return
+	}
+	var ids []any
+	seen := make(map[int64]bool)
+	for rows.Next() {
+		var id, addrID int64
+		if err = rows.Scan(&id, &addrID); err != nil {
+			rows.Close()
+			return
+		}
+		ids = append(ids, id)
+		if !seen[addrID] {
+			seen[addrID] = true
+			addrIds = append(addrIds, addrID)
+		}
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		err = mdltx.Commit()
+		return
+	}
+	// close expired transactions (all or the collected batch)
+	var res sql.Result
+	if mdl.cfg.MaxClose > 0 {
+		query = "update tx set stat=1 where id in (?" + strings.Repeat(",?", len(ids)-1) + ")"
+		res, err = mdltx.Exec(query, ids...)
+	} else {
+		res, err = mdltx.Exec("update tx set stat=1 where stat=0 and validTo<?", t)
+	}
+	if err != nil {
+		return
+	}
+	if n, err = res.RowsAffected(); err != nil {
+		return
+	}
+	// count remaining backlog
+	if mdl.cfg.MaxClose > 0 {
+		row := mdltx.QueryRow("select count(*) from tx where stat=0 and validTo<?", t)
+		if err = row.Scan(&left); err != nil {
+			return
+		}
+	}
+	// commit repository transaction
+	err = mdltx.Commit()
+	return
+}
+
 //----------------------------------------------------------------------
 // Market-related methods
 //----------------------------------------------------------------------
 
+// StaleRate is a coin without recent exchange rates
+type StaleRate struct {
+	Coin string `json:"coin"` // coin symbol
+	Last string `json:"last"` // date of last rate (YYYY-MM-DD; empty = never)
+}
+
+// GetStaleRates returns the coins whose most recent exchange rate (in the
+// given fiat currency) is older than maxAge seconds. As rates are stored
+// per day, the age is checked with a granularity of days.
+func (mdl *Model) GetStaleRates(maxAge int64, fiat string) (list []*StaleRate, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	limit := mdl.now().Add(-time.Duration(maxAge) * time.Second).Format("2006-01-02")
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(
+		"select c.symbol, coalesce(max(r.dt),'') as last from coin c"+
+			" left join rates r on r.coin = c.symbol and r.fiat = ?"+
+			" group by c.symbol having coalesce(max(r.dt),'') < ? order by c.symbol",
+		fiat, limit); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sr := new(StaleRate)
+		if err = rows.Scan(&sr.Coin, &sr.Last); err != nil {
+			return
+		}
+		list = append(list, sr)
+	}
+	return
+}
+
 // UpdateRate sets the new exchange rate (in market base currency) for
-// the given coin.
-func (mdl *Model) UpdateRate(dt, coin, fiat string, rate float64) error {
+// the given coin. Estimated rates (not from a direct fiat pair) are flagged.
+func (mdl *Model) UpdateRate(dt, coin, fiat string, rate float64, est bool) error {
 	// check for valid repository
 	if mdl.inst == nil {
 		return ErrModelNotAvailable
 	}
 	// update rate in coin record
-	if _, err := mdl.inst.Exec("update coin set rate=? where symbol=?", rate, coin); err != nil {
+	if _, err := mdl.inst.Exec("update coin set rate=?,est=? where symbol=?", rate, est, coin); err != nil {
 		return err
 	}
 	// update rate in rates table
@@ -1102,20 +2206,225 @@ func (mdl *Model) UpdateRate(dt, coin, fiat string, rate float64) error {
 }
 
 // GetRate returns a historical exchange rate for coin from rates table.
+// For dates beyond the retention of daily rates, the weekly rate is
+// returned if there is no daily rate.
 func (mdl *Model) GetRate(dt, coin, fiat string) (rate float64, err error) {
 	row := mdl.inst.QueryRow("select rate from rates where dt=? and coin=? and fiat=?", dt, coin, fiat)
 	if err = row.Scan(&rate); err != nil {
 		rate = -1
+		if errors.Is(err, sql.ErrNoRows) && mdl.cfg.Rates != nil && mdl.cfg.Rates.Keep > 0 {
+			// check for downsampled (weekly) rate
+			limit := mdl.now().AddDate(0, 0, -mdl.cfg.Rates.Keep).Format("2006-01-02")
+			if week, ok := weekStart(dt); ok && dt < limit {
+				row = mdl.inst.QueryRow("select rate from rates where dt=? and coin=? and fiat=?", week, coin, fiat)
+				if err = row.Scan(&rate); err != nil {
+					rate = -1
+				}
+			}
+		}
 	}
 	return
 }
 
-// SetRate sets a historical exchange rate for coin in rates table.
-func (mdl *Model) SetRate(dt, coin, fiat string, rate float64) error {
-	// update rate in rates table
+// weekStart returns the date of the Monday in the week of a date.
+func weekStart(dt string) (string, bool) {
+	t, err := time.Parse("2006-01-02", dt)
+	if err != nil {
+		return "", false
+	}
+	t = t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	return t.Format("2006-01-02"), true
+}
+
+// PruneRates downsamples daily rates older than 'keep' days to weekly
+// rates (stored for the Monday of a week; the rate is the average over
+// all rates in the week). Returns the number of removed entries.
+func (mdl *Model) PruneRates(keep int) (n int64, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return 0, ErrModelNotAvailable
+	}
+	if keep <= 0 {
+		return 0, nil
+	}
+	// serialize rate updates
+	mdl.rateLock.Lock()
+	defer mdl.rateLock.Unlock()
+
+	limit := mdl.now().AddDate(0, 0, -keep).Format("2006-01-02")
+	ctx := context.Background()
+	var tx *sql.Tx
+	if tx, err = mdl.inst.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// aggregate old rates per week
+	type weekly struct {
+		sum float64 // sum of weighted rates
+		n   int64   // number of rates
+	}
+	weeks := make(map[[3]string]*weekly)
+	var keys [][3]string
+	var rows *sql.Rows
+	if rows, err = tx.Query("select dt,coin,fiat,rate,n from rates where dt<?", limit); err != nil {
+		return
+	}
+	var count int64
+	for rows.Next() {
+		var (
+			dt, coin, fiat string
+			rate           float64
+			cnt            int64
+		)
+		if err = rows.Scan(&dt, &coin, &fiat, &rate, &cnt); err != nil {
+			rows.Close()
+			return
+		}
+		count++
+		week, ok := weekStart(dt)
+		if !ok {
+			continue
+		}
+		cnt = max(cnt, 1)
+		key := [3]string{week, coin, fiat}
+		w, ok := weeks[key]
+		if !ok {
+			w = new(weekly)
+			weeks[key] = w
+			keys = append(keys, key)
+		}
+		w.sum += rate * float64(cnt)
+		w.n += cnt
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	// replace daily by weekly rates
+	if _, err = tx.Exec("delete from rates where dt<?", limit); err != nil {
+		return
+	}
+	for _, key := range keys {
+		w := weeks[key]
+		if _, err = tx.Exec("insert into rates(dt,coin,fiat,rate,n) values(?,?,?,?,?)",
+			key[0], key[1], key[2], w.sum/float64(w.n), w.n); err != nil {
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	return count - int64(len(keys)), nil
+}
+
+// SetRate sets a historical exchange rate for coin in rates table. If the
+// rates table has an entry for the date already, the rate is averaged over
+// all rates for that date. Concurrent updates are serialized (the averaging
+// depends on the current entry); an update of a SQLite3 database that is
+// locked by another process is retried.
+func (mdl *Model) SetRate(dt, coin, fiat string, rate float64) (err error) {
+	// serialize rate updates
+	mdl.rateLock.Lock()
+	defer mdl.rateLock.Unlock()
+
+	// update rate in rates table (atomic upsert)
+	query := "insert into rates(dt,coin,rate,fiat) values(?,?,?,?)" +
+		" on duplicate key update rate=(n*rate+?)/(n+1), n=n+1"
+	if mdl.cfg.DbEngine == "sqlite3" {
+		query = "insert into rates(dt,coin,rate,fiat) values(?,?,?,?)" +
+			" on conflict(dt,coin,fiat) do update set rate=(n*rate+?)/(n+1), n=n+1"
+	}
+	for retry := 0; retry < 5; retry++ {
+		if _, err = mdl.inst.Exec(query, dt, coin, rate, fiat, rate); !dbBusy(err) {
+			break
+		}
+		time.Sleep(time.Duration(retry+1) * 100 * time.Millisecond)
+	}
+	return
+}
+
+// check if a SQLite3 database is locked by another connection
+func dbBusy(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) && (se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked)
+}
+
+//----------------------------------------------------------------------
+// Event-related methods
+//----------------------------------------------------------------------
+
+// FailedEvent is a payment event that could not be delivered (dead-letter
+// log of the webhook).
+type FailedEvent struct {
+	ID      int64  `json:"id"`      // database record id
+	Body    string `json:"body"`    // event payload (JSON)
+	Error   string `json:"error"`   // last delivery error
+	Tries   int    `json:"tries"`   // number of delivery attempts
+	Created int64  `json:"created"` // time of first failure
+	LastTry int64  `json:"lastTry"` // time of last attempt
+}
+
+// truncate error message for storage
+func errMsg(err error) string {
+	return truncMsg(err.Error())
+}
+
+// truncate message for storage in a varchar(255) column
+func truncMsg(msg string) string {
+	if r := []rune(msg); len(r) > 255 {
+		msg = string(r[:255])
+	}
+	return msg
+}
+
+// AddFailedEvent stores an undelivered event after 'tries' failed attempts.
+func (mdl *Model) AddFailedEvent(body []byte, tries int, cause error) error {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	now := mdl.now().Unix()
 	_, err := mdl.inst.Exec(
-		"insert into rates(dt,coin,rate,fiat) values(?,?,?,?)"+
-			" on duplicate key update rate=(n*rate+?)/(n+1), n=n+1",
-		dt, coin, rate, fiat, rate)
+		"insert into failed_events(body,error,tries,created,lastTry) values(?,?,?,?,?)",
+		string(body), errMsg(cause), tries, now, now)
 	return err
 }
+
+// GetFailedEvents returns all undelivered events (oldest first).
+func (mdl *Model) GetFailedEvents() (list []*FailedEvent, err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return nil, ErrModelNotAvailable
+	}
+	var rows *sql.Rows
+	if rows, err = mdl.inst.Query(
+		"select id,body,coalesce(error,''),tries,created,lastTry from failed_events order by id"); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ev := new(FailedEvent)
+		if err = rows.Scan(&ev.ID, &ev.Body, &ev.Error, &ev.Tries, &ev.Created, &ev.LastTry); err != nil {
+			return
+		}
+		list = append(list, ev)
+	}
+	return list, rows.Err()
+}
+
+// RetriedEvent records the result of another delivery attempt of an
+// undelivered event: the event is removed on success (cause is nil).
+func (mdl *Model) RetriedEvent(ID int64, cause error) (err error) {
+	// check for valid repository
+	if mdl.inst == nil {
+		return ErrModelNotAvailable
+	}
+	if cause == nil {
+		_, err = mdl.inst.Exec("delete from failed_events where id=?", ID)
+		return
+	}
+	_, err = mdl.inst.Exec("update failed_events set error=?,tries=tries+1,lastTry=? where id=?",
+		errMsg(cause), mdl.now().Unix(), ID)
+	return
+}
@@ -0,0 +1,586 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/bitcoin/wallet"
+)
+
+// create a model on a new SQLite3 database (schema from the create script)
+func newTestModel(t *testing.T) *Model {
+	t.Helper()
+	schema, err := os.ReadFile("../db/db_create.sqlite3.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ModelConfig{
+		DbEngine:    "sqlite3",
+		DbConnect:   t.TempDir() + "/test.db",
+		BalanceWait: []float64{300, 2, 3600},
+		TxTTL:       900,
+	}
+	mdl, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mdl.Close() })
+	if _, err = mdl.inst.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return mdl
+}
+
+// execute SQL statements for test data
+func testExec(t *testing.T, db *sql.DB, stmts ...string) {
+	t.Helper()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %s", stmt, err.Error())
+		}
+	}
+}
+
+// fake clock for the model
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestNextWait(t *testing.T) {
+	bw := []float64{300, 2, 3600}
+	for _, tc := range []struct {
+		wait   int64
+		reset  bool
+		factor float64
+		want   int64
+	}{
+		{300, false, 2, 600},
+		{600, false, 2, 1200},
+		{2400, false, 2, 3600}, // capped
+		{3600, false, 2, 3600},
+		{3600, true, 2, 300}, // reset to minimum
+		{300, false, 1.5, 450},
+	} {
+		if got := NextWait(bw, tc.wait, tc.reset, tc.factor); got != tc.want {
+			t.Errorf("NextWait(%d,%v,%f) = %d, want %d", tc.wait, tc.reset, tc.factor, got, tc.want)
+		}
+	}
+}
+
+func TestWaitProgression(t *testing.T) {
+	mdl := newTestModel(t)
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	mdl.SetClock(clock.now)
+	mdl.SetWaitFactor(func(f float64) float64 { return f })
+
+	t0 := clock.t.Unix()
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label,logo) values(1,'btc','Bitcoin','')",
+		"insert into account(id,label,name) values(1,'shop','Shop')",
+		fmt.Sprintf("insert into addr(id,coin,idx,val,accnt,nextCheck,waitCheck) values(1,1,0,'addr',1,%d,300)", t0),
+	)
+	// check schedule of address
+	check := func(wantWait, wantNext, wantLast int64) {
+		t.Helper()
+		var wait, next, last int64
+		row := mdl.inst.QueryRow("select waitCheck,nextCheck,lastCheck from addr where id=1")
+		if err := row.Scan(&wait, &next, &last); err != nil {
+			t.Fatal(err)
+		}
+		if wait != wantWait || next != wantNext || last != wantLast {
+			t.Fatalf("wait=%d next=%d last=%d, want %d/%d/%d", wait, next, last, wantWait, wantNext, wantLast)
+		}
+	}
+	pending := func() bool {
+		t.Helper()
+		list, err := mdl.PendingAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list["btc"]) == 1
+	}
+
+	// wait time doubles (up to the maximum); the next check is due after
+	// the wait time
+	next := t0
+	for _, wait := range []int64{600, 1200, 2400, 3600, 3600} {
+		if !pending() {
+			t.Fatalf("address not pending at %d", clock.t.Unix())
+		}
+		last := clock.t.Unix()
+		if err := mdl.NextUpdate(1, false); err != nil {
+			t.Fatal(err)
+		}
+		next += wait
+		check(wait, next, last)
+		clock.advance(time.Duration(wait-1) * time.Second)
+		if pending() {
+			t.Fatalf("address pending before next check (%d < %d)", clock.t.Unix(), next)
+		}
+		clock.advance(time.Second)
+	}
+	// a balance change resets the wait time to the minimum
+	last := clock.t.Unix()
+	if err := mdl.NextUpdate(1, true); err != nil {
+		t.Fatal(err)
+	}
+	check(300, next+300, last)
+}
+
+func TestWaitCap(t *testing.T) {
+	mdl := newTestModel(t)
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	mdl.SetClock(clock.now)
+	mdl.SetWaitFactor(func(f float64) float64 { return f })
+
+	// cap at a month (longer than a week)
+	const month = 30 * 24 * 3600
+	mdl.cfg.BalanceWait = []float64{300, 2, month}
+
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label,logo) values(1,'btc','Bitcoin','')",
+		"insert into account(id,label,name) values(1,'shop','Shop')",
+		fmt.Sprintf("insert into addr(id,coin,idx,val,accnt,nextCheck,waitCheck) values(1,1,0,'addr',1,%d,300)", clock.t.Unix()),
+	)
+	wait := func() (wait int64) {
+		t.Helper()
+		if err := mdl.inst.QueryRow("select waitCheck from addr where id=1").Scan(&wait); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// wait time grows beyond a week and saturates at the configured cap
+	prev := wait()
+	for i := 0; i < 20; i++ {
+		if err := mdl.NextUpdate(1, false); err != nil {
+			t.Fatal(err)
+		}
+		w := wait()
+		if w > month {
+			t.Fatalf("wait time %d exceeds cap %d", w, month)
+		}
+		if w < prev {
+			t.Fatalf("wait time decreased: %d < %d", w, prev)
+		}
+		prev = w
+	}
+	if prev != month {
+		t.Fatalf("wait time %d, want %d", prev, month)
+	}
+}
+
+func TestTxGrace(t *testing.T) {
+	mdl := newTestModel(t)
+	mdl.cfg.TxGrace = 600
+	clk := &testClock{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	mdl.SetClock(clk.now)
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label) values(1,'btc','Bitcoin')",
+		"insert into account(id,label,name) values(1,'test','Test')",
+		"insert into addr(id,coin,accnt,idx,val) values(1,1,1,0,'addr1')",
+	)
+	ctx := context.Background()
+	tx1, err := mdl.NewTransaction(ctx, "btc", "test", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// transaction is closed on expiration...
+	clk.advance(901 * time.Second)
+	n, _, _, err := mdl.CloseExpiredTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("closed %d transactions, want 1", n)
+	}
+	// ...but its address is still watched in the grace period
+	late, err := mdl.GetLateTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(late) != 1 || late[1] != 1 {
+		t.Fatalf("late transactions %v, want tx 1 on address 1", late)
+	}
+	// late funds are attributed to the expired transaction
+	clk.advance(60 * time.Second)
+	testExec(t, mdl.inst, fmt.Sprintf(
+		"insert into incoming(firstSeen,addr,amount) values(%d,1,0.5)", clk.now().Unix()))
+	tx, err := mdl.GetTransaction(tx1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Status != 1 || !tx.Late {
+		t.Errorf("tx status=%d, late=%v; want closed and late", tx.Status, tx.Late)
+	}
+	// address is not reused during the grace period
+	testExec(t, mdl.inst, "insert into addr(id,coin,accnt,idx,val) values(2,1,1,1,'addr2')")
+	tx2, err := mdl.NewTransaction(ctx, "btc", "test", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx2.Addr != "addr2" {
+		t.Errorf("address '%s' used in grace period", tx2.Addr)
+	}
+	// after the grace period, the address is no longer watched
+	clk.advance(600 * time.Second)
+	if late, err = mdl.GetLateTransactions(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := late[1]; ok {
+		t.Error("tx 1 still in grace period")
+	}
+}
+
+func TestNewAccount(t *testing.T) {
+	mdl := newTestModel(t)
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label) values(1,'btc','Bitcoin')",
+		"insert into coin(id,symbol,label) values(2,'eth','Ethereum')",
+	)
+	count := func(query string) (n int) {
+		t.Helper()
+		if err := mdl.inst.QueryRow(query).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// account is created with its coins
+	id, err := mdl.NewAccount("shop", "Shop", "usd", []int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(fmt.Sprintf("select count(*) from accept where accnt=%d", id)); n != 2 {
+		t.Errorf("%d coins assigned, want 2", n)
+	}
+	// failed coin assignment leaves no account behind
+	if _, err = mdl.NewAccount("other", "Other", "", []int64{1, 1}); err == nil {
+		t.Fatal("duplicate coin assignment accepted")
+	}
+	if n := count("select count(*) from account where label='other'"); n != 0 {
+		t.Errorf("account created without its coins")
+	}
+	// failed account creation leaves no coin assignments behind
+	if _, err = mdl.NewAccount("shop", "Shop 2", "", []int64{2}); err == nil {
+		t.Fatal("duplicate label accepted")
+	}
+	if n := count("select count(*) from accept"); n != 2 {
+		t.Errorf("%d coin assignments, want 2", n)
+	}
+}
+
+func TestNewItem(t *testing.T) {
+	columns := []string{"id", "name", "status", "label", "rate", "count"}
+	for _, tc := range []struct {
+		driver string
+		values []interface{}
+	}{
+		// MySQL returns text and numbers as byte arrays (without type info)
+		{"bytes", []interface{}{[]uint8("7"), []uint8("Bitcoin"), []uint8("1"), []uint8("btc"), 1.5, int32(3)}},
+		// SQLite3 returns typed values
+		{"typed", []interface{}{int64(7), "Bitcoin", true, "btc", float32(1.5), int64(3)}},
+	} {
+		item, err := newItem(columns, tc.values)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.driver, err.Error())
+		}
+		if item.ID != 7 || item.Name != "Bitcoin" || !item.Status {
+			t.Errorf("%s: got %s", tc.driver, item)
+		}
+		if item.Dict["label"] != "btc" || item.Dict["rate"] != 1.5 || item.Dict["count"] != int64(3) {
+			t.Errorf("%s: dict %v", tc.driver, item.Dict)
+		}
+	}
+	// unexpected column type for id
+	if _, err := newItem(columns, []interface{}{1.5, "x", 0, nil, nil, nil}); err == nil {
+		t.Error("invalid id accepted")
+	}
+}
+
+func TestCloseExpired(t *testing.T) {
+	mdl := newTestModel(t)
+	clk := &testClock{time.Unix(1700000000, 0)}
+	mdl.SetClock(clk.now)
+	t0 := clk.t.Unix()
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label) values(1,'btc','Bitcoin')",
+		"insert into account(id,label,name) values(1,'test','Test')",
+		"insert into addr(id,coin,accnt,idx,val) values(1,1,1,0,'addr1')",
+		"insert into addr(id,coin,accnt,idx,val) values(2,1,1,1,'addr2')",
+		// three expired transactions, one active
+		fmt.Sprintf("insert into tx(id,txid,addr,validFrom,validTo) values(1,'tx1',1,%d,%d)", t0-3000, t0-2000),
+		fmt.Sprintf("insert into tx(id,txid,addr,validFrom,validTo) values(2,'tx2',2,%d,%d)", t0-2000, t0-1000),
+		fmt.Sprintf("insert into tx(id,txid,addr,validFrom,validTo) values(3,'tx3',1,%d,%d)", t0-1000, t0-1),
+		fmt.Sprintf("insert into tx(id,txid,addr,validFrom,validTo) values(4,'tx4',2,%d,%d)", t0, t0+900),
+	)
+	closed := func() (n int) {
+		t.Helper()
+		if err := mdl.inst.QueryRow("select count(*) from tx where stat=1").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// a failing update closes no transaction of the batch
+	testExec(t, mdl.inst,
+		"create trigger fail before update on tx when new.id=3 begin select raise(abort,'failed'); end")
+	if _, _, _, err := mdl.CloseExpiredTransactions(); err == nil {
+		t.Fatal("failed update not reported")
+	}
+	if n := closed(); n != 0 {
+		t.Fatalf("%d transactions closed in failed batch", n)
+	}
+	testExec(t, mdl.inst, "drop trigger fail")
+
+	// batches are limited in size (oldest first)
+	mdl.cfg.MaxClose = 2
+	n, left, addrIds, err := mdl.CloseExpiredTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || left != 1 || len(addrIds) != 2 {
+		t.Fatalf("closed %d (left %d, addresses %v), want 2/1/2", n, left, addrIds)
+	}
+	n, left, addrIds, err = mdl.CloseExpiredTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || left != 0 || len(addrIds) != 1 || addrIds[0] != 1 {
+		t.Fatalf("closed %d (left %d, addresses %v), want 1/0/[1]", n, left, addrIds)
+	}
+	// active transaction is not closed
+	if n := closed(); n != 3 {
+		t.Fatalf("%d transactions closed, want 3", n)
+	}
+}
+
+func TestConcurrentRates(t *testing.T) {
+	mdl := newTestModel(t)
+	// second model on the same database (like another process)
+	mdl2, err := Connect(mdl.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdl2.Close()
+
+	// concurrent updates of the same rate (values 1..N)
+	const N = 40
+	var wg sync.WaitGroup
+	errs := make(chan error, N)
+	for i := 1; i <= N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := mdl
+			if i%2 == 0 {
+				m = mdl2
+			}
+			if err := m.SetRate("2024-03-01", "btc", "EUR", float64(i)); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	// all rates are counted and averaged
+	var n int
+	var rate float64
+	row := mdl.inst.QueryRow("select n,rate from rates where dt='2024-03-01' and coin='btc' and fiat='EUR'")
+	if err = row.Scan(&n, &rate); err != nil {
+		t.Fatal(err)
+	}
+	if n != N || math.Abs(rate-float64(N+1)/2) > 1e-9 {
+		t.Fatalf("n=%d, rate=%f; want %d/%f", n, rate, N, float64(N+1)/2)
+	}
+}
+
+func TestFirstCheck(t *testing.T) {
+	mdl := newTestModel(t)
+	mdl.cfg.FirstCheck = 60
+	mdl.sel = new(FreshSelector)
+	clk := &testClock{time.Unix(1700000000, 0)}
+	mdl.SetClock(clk.now)
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label) values(1,'btc','Bitcoin')",
+		"insert into account(id,label,name) values(1,'test','Test')",
+	)
+	// handler deriving new addresses locally
+	pk, err := wallet.ParseExtendedPublicKey(testXpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coin, _ := wallet.GetCoinInfo("btc")
+	HdlrList["btc"] = &Handler{
+		coin:    coin,
+		symb:    "btc",
+		tree:    wallet.NewHDPublic(pk, "m"),
+		pathTpl: "m/0/%d",
+	}
+	defer delete(HdlrList, "btc")
+
+	pending := func() int {
+		t.Helper()
+		list, err := mdl.PendingAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list["btc"])
+	}
+	// new address is not checked before the first check delay
+	if _, err = mdl.NewTransaction(context.Background(), "btc", "test", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := pending(); n != 0 {
+		t.Fatalf("%d addresses pending after creation", n)
+	}
+	clk.advance(59 * time.Second)
+	if n := pending(); n != 0 {
+		t.Fatalf("%d addresses pending before first check", n)
+	}
+	clk.advance(time.Second)
+	if n := pending(); n != 1 {
+		t.Fatalf("%d addresses pending after first check delay, want 1", n)
+	}
+	// default delay is the minimum wait time
+	mdl.cfg.FirstCheck = 0
+	if _, err = mdl.NewTransaction(context.Background(), "btc", "test", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	clk.advance(299 * time.Second)
+	if n := pending(); n != 1 {
+		t.Fatalf("%d addresses pending before minimum wait, want 1", n)
+	}
+	clk.advance(time.Second)
+	if n := pending(); n != 2 {
+		t.Fatalf("%d addresses pending after minimum wait, want 2", n)
+	}
+}
+
+func TestSaveHandlerStats(t *testing.T) {
+	mdl := newTestModel(t)
+	// a failing entry doesn't abort the batch
+	testExec(t, mdl.inst,
+		"create trigger fail before insert on hdlrstat when new.name='bad' begin select raise(abort,'failed'); end")
+	long := strings.Repeat("ä", 300)
+	err := mdl.SaveHandlerStats([]*HandlerStat{
+		{Name: "first", Success: 1},
+		{Name: "bad", Failure: 1},
+		{Name: "last", Failure: 2, LastError: long},
+	})
+	if err == nil {
+		t.Fatal("failed entry not reported")
+	}
+	list, err := mdl.GetHandlerStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "first" || list[1].Name != "last" {
+		t.Fatalf("unexpected stats %v", list)
+	}
+	// long error messages are truncated (in characters)
+	if msg := list[1].LastError; msg != long[:2*255] {
+		t.Fatalf("error message of %d characters stored", len([]rune(msg)))
+	}
+}
+
+func TestIncomingByPeriod(t *testing.T) {
+	mdl := newTestModel(t)
+	day := func(s string) int64 {
+		t.Helper()
+		d, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Unix()
+	}
+	testExec(t, mdl.inst,
+		"insert into coin(id,symbol,label,rate) values(1,'btc','Bitcoin',100)",
+		"insert into account(id,label,name) values(1,'test','Test')",
+		"insert into addr(id,coin,accnt,idx,val) values(1,1,1,0,'addr1')",
+		// rates in USD only for some days
+		"insert into rates(dt,coin,rate,fiat) values('2020-12-31','btc',10,'USD')",
+		"insert into rates(dt,coin,rate,fiat) values('2021-01-04','btc',20,'USD')",
+	)
+	for _, ts := range []string{
+		"2019-12-30 12:00", // Monday: ISO week 2020-W01
+		"2020-12-31 12:00", // Thursday: ISO week 2020-W53
+		"2021-01-01 12:00", // Friday: ISO week 2020-W53 (no USD rate)
+		"2021-01-03 23:00", // Sunday: ISO week 2020-W53 (no USD rate)
+		"2021-01-04 00:30", // Monday: ISO week 2021-W01
+	} {
+		testExec(t, mdl.inst, fmt.Sprintf("insert into incoming(firstSeen,addr,amount) values(%d,1,1)", day(ts)))
+	}
+	from, to := day("2019-12-01 00:00"), day("2021-02-01 00:00")
+
+	// ISO weeks (in fiat currency with missing rates)
+	list, err := mdl.IncomingByPeriod(from, to, "week", "USD", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Revenue{
+		{"2020-W01", 1, 0, 1},
+		{"2020-W53", 3, 10, 2},
+		{"2021-W01", 1, 20, 0},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("got %d weeks, want %d", len(list), len(want))
+	}
+	for i, rev := range list {
+		if *rev != want[i] {
+			t.Errorf("got %v, want %v", *rev, want[i])
+		}
+	}
+	// days (fiat currency of coin rates: current rate as fallback)
+	if list, err = mdl.IncomingByPeriod(from, to, "day", "USD", true); err != nil {
+		t.Fatal(err)
+	}
+	want = []Revenue{
+		{"2019-12-30", 1, 100, 0},
+		{"2020-12-31", 1, 10, 0},
+		{"2021-01-01", 1, 100, 0},
+		{"2021-01-03", 1, 100, 0},
+		{"2021-01-04", 1, 20, 0},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("got %d days, want %d", len(list), len(want))
+	}
+	for i, rev := range list {
+		if *rev != want[i] {
+			t.Errorf("got %v, want %v", *rev, want[i])
+		}
+	}
+	if _, err = mdl.IncomingByPeriod(from, to, "month", "USD", true); err != ErrMdlInvalidBucket {
+		t.Errorf("got error %v, want %v", err, ErrMdlInvalidBucket)
+	}
+}
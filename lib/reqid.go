@@ -0,0 +1,103 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Correlation of log lines: every web request carries a request id (taken
+// from the 'X-Request-ID' header or generated) in its context. Log lines
+// written with Logf are prefixed with that id; balance checks triggered by
+// a request (new transaction) carry the id of the request.
+
+// HTTP header for request ids
+const RequestIDHeader = "X-Request-ID"
+
+// maximum length of client-supplied request ids
+const maxRequestID = 64
+
+// context key for request id
+type reqIDKey struct{}
+
+// WithRequestID returns a context carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reqIDKey{}, id)
+}
+
+// RequestID returns the request id of a context (or an empty string).
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(reqIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// NewRequestID generates a random request id.
+func NewRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Logf logs a formatted message; the message is prefixed with the request
+// id of the context (if any).
+func Logf(ctx context.Context, level int, format string, args ...any) {
+	if id := RequestID(ctx); len(id) > 0 {
+		format = "[req:" + id + "] " + format
+	}
+	logger.Printf(level, format, args...)
+}
+
+// RequestIDs is a HTTP handler wrapper that assigns a request id to every
+// request. A valid id from the request header is used, otherwise a new id
+// is generated. The id is returned in the response header.
+func RequestIDs(hdlr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		hdlr.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// check client-supplied request id (no log injection)
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
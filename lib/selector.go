@@ -0,0 +1,109 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// AddressSelector implements a policy to select an existing address
+// for a new transaction.
+type AddressSelector interface {
+	// Select returns an existing address for given coin and account.
+	// If no address is selected, an empty string is returned and a new
+	// address is generated by the caller. Addresses of transactions in
+	// their grace period (expired within 'grace' seconds before 'now')
+	// are still watched for late funds and must not be selected.
+	Select(mdltx *sql.Tx, coin, account string, now, grace int64) (string, error)
+}
+
+var (
+	// map of available address selectors (by policy name)
+	addrSelectors = map[string]AddressSelector{
+		"reuse": new(ReuseSelector),
+		"fresh": new(FreshSelector),
+		"pool":  new(PoolSelector),
+	}
+)
+
+// GetAddressSelector returns the address selector for a named policy.
+// The default policy is "reuse".
+func GetAddressSelector(policy string) (AddressSelector, error) {
+	if len(policy) == 0 {
+		policy = "reuse"
+	}
+	sel, ok := addrSelectors[policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown address policy '%s'", policy)
+	}
+	return sel, nil
+}
+
+// helper: select address from query; returns empty string if no
+// address is found.
+func selectAddress(mdltx *sql.Tx, query string, args ...interface{}) (addr string, err error) {
+	row := mdltx.QueryRow(query, args...)
+	if err = row.Scan(&addr); err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+
+// ReuseSelector re-uses the open address (stat=0) of a coin/account
+// pair until it gets closed. While a transaction on the address is in its
+// grace period, a new address is used.
+type ReuseSelector struct{}
+
+// Select an open address
+func (s *ReuseSelector) Select(mdltx *sql.Tx, coin, account string, now, grace int64) (string, error) {
+	return selectAddress(mdltx,
+		"select a.val from v_addr a where a.stat=0 and a.coin=? and a.account=?"+
+			" and not exists (select 1 from tx t where t.addr=a.id and t.validTo<? and t.validTo>=?)",
+		coin, account, now, now-grace)
+}
+
+//----------------------------------------------------------------------
+
+// FreshSelector always requests a new address for a transaction.
+type FreshSelector struct{}
+
+// Select no address (always generate a new one)
+func (s *FreshSelector) Select(mdltx *sql.Tx, coin, account string, now, grace int64) (string, error) {
+	return "", nil
+}
+
+//----------------------------------------------------------------------
+
+// PoolSelector picks an open address from a pool of (pre-generated)
+// addresses that have not been used in a transaction yet (so there is no
+// transaction in its grace period). If the pool is empty, a new address
+// is generated.
+type PoolSelector struct{}
+
+// Select an unused address from the pool
+func (s *PoolSelector) Select(mdltx *sql.Tx, coin, account string, now, grace int64) (string, error) {
+	return selectAddress(mdltx,
+		"select val from v_addr where stat=0 and cnt=0 and coin=? and account=? order by id limit 1",
+		coin, account)
+}
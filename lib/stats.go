@@ -0,0 +1,80 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HandlerStat holds the request statistics of a (blockchain or market)
+// handler.
+type HandlerStat struct {
+	Name      string `json:"name"`      // name of handler
+	Success   int64  `json:"success"`   // number of successful requests
+	Failure   int64  `json:"failure"`   // number of failed requests
+	LastError string `json:"lastError"` // last error message
+	LastFail  int64  `json:"lastFail"`  // time of last failure
+}
+
+// statistics of all handlers used in this process
+var (
+	hdlrStats     = make(map[string]*HandlerStat)
+	hdlrStatsLock sync.Mutex
+)
+
+// recordResult updates the statistics of a named handler with the result
+// of a request.
+func recordResult(name string, err error) {
+	hdlrStatsLock.Lock()
+	defer hdlrStatsLock.Unlock()
+
+	hs, ok := hdlrStats[name]
+	if !ok {
+		hs = &HandlerStat{Name: name}
+		hdlrStats[name] = hs
+	}
+	if err != nil {
+		hs.Failure++
+		hs.LastError = err.Error()
+		hs.LastFail = time.Now().Unix()
+		return
+	}
+	hs.Success++
+}
+
+// GetHandlerStats returns the current statistics of all handlers used
+// (sorted by name).
+func GetHandlerStats() []*HandlerStat {
+	hdlrStatsLock.Lock()
+	defer hdlrStatsLock.Unlock()
+
+	list := make([]*HandlerStat, 0, len(hdlrStats))
+	for _, hs := range hdlrStats {
+		c := *hs
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,160 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Error codes
+var (
+	ErrSVGInvalid = errors.New("not a SVG image")
+)
+
+// elements that are removed from a SVG image (including content)
+var svgBlockedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+}
+
+// SanitizeSVG removes active content from a SVG image: scripts and other
+// embedding elements, event handlers ("on..." attributes) and references
+// to external resources. Comments and directives (like DOCTYPE) are removed
+// as well. Returns an error if the data is not a SVG image.
+func SanitizeSVG(body []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	buf := new(bytes.Buffer)
+	depth := 0    // element nesting depth
+	skip := 0     // depth of skipped element (0 = not skipping)
+	root := false // root element processed?
+	var elem string
+	for {
+		tk, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tk.(type) {
+		case xml.StartElement:
+			depth++
+			if skip > 0 {
+				continue
+			}
+			if !root {
+				if t.Name.Local != "svg" {
+					return nil, ErrSVGInvalid
+				}
+				root = true
+			}
+			elem = strings.ToLower(t.Name.Local)
+			if svgBlockedElements[elem] {
+				skip = depth
+				continue
+			}
+			buf.WriteString("<" + svgName(t.Name))
+			for _, attr := range t.Attr {
+				name := strings.ToLower(attr.Name.Local)
+				if strings.HasPrefix(name, "on") || svgExternalRef(name, attr.Value) {
+					continue
+				}
+				buf.WriteString(" " + svgName(attr.Name) + `="`)
+				xml.EscapeText(buf, []byte(attr.Value))
+				buf.WriteString(`"`)
+			}
+			buf.WriteString(">")
+
+		case xml.EndElement:
+			depth--
+			elem = ""
+			if skip > 0 {
+				if depth < skip {
+					skip = 0
+				}
+				continue
+			}
+			buf.WriteString("</" + svgName(t.Name) + ">")
+
+		case xml.CharData:
+			if skip > 0 || !root {
+				continue
+			}
+			// style sheets must not reference external resources
+			if elem == "style" && svgExternalRef("style", string(t)) {
+				continue
+			}
+			xml.EscapeText(buf, t)
+
+		case xml.ProcInst:
+			if t.Target == "xml" && !root {
+				buf.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		}
+	}
+	if !root {
+		return nil, ErrSVGInvalid
+	}
+	return buf.Bytes(), nil
+}
+
+// return qualified name of element or attribute
+func svgName(n xml.Name) string {
+	if len(n.Space) > 0 {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// check if an attribute value references an external resource. Only
+// local references ("#id") and inline raster images ("data:image/...")
+// are allowed.
+func svgExternalRef(name, value string) bool {
+	val := strings.ToLower(strings.Join(strings.Fields(value), ""))
+	if strings.Contains(val, "javascript:") || strings.Contains(val, "@import") {
+		return true
+	}
+	if name == "href" || name == "src" {
+		return !svgLocalRef(val)
+	}
+	for _, part := range strings.Split(val, "url(")[1:] {
+		if !svgLocalRef(strings.TrimLeft(part, `'"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// check for local reference or inline raster image (nested SVG images
+// could contain active content)
+func svgLocalRef(ref string) bool {
+	if strings.HasPrefix(ref, "#") {
+		return true
+	}
+	return strings.HasPrefix(ref, "data:image/") && !strings.HasPrefix(ref, "data:image/svg")
+}
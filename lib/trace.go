@@ -0,0 +1,277 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Tracing of operations (like blockchain and market queries or database
+// transactions): spans are exported to an OpenTelemetry collector using
+// OTLP over HTTP (JSON encoding). Without configuration, tracing is
+// disabled and spans are no-ops.
+
+// maximum number of spans buffered between exports (excess spans are dropped)
+const maxSpans = 4096
+
+// SpanAttr is a span attribute
+type SpanAttr struct {
+	Key   string
+	Value string
+}
+
+// Attr returns a span attribute
+func Attr(key, value string) SpanAttr {
+	return SpanAttr{Key: key, Value: value}
+}
+
+// Span is a traced operation
+type Span struct {
+	traceID  [16]byte   // trace identifier
+	spanID   [8]byte    // span identifier
+	parentID []byte     // parent span identifier (if any)
+	name     string     // name of operation
+	start    time.Time  // start of operation
+	end      time.Time  // end of operation
+	attrs    []SpanAttr // span attributes
+	err      error      // result of operation
+}
+
+// context key for current span
+type spanKey struct{}
+
+// active span exporter (nil = tracing disabled)
+var tracer *spanExporter
+
+// StartSpan starts a new span for an operation. If the context has a span,
+// the new span is its child. Returns a context with the new span; the span
+// is nil if tracing is disabled.
+func StartSpan(ctx context.Context, name string, attrs ...SpanAttr) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		name:  name,
+		start: time.Now(),
+		attrs: attrs,
+	}
+	rand.Read(s.spanID[:])
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID[:]
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// End a span with the result of the operation.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	tracer.add(s)
+}
+
+// StartTracing starts the export of spans to the configured collector.
+// The export runs until the context is cancelled (remaining spans are
+// exported on exit).
+func StartTracing(ctx context.Context, cfg *TracingConfig) {
+	if cfg == nil || len(cfg.Endpoint) == 0 {
+		return
+	}
+	exp := &spanExporter{
+		url:     cfg.Endpoint + "/v1/traces",
+		service: cfg.Service,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(exp.service) == 0 {
+		exp.service = "bitbank-relay"
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 10
+	}
+	tracer = exp
+	logger.Printf(logger.INFO, "Exporting traces to %s", exp.url)
+	go func() {
+		tick := time.NewTicker(time.Duration(interval) * time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if err := exp.export(ctx); err != nil {
+					logger.Println(logger.WARN, "Tracing: "+err.Error())
+				}
+			case <-ctx.Done():
+				toCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				exp.export(toCtx)
+				cancel()
+				return
+			}
+		}
+	}()
+}
+
+//----------------------------------------------------------------------
+// OTLP exporter
+//----------------------------------------------------------------------
+
+// spanExporter buffers ended spans and exports them periodically
+type spanExporter struct {
+	url     string            // OTLP endpoint for traces
+	service string            // service name
+	headers map[string]string // additional HTTP headers
+	client  *http.Client      // HTTP client for export
+	spans   []*Span           // buffered spans
+	lock    sync.Mutex        // serialize buffer access
+}
+
+// add ended span to buffer
+func (e *spanExporter) add(s *Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.spans) < maxSpans {
+		e.spans = append(e.spans, s)
+	}
+}
+
+// export buffered spans
+func (e *spanExporter) export(ctx context.Context) error {
+	e.lock.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.lock.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	// assemble OTLP request
+	list := make([]*otlpSpan, len(spans))
+	for i, s := range spans {
+		ot := &otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Parent:  hex.EncodeToString(s.parentID),
+			Name:    s.name,
+			Kind:    1, // internal
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		for _, a := range s.attrs {
+			ot.Attrs = append(ot.Attrs, newOtlpAttr(a.Key, a.Value))
+		}
+		if s.err != nil {
+			ot.Status = &otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		list[i] = ot
+	}
+	req := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []*otlpAttr{newOtlpAttr("service.name", e.service)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "relay"},
+				"spans": list,
+			}},
+		}},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	// send request
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		hr.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(hr)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("export of %d spans failed: %s", len(spans), resp.Status)
+	}
+	return nil
+}
+
+// OTLP span (JSON encoding)
+type otlpSpan struct {
+	TraceID string      `json:"traceId"`
+	SpanID  string      `json:"spanId"`
+	Parent  string      `json:"parentSpanId,omitempty"`
+	Name    string      `json:"name"`
+	Kind    int         `json:"kind"`
+	Start   string      `json:"startTimeUnixNano"`
+	End     string      `json:"endTimeUnixNano"`
+	Attrs   []*otlpAttr `json:"attributes,omitempty"`
+	Status  *otlpStatus `json:"status,omitempty"`
+}
+
+// OTLP attribute (string values only)
+type otlpAttr struct {
+	Key   string `json:"key"`
+	Value struct {
+		Str string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func newOtlpAttr(key, value string) *otlpAttr {
+	a := &otlpAttr{Key: key}
+	a.Value.Str = value
+	return a
+}
+
+// OTLP span status
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// return the endpoint (scheme and host) of a query URL: the full URL is
+// not traced as it can contain API keys.
+func endpoint(query string) string {
+	u, err := url.Parse(query)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
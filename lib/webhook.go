@@ -0,0 +1,147 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// WebhookEvent is the payload posted to the webhook on incoming funds.
+type WebhookEvent struct {
+	Address   string  `json:"address"`   // receiving address
+	Coin      string  `json:"coin"`      // coin symbol
+	Amount    float64 `json:"amount"`    // amount received
+	Account   string  `json:"account"`   // account label
+	Timestamp int64   `json:"timestamp"` // time of balance check
+}
+
+// Webhook is a payment handler that posts incoming funds to a URL.
+type Webhook struct {
+	cfg    *WebhookConfig
+	mdl    *Model
+	client *http.Client
+}
+
+// NewWebhook creates a new webhook notifier for incoming funds.
+func NewWebhook(cfg *WebhookConfig, mdl *Model) *Webhook {
+	return &Webhook{
+		cfg:    cfg,
+		mdl:    mdl,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// OnPayment posts the incoming funds to the webhook (in the background).
+func (wh *Webhook) OnPayment(ctx context.Context, p *Payment) {
+	ev := &WebhookEvent{
+		Address:   p.Addr,
+		Coin:      p.Coin,
+		Amount:    p.Amount,
+		Timestamp: p.Time,
+	}
+	if ai, err := wh.mdl.GetAddresses(p.AddrID, 0, 0, true); err == nil && len(ai) > 0 {
+		ev.Account = ai[0].AccntLabel
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		Logf(ctx, logger.ERROR, "[webhook] %s", err.Error())
+		return
+	}
+	go wh.deliver(ctx, body)
+}
+
+// deliver the payload; retry with exponential backoff on failure. An
+// undelivered payload (after all retries or on shutdown) is stored in the
+// dead-letter log of the model for later delivery.
+func (wh *Webhook) deliver(ctx context.Context, body []byte) {
+	wait := time.Second
+	for try := 0; ; try++ {
+		err := wh.post(ctx, body)
+		if err == nil {
+			return
+		}
+		if try >= wh.cfg.Retries {
+			Logf(ctx, logger.ERROR, "[webhook] delivery failed: %s", err.Error())
+			wh.deadLetter(ctx, body, try+1, err)
+			return
+		}
+		Logf(ctx, logger.WARN, "[webhook] delivery failed (retry in %s): %s", wait, err.Error())
+		select {
+		case <-ctx.Done():
+			wh.deadLetter(ctx, body, try+1, err)
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// store undelivered payload in the dead-letter log
+func (wh *Webhook) deadLetter(ctx context.Context, body []byte, tries int, cause error) {
+	if err := wh.mdl.AddFailedEvent(body, tries, cause); err != nil {
+		Logf(ctx, logger.ERROR, "[webhook] can't store undelivered event: %s", err.Error())
+		return
+	}
+	Logf(ctx, logger.INFO, "[webhook] undelivered event stored in dead-letter log")
+}
+
+// Redeliver tries to deliver an undelivered event from the dead-letter log
+// (once). The event is removed from the log on success.
+func (wh *Webhook) Redeliver(ctx context.Context, ev *FailedEvent) error {
+	err := wh.post(ctx, []byte(ev.Body))
+	if rerr := wh.mdl.RetriedEvent(ev.ID, err); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// post the payload to the webhook URL
+func (wh *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(wh.cfg.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(wh.cfg.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Relay-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
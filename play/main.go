@@ -69,6 +69,10 @@ func main() {
 		logger.Println(logger.ERROR, err.Error())
 		return
 	}
+	if err = cfg.Validate(); err != nil {
+		logger.Println(logger.ERROR, "Invalid configuration:\n"+err.Error())
+		return
+	}
 
 	// connect to model
 	logger.Println(logger.INFO, "Connecting to model...")
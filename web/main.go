@@ -40,6 +40,7 @@ var (
 	cfg     *lib.Config = nil
 	coins   []string
 	Version string = "v0.0.0"
+	started        = time.Now()
 )
 
 // Application entry point
@@ -64,6 +65,14 @@ func main() {
 		logger.Println(logger.ERROR, err.Error())
 		return
 	}
+	// override settings from environment
+	for _, name := range cfg.ApplyEnv() {
+		logger.Println(logger.INFO, "   Using setting from "+name)
+	}
+	if err = cfg.Validate(); err != nil {
+		logger.Println(logger.ERROR, "Invalid configuration:\n"+err.Error())
+		return
+	}
 	// setup logging
 	if len(cfg.Service.LogFile) > 0 {
 		lfName := fmt.Sprintf(cfg.Service.LogFile, "web")
@@ -86,14 +95,22 @@ func main() {
 		return
 	}
 	logger.Println(logger.INFO, "   Added coins: "+strings.Join(coins, ","))
+	initRampUps()
 	logger.Println(logger.INFO, "Done.")
 
 	// Prepare context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// setting up balancer service
-	balanceCh := lib.StartBalancer(ctx, mdl)
+	// export traces (if configured)
+	lib.StartTracing(ctx, cfg.Tracing)
+
+	// setting up balancer service (with webhook notification)
+	var onPayment lib.PaymentHandler
+	if wh := cfg.Service.Webhook; wh != nil {
+		onPayment = lib.NewWebhook(wh, mdl)
+	}
+	balanceCh := lib.StartBalancer(ctx, mdl, onPayment)
 
 	// setting up webservice
 	srvQuit := runService(cfg.Service)
@@ -106,6 +123,15 @@ func main() {
 	tick := time.NewTicker(time.Duration(cfg.Service.Epoch) * time.Second)
 	epoch := 0
 
+	// market updates (on their own schedule if an interval is defined;
+	// otherwise handled in periodic tasks)
+	var marketCh <-chan time.Time
+	if interval := cfg.Handler.Market.Interval; interval > 0 {
+		marketTick := time.NewTicker(time.Duration(interval) * time.Second)
+		defer marketTick.Stop()
+		marketCh = marketTick.C
+	}
+
 loop:
 	for {
 		select {
@@ -116,7 +142,8 @@ loop:
 				logger.Printf(logger.INFO, "Terminating service (on signal '%s')\n", sig)
 				break loop
 			case syscall.SIGHUP:
-				logger.Println(logger.INFO, "SIGHUP")
+				logger.Println(logger.INFO, "SIGHUP: accepting next market rates unchecked")
+				lib.ForceRates()
 			case syscall.SIGURG:
 				// TODO: https://github.com/golang/go/issues/37942
 			default:
@@ -127,6 +154,10 @@ loop:
 			epoch++
 			logger.Printf(logger.INFO, "Epoch #%d at %s", epoch, now.String())
 			go periodicTasks(ctx, epoch, balanceCh)
+
+		// handle market updates
+		case <-marketCh:
+			go updateMarket(ctx)
 		}
 	}
 
@@ -23,6 +23,7 @@ package main
 import (
 	"context"
 	"relay/lib"
+	"time"
 
 	"github.com/bfix/gospel/logger"
 )
@@ -30,25 +31,14 @@ import (
 // Periodic tasks for service/data maintenance
 func periodicTasks(ctx context.Context, epoch int, balancer chan int64) {
 
-	// check expired transactions
-	txList, err := mdl.GetExpiredTransactions()
+	// close expired transactions
+	n, left, addrIds, err := mdl.CloseExpiredTransactions()
 	if err != nil {
-		logger.Println(logger.ERROR, "[periodic] GetExpiredTxs: "+err.Error())
-	} else if len(txList) > 0 {
-		logger.Println(logger.INFO, "[periodic] Closing expired transactions...")
-		// build unique list of addresses from expired transaction
-		list := make(map[int64]bool)
-		for txID, addrID := range txList {
-			logger.Printf(logger.INFO, "[periodic] Closing transaction #%d", txID)
-			if err = mdl.CloseTransaction(txID); err != nil {
-				logger.Println(logger.ERROR, "[periodic] CloseTx: "+err.Error())
-				continue
-			}
-			list[addrID] = true
-		}
-		addrIds := make([]int64, 0)
-		for addrID := range list {
-			addrIds = append(addrIds, addrID)
+		logger.Println(logger.ERROR, "[periodic] CloseExpiredTxs: "+err.Error())
+	} else if n > 0 {
+		logger.Printf(logger.INFO, "[periodic] Closed %d expired transactions", n)
+		if left > 0 {
+			logger.Printf(logger.INFO, "[periodic] => %d expired transactions left for next epochs", left)
 		}
 		logger.Printf(logger.DBG, "[periodic] => %d addresses effected", len(addrIds))
 		// check balance of all effected addresses
@@ -58,19 +48,30 @@ func periodicTasks(ctx context.Context, epoch int, balancer chan int64) {
 			}
 		}()
 	}
-	// update market data
-	if epoch%cfg.Handler.Market.Rescan == 1 {
-		// get new exchange rates
-		logger.Println(logger.INFO, "[periodic] Get market data...")
-		if _, err := lib.GetMarketData(ctx, mdl, cfg.Handler.Market.Fiat, -1, coins); err != nil {
-			logger.Println(logger.ERROR, "[periodic] GetMarketData: "+err.Error())
+	// watch addresses of expired transactions in grace period
+	if txList, err := mdl.GetLateTransactions(); err != nil {
+		logger.Println(logger.ERROR, "[periodic] GetLateTxs: "+err.Error())
+	} else if len(txList) > 0 {
+		list := make(map[int64]bool)
+		for _, addrID := range txList {
+			list[addrID] = true
 		}
+		logger.Printf(logger.DBG, "[periodic] Checking %d addresses in grace period", len(list))
+		go func() {
+			for id := range list {
+				balancer <- id
+			}
+		}()
+	}
+	// update market data (if not running on its own schedule)
+	if cfg.Handler.Market.Interval <= 0 && epoch%cfg.Handler.Market.Rescan == 1 {
+		updateMarket(ctx)
 	}
 	// check balances of addresses that need a rescan (balance sync)
-	addrIds, err := mdl.PendingAddresses()
+	pending, err := mdl.PendingAddresses()
 	if err != nil {
 		logger.Println(logger.ERROR, "[periodic] rescan: "+err.Error())
-	} else if len(addrIds) > 0 {
+	} else if addrIds := rampedUp(pending); len(addrIds) > 0 {
 		logger.Printf(logger.INFO, "[periodic] Update %d pending address balances...", len(addrIds))
 		// check balance of all effected addresses
 		go func() {
@@ -79,8 +80,101 @@ func periodicTasks(ctx context.Context, epoch int, balancer chan int64) {
 			}
 		}()
 	}
+	// backup database (SQLite3 only)
+	if b := cfg.Model.Backup; b != nil && cfg.Model.DbEngine == "sqlite3" && epoch%max(b.Rate, 1) == 0 {
+		if file, err := mdl.Backup(b.Dir, b.Keep); err != nil {
+			logger.Println(logger.ERROR, "[periodic] backup: "+err.Error())
+		} else {
+			logger.Printf(logger.INFO, "[periodic] Database backup written to '%s'", file)
+		}
+	}
+	// downsample old daily rates
+	if r := cfg.Model.Rates; r != nil && r.Keep > 0 && r.Rate > 0 && epoch%r.Rate == 0 {
+		if n, err := mdl.PruneRates(r.Keep); err != nil {
+			logger.Println(logger.ERROR, "[periodic] PruneRates: "+err.Error())
+		} else if n > 0 {
+			logger.Printf(logger.INFO, "[periodic] Downsampled %d daily rates", n)
+		}
+	}
+	// apply changed order of blockchain handlers (management GUI)
+	if err = lib.ApplyChainOrders(mdl); err != nil {
+		logger.Println(logger.ERROR, "[periodic] ApplyChainOrders: "+err.Error())
+	}
+	// save handler statistics (for display in management GUI)
+	if err = mdl.SaveHandlerStats(lib.GetHandlerStats()); err != nil {
+		logger.Println(logger.ERROR, "[periodic] SaveHandlerStats: "+err.Error())
+	}
+	// write status snapshot
+	if len(cfg.Service.Snapshot) > 0 && epoch%max(cfg.Service.SnapRate, 1) == 0 {
+		if err = writeSnapshot(cfg.Service.Snapshot); err != nil {
+			logger.Println(logger.ERROR, "[periodic] snapshot: "+err.Error())
+		}
+	}
 	// check for log rotation
 	if epoch%cfg.Service.LogRotate == 0 {
 		logger.Rotate()
 	}
 }
+
+// coin ramp-up (limited balance checks for newly added coins)
+type rampUp struct {
+	until int64 // end of ramp-up phase
+	limit int   // max. balance checks per epoch
+}
+
+// list of coins in ramp-up phase
+var rampUps = make(map[string]*rampUp)
+
+// Initialize ramp-up phases of coins (based on their onboarding time)
+func initRampUps() {
+	now := time.Now().Unix()
+	for _, coin := range cfg.Coins {
+		if coin.RampUp <= 0 || coin.RampEpochs <= 0 {
+			continue
+		}
+		ts, err := mdl.CoinOnboarded(coin.Symb)
+		if err != nil {
+			logger.Printf(logger.ERROR, "[rampup] %s: %s", coin.Symb, err.Error())
+			continue
+		}
+		until := ts + int64(coin.RampEpochs*cfg.Service.Epoch)
+		if until > now {
+			logger.Printf(logger.INFO, "[rampup] %s: max. %d checks per epoch until %s",
+				coin.Symb, coin.RampUp, time.Unix(until, 0).Format(time.RFC3339))
+			rampUps[coin.Symb] = &rampUp{until: until, limit: coin.RampUp}
+		}
+	}
+}
+
+// rampedUp returns the list of pending addresses to be checked: the
+// number of addresses for coins in their ramp-up phase is limited (the
+// remaining addresses are checked in later epochs).
+func rampedUp(pending map[string][]int64) (list []int64) {
+	now := time.Now().Unix()
+	for coin, ids := range pending {
+		if ru, ok := rampUps[coin]; ok && now < ru.until && len(ids) > ru.limit {
+			logger.Printf(logger.DBG, "[rampup] %s: deferring %d pending addresses", coin, len(ids)-ru.limit)
+			ids = ids[:ru.limit]
+		}
+		list = append(list, ids...)
+	}
+	return
+}
+
+// Update market data (exchange rates)
+func updateMarket(ctx context.Context) {
+	// get new exchange rates
+	logger.Println(logger.INFO, "[market] Get market data...")
+	if _, err := lib.GetMarketData(ctx, mdl, cfg.Handler.Market.Fiat, -1, coins); err != nil {
+		logger.Println(logger.ERROR, "[market] GetMarketData: "+err.Error())
+	}
+	// get exchange rates for accounts with their own fiat currency
+	fiats, err := mdl.GetAccountFiats(cfg.Handler.Market.Fiat)
+	if err != nil {
+		logger.Println(logger.ERROR, "[market] GetAccountFiats: "+err.Error())
+	} else if len(fiats) > 0 {
+		if err = lib.UpdateFiatRates(ctx, mdl, fiats, coins); err != nil {
+			logger.Println(logger.ERROR, "[market] UpdateFiatRates: "+err.Error())
+		}
+	}
+}
@@ -21,16 +21,24 @@
 package main
 
 import (
-	"bytes"
+	"archive/zip"
 	"context"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"regexp"
 	"relay/lib"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bfix/gospel/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	qrcode "github.com/yeqown/go-qrcode"
 )
 
@@ -43,14 +51,47 @@ func runService(cfg *lib.ServiceConfig) func(ctx context.Context) error {
 	// setup request router
 	logger.Println(logger.INFO, "Setting up web service...")
 	mux := http.NewServeMux()
-	mux.HandleFunc("/list/", listHandler)
-	mux.HandleFunc("/receive/", receiveHandler)
-	mux.HandleFunc("/status/", statusHandler)
+	admin, err := lib.NewAllowlist(cfg.AdminNets)
+	if err != nil {
+		logger.Println(logger.ERROR, "Admin access: "+err.Error())
+	}
+	// readiness probe (for monitoring)
+	mux.HandleFunc("/readyz", readyHandler)
+	// health check (for load balancers)
+	mux.HandleFunc("/health", healthHandler)
+	// metrics (for Prometheus; admin token required)
+	mux.Handle("/metrics", lib.Restricted(admin, adminOnly(cfg, promhttp.Handler().ServeHTTP)))
+
+	// legacy API and API version 2 (see v2.go)
+	for _, prefix := range []string{"", "/v2"} {
+		mux.HandleFunc(prefix+"/list/", permitted(cfg, listHandler(cfg.MaxList)))
+		mux.HandleFunc(prefix+"/receive/", permitted(cfg, receiveHandler))
+		mux.HandleFunc(prefix+"/status/", statusHandler)
+		mux.HandleFunc(prefix+"/qr/", qrHandler(cfg.QRFormat))
+		mux.HandleFunc(prefix+"/api/total/", authenticated(cfg, totalHandler))
+		mux.HandleFunc(prefix+"/api/transactions/", authenticated(cfg, transactionsHandler))
+		mux.HandleFunc(prefix+"/api/tx", authenticated(cfg, txDetailHandler))
+		mux.HandleFunc(prefix+"/api/addresses/", authenticated(cfg, addressesHandler))
+		mux.HandleFunc(prefix+"/api/address/", authenticated(cfg, addressHandler))
+		mux.HandleFunc(prefix+"/api/accounts/", authenticated(cfg, accountsHandler))
+		mux.HandleFunc(prefix+"/api/handlers/", authenticated(cfg, handlersHandler))
+		mux.HandleFunc(prefix+"/api/revenue/", authenticated(cfg, revenueHandler))
+		mux.HandleFunc(prefix+"/incoming/", authenticated(cfg, incomingHandler))
+		mux.Handle(prefix+"/admin/account", lib.Restricted(admin, adminOnly(cfg, newAccountHandler(cfg.NewCoins))))
+		mux.Handle(prefix+"/admin/chains", lib.Restricted(admin, adminOnly(cfg, chainsHandler)))
+		mux.Handle(prefix+"/admin/balance", lib.Restricted(admin, adminOnly(cfg, balanceHandler)))
+	}
 
-	// assemble HTTP server
+	// assemble HTTP server (with request ids for log correlation and
+	// optional compression of responses)
+	var hdlr http.Handler = mux
+	if cfg.Compress {
+		hdlr = lib.Compressed(mux)
+	}
+	hdlr = lib.RequestIDs(hdlr)
 	logger.Printf(logger.INFO, "Service listening at %s", cfg.Listen)
 	srv := &http.Server{
-		Handler:      mux,
+		Handler:      hdlr,
 		Addr:         cfg.Listen,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
@@ -66,33 +107,59 @@ func runService(cfg *lib.ServiceConfig) func(ctx context.Context) error {
 }
 
 //----------------------------------------------------------------------
-// ListHandler returns a list of coins accepted for a given account.
-// Returns an empty list if no valid account is specified.
+// ListHandler returns a list of coins accepted for a given account (in
+// the order of coins in the configuration). Returns an empty list if no
+// valid account is specified. If the list is limited to a maximum number
+// of coins, a truncated list is flagged with a 'X-Truncated' header.
 //----------------------------------------------------------------------
 
-func listHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+func listHandler(maxList int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	accnt := r.FormValue("a")
-	if len(accnt) == 0 {
-		logger.Println(logger.INFO, "List[0]: no account")
-		io.WriteString(w, "[]")
-		return
-	}
-	list, err := mdl.GetCoins(accnt)
-	if err != nil {
-		logger.Println(logger.ERROR, "List[1]: "+err.Error())
-		io.WriteString(w, "[]")
-		return
+		accnt := r.FormValue("a")
+		if len(accnt) == 0 {
+			lib.Logf(r.Context(), logger.INFO, "List[0]: no account")
+			io.WriteString(w, "[]")
+			return
+		}
+		list, err := mdl.GetCoins(accnt)
+		if err != nil {
+			lib.Logf(r.Context(), logger.ERROR, "List[1]: %s", err.Error())
+			io.WriteString(w, "[]")
+			return
+		}
+		// only list coins permitted for API key
+		if key, ok := r.Context().Value(ctxApiKey).(*lib.ApiKey); ok {
+			list = slices.DeleteFunc(list, func(ci *lib.CoinInfo) bool {
+				return !key.Allows("", ci.Symbol)
+			})
+		}
+		// sort coins (configuration order) and limit list
+		slices.SortStableFunc(list, func(a, b *lib.CoinInfo) int {
+			return coinOrder(a.Symbol) - coinOrder(b.Symbol)
+		})
+		if maxList > 0 && len(list) > maxList {
+			lib.Logf(r.Context(), logger.WARN, "List[2]: %d coins for '%s' truncated", len(list), accnt)
+			list = list[:maxList]
+			w.Header().Set("X-Truncated", "true")
+		}
+		body, err := marshal(r, list)
+		if err != nil {
+			lib.Logf(r.Context(), logger.ERROR, "List[3]: %s", err.Error())
+			io.WriteString(w, "[]")
+			return
+		}
+		w.Write(body)
 	}
-	body, err := json.Marshal(list)
-	if err != nil {
-		logger.Println(logger.ERROR, "List[2]: "+err.Error())
-		io.WriteString(w, "[]")
-		return
+}
+
+// return position of coin in configuration (unknown coins last)
+func coinOrder(symb string) int {
+	if i := slices.Index(coins, symb); i >= 0 {
+		return i
 	}
-	w.Write(body)
+	return len(coins)
 }
 
 //----------------------------------------------------------------------
@@ -105,6 +172,11 @@ type txResponse struct {
 	Tx    *lib.Transaction `json:"tx"`
 	Qr    string           `json:"qr"`
 	Coin  *lib.CoinInfo    `json:"coin"`
+
+	// funds received during transaction (status only)
+	Received *float64 `json:"received,omitempty"`
+	// unconfirmed funds on address (status only)
+	Unconfirmed *float64 `json:"unconfirmed,omitempty"`
 }
 
 func receiveHandler(w http.ResponseWriter, r *http.Request) {
@@ -114,31 +186,34 @@ func receiveHandler(w http.ResponseWriter, r *http.Request) {
 	// create response and send it on exit
 	resp := new(txResponse)
 	defer func() {
-		buf, _ := json.Marshal(resp)
+		buf, _ := marshal(r, resp)
 		w.Write(buf)
 	}()
 
 	// get address for given account and coin
 	accnt := r.FormValue("a")
-	coin := r.FormValue("c")
-	tx, err := mdl.NewTransaction(coin, accnt)
+	coin := lib.CoinSymbol(r.FormValue("c"))
+	withMemo := false
+	if hdlr, ok := lib.HdlrList[coin]; ok {
+		withMemo = hdlr.RequiresMemo()
+	}
+	refund := ""
+	if cfg.Service.Refund {
+		refund = strings.TrimSpace(r.FormValue("refund"))
+	}
+	tx, err := mdl.NewTransaction(r.Context(), coin, accnt, withMemo, refund)
 	if err != nil {
-		logger.Printf(logger.ERROR, "receive: account=%s, coin=%s failed: %s\n", accnt, coin, err.Error())
+		lib.Logf(r.Context(), logger.ERROR, "receive: account=%s, coin=%s failed: %s\n", accnt, coin, err.Error())
 		resp.Error = err.Error()
 		return
 	}
-	logger.Printf(logger.INFO, "receive: account=%s, coin=%s => %s\n", accnt, coin, tx.Addr)
+	lib.Logf(r.Context(), logger.INFO, "receive: account=%s, coin=%s => %s\n", accnt, coin, tx.Addr)
+	lib.CountTransaction(coin)
+	// display address in requested format
+	tx.Addr = lib.DisplayAddress(coin, tx.Addr, r.FormValue("af"))
 
 	// generate QR code of address
-	qr := "data:image/jpeg;base64,"
-	qrc, err := qrcode.New(tx.Addr)
-	if err == nil {
-		buf := new(bytes.Buffer)
-		qrc.SaveTo(buf)
-		qr += base64.StdEncoding.EncodeToString(buf.Bytes())
-	} else {
-		qr = ""
-	}
+	qr := qrDataURL(paymentURI(tx), cfg.Service.QRFormat)
 	// get coin info
 	ci, err := mdl.GetCoin(coin)
 	if err != nil {
@@ -162,29 +237,25 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	// create response and send it on exit
 	resp := new(txResponse)
 	defer func() {
-		buf, _ := json.Marshal(resp)
+		buf, _ := marshal(r, resp)
 		w.Write(buf)
 	}()
 
 	// get transaction
 	var err error
 	tx := r.FormValue("t")
-	logger.Printf(logger.DBG, "status: tx=%s\n", tx)
+	lib.Logf(r.Context(), logger.DBG, "status: tx=%s\n", tx)
 
 	if resp.Tx, err = mdl.GetTransaction(tx); err != nil {
 		resp.Error = err.Error()
 		return
 	}
+	// display address in requested format
+	addr := resp.Tx.Addr
+	resp.Tx.Addr = lib.DisplayAddress(resp.Tx.Coin, addr, r.FormValue("af"))
+
 	// generate QR code of address
-	qr := "data:image/jpeg;base64,"
-	qrc, err := qrcode.New(resp.Tx.Addr)
-	if err == nil {
-		buf := new(bytes.Buffer)
-		qrc.SaveTo(buf)
-		qr += base64.StdEncoding.EncodeToString(buf.Bytes())
-	} else {
-		qr = ""
-	}
+	qr := qrDataURL(paymentURI(resp.Tx), cfg.Service.QRFormat)
 	// get coin info
 	ci, err := mdl.GetCoin(resp.Tx.Coin)
 	if err != nil {
@@ -194,4 +265,847 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	// assemble response
 	resp.Qr = qr
 	resp.Coin = ci
+
+	// get funds received during transaction
+	if cfg.Service.TxReceived {
+		var amount float64
+		if amount, err = mdl.GetTxReceived(tx); err != nil {
+			resp.Error = err.Error()
+			return
+		}
+		resp.Received = &amount
+	}
+	// get unconfirmed funds on address (if available)
+	if hdlr, ok := lib.HdlrList[resp.Tx.Coin]; ok && hdlr.HasUnconfirmed() {
+		amount, err := hdlr.GetUnconfirmed(r.Context(), addr)
+		if err != nil {
+			lib.Logf(r.Context(), logger.DBG, "status: unconfirmed: %s\n", err.Error())
+			return
+		}
+		resp.Unconfirmed = &amount
+	}
+}
+
+//----------------------------------------------------------------------
+// QrHandler returns the QR code for a given transaction ('tx') as an image
+// (PNG or JPEG as configured). The QR code of a transaction never changes,
+// so clients can cache the image. If more than one transaction is given
+// (repeated 'tx'), the QR codes are streamed as a ZIP archive.
+//----------------------------------------------------------------------
+
+func qrHandler(format string) http.HandlerFunc {
+	mime, opt := qrImage(format)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get transaction(s)
+		r.ParseForm()
+		txids := r.Form["tx"]
+		if len(txids) > 1 {
+			qrBatch(w, r, txids, format)
+			return
+		}
+		txid := r.FormValue("tx")
+		tx, err := mdl.GetTransaction(txid)
+		if err != nil {
+			lib.Logf(r.Context(), logger.DBG, "qr: tx=%s: %s\n", txid, err.Error())
+			http.NotFound(w, r)
+			return
+		}
+		// check client cache
+		etag := `"` + tx.ID + `"`
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		// send QR code image
+		w.Header().Set("Content-Type", mime)
+		w.WriteHeader(http.StatusOK)
+		if err = writeQR(w, paymentURI(tx), opt); err != nil {
+			lib.Logf(r.Context(), logger.ERROR, "QR code: %s", err.Error())
+		}
+	}
+}
+
+// maximum number of QR codes in a batch
+const maxQRBatch = 100
+
+// send the QR codes of a batch of transactions as a ZIP archive (one image
+// per transaction). All transactions must exist.
+func qrBatch(w http.ResponseWriter, r *http.Request, txids []string, format string) {
+	if len(txids) > maxQRBatch {
+		http.Error(w, "too many transactions", http.StatusBadRequest)
+		return
+	}
+	texts := make([]string, len(txids))
+	for i, txid := range txids {
+		tx, err := mdl.GetTransaction(txid)
+		if err != nil {
+			lib.Logf(r.Context(), logger.DBG, "qr: tx=%s: %s\n", txid, err.Error())
+			http.NotFound(w, r)
+			return
+		}
+		texts[i] = paymentURI(tx)
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	if err := writeQRArchive(w, txids, texts, format); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "QR archive: %s", err.Error())
+	}
+}
+
+//----------------------------------------------------------------------
+// TotalHandler returns the total funds received by an account (in fiat
+// currency) with a breakdown per coin. Authenticated API call.
+//----------------------------------------------------------------------
+
+type totalResponse struct {
+	Error string          `json:"error,omitempty"`
+	Total *lib.AccntTotal `json:"total"`
+}
+
+func totalHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(totalResponse)
+	defer func() {
+		buf, _ := json.Marshal(resp)
+		w.Write(buf)
+	}()
+
+	// get account and fiat currency
+	accnt := r.FormValue("a")
+	fiat := strings.ToUpper(r.FormValue("f"))
+	if len(fiat) == 0 {
+		fiat = cfg.Handler.Market.Fiat
+	}
+	id, err := mdl.GetAccountID(accnt)
+	if err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "total: account=%s failed: %s\n", accnt, err.Error())
+		resp.Error = "unknown account"
+		return
+	}
+	if resp.Total, err = mdl.GetAccountTotal(id, fiat); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "total: account=%s failed: %s\n", accnt, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+//----------------------------------------------------------------------
+// TransactionsHandler returns a paginated list of transactions for an
+// account (and coin). Authenticated API call.
+//----------------------------------------------------------------------
+
+type transactionsResponse struct {
+	Error  string             `json:"error,omitempty"`
+	Total  int                `json:"total"`
+	Offset int                `json:"offset"`
+	Txs    []*lib.Transaction `json:"txs"`
+}
+
+func transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(transactionsResponse)
+	defer func() {
+		buf, _ := marshal(r, resp)
+		w.Write(buf)
+	}()
+
+	// get account and coin (optional)
+	accnt := r.FormValue("a")
+	accntID, err := mdl.GetAccountID(accnt)
+	if err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "transactions: account=%s failed: %s\n", accnt, err.Error())
+		resp.Error = "unknown account"
+		return
+	}
+	var coinID int64
+	if coin := r.FormValue("c"); len(coin) > 0 {
+		ci, err := mdl.GetCoin(coin)
+		if err != nil {
+			resp.Error = "unknown coin"
+			return
+		}
+		coinID = ci.ID
+	}
+	// get pagination parameters
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if resp.Offset, err = strconv.Atoi(r.FormValue("offset")); err != nil || resp.Offset < 0 {
+		resp.Offset = 0
+	}
+	// get transactions
+	if resp.Txs, resp.Total, err = mdl.GetTransactions(0, accntID, coinID, limit, resp.Offset); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "transactions: account=%s failed: %s\n", accnt, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+//----------------------------------------------------------------------
+// TxDetailHandler returns all information about a transaction ('id'):
+// status, address, funds received within its validity window and the
+// coin rates at creation and now. Authenticated API call.
+//----------------------------------------------------------------------
+
+type txDetailResponse struct {
+	Error  string        `json:"error,omitempty"`
+	Detail *lib.TxDetail `json:"detail"`
+}
+
+func txDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(txDetailResponse)
+	defer func() {
+		buf, _ := marshal(r, resp)
+		w.Write(buf)
+	}()
+
+	// get transaction details
+	var err error
+	txid := r.FormValue("id")
+	if resp.Detail, err = mdl.GetTransactionDetail(txid, cfg.Handler.Market.Fiat); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "tx: id=%s failed: %s\n", txid, err.Error())
+		resp.Error = "unknown transaction"
+	}
+}
+
+//----------------------------------------------------------------------
+// AddressesHandler returns the addresses of an account (and coin). Removed
+// addresses are only listed if 'all' is set. Authenticated API call.
+//----------------------------------------------------------------------
+
+type addressesResponse struct {
+	Error string          `json:"error,omitempty"`
+	Addrs []*lib.AddrInfo `json:"addresses"`
+}
+
+func addressesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(addressesResponse)
+	defer func() {
+		buf, _ := marshal(r, resp)
+		w.Write(buf)
+	}()
+
+	// get account and coin (optional)
+	accnt := r.FormValue("a")
+	accntID, err := mdl.GetAccountID(accnt)
+	if err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "addresses: account=%s failed: %s\n", accnt, err.Error())
+		resp.Error = "unknown account"
+		return
+	}
+	var coinID int64
+	if coin := r.FormValue("c"); len(coin) > 0 {
+		ci, err := mdl.GetCoin(lib.CoinSymbol(coin))
+		if err != nil {
+			resp.Error = "unknown coin"
+			return
+		}
+		coinID = ci.ID
+	}
+	all := r.FormValue("all") == "1"
+	if resp.Addrs, err = mdl.GetAddresses(0, accntID, coinID, all); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "addresses: account=%s failed: %s\n", accnt, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+//----------------------------------------------------------------------
+// AddressHandler returns the coin, account, status and balance of all
+// address entries with a given address value ('v'). Authenticated API call.
+//----------------------------------------------------------------------
+
+func addressHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(addressesResponse)
+	defer func() {
+		buf, _ := marshal(r, resp)
+		w.Write(buf)
+	}()
+
+	// get address information
+	val := strings.TrimSpace(r.FormValue("v"))
+	if len(val) == 0 {
+		resp.Error = "missing address"
+		return
+	}
+	var err error
+	if resp.Addrs, err = mdl.GetAddressByValue(val); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "address: value=%s failed: %s\n", val, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+//----------------------------------------------------------------------
+// AccountsHandler returns a list of accounts where label or name match a
+// query string (all accounts if no query is given). Authenticated API call.
+//----------------------------------------------------------------------
+
+type accountsResponse struct {
+	Error    string           `json:"error,omitempty"`
+	Accounts []*lib.AccntInfo `json:"accounts"`
+}
+
+func accountsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(accountsResponse)
+	defer func() {
+		buf, _ := json.Marshal(resp)
+		w.Write(buf)
+	}()
+
+	// search accounts
+	var err error
+	query := r.FormValue("q")
+	if resp.Accounts, err = mdl.SearchAccounts(query); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "accounts: query=%s failed: %s\n", query, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+//----------------------------------------------------------------------
+// ReadyHandler reports if the service is ready (database available and
+// exchange rates are up-to-date). Returns status 503 if not ready.
+//----------------------------------------------------------------------
+
+type readyResponse struct {
+	Ready      bool             `json:"ready"`
+	Error      string           `json:"error,omitempty"`
+	StaleRates []*lib.StaleRate `json:"staleRates,omitempty"`
+}
+
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := new(readyResponse)
+	defer func() {
+		if !resp.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		buf, _ := json.Marshal(resp)
+		w.Write(buf)
+	}()
+	// check database
+	if _, err := mdl.GetSchemaVersion(); err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	// check age of exchange rates
+	if market := cfg.Handler.Market; market.StaleAge > 0 {
+		list, err := mdl.GetStaleRates(market.StaleAge, market.Fiat)
+		if err != nil {
+			resp.Error = err.Error()
+			return
+		}
+		if len(list) > 0 {
+			resp.StaleRates = list
+			resp.Error = "stale exchange rates"
+			return
+		}
+	}
+	resp.Ready = true
+}
+
+//----------------------------------------------------------------------
+// HealthHandler is a liveness/readiness check for load balancers: the
+// service is healthy if the database is reachable and coin handlers are
+// registered (HTTP 200), otherwise HTTP 503 is returned.
+//----------------------------------------------------------------------
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	DB      string `json:"db"`
+	Coins   int    `json:"coins"`
+	Uptime  int64  `json:"uptime"`
+	Version string `json:"version"`
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := &healthResponse{
+		Status:  "ok",
+		DB:      "ok",
+		Coins:   len(lib.HdlrList),
+		Uptime:  int64(time.Since(started).Seconds()),
+		Version: Version,
+	}
+	if err := mdl.Ping(); err != nil {
+		lib.Logf(r.Context(), logger.WARN, "health: database: %s", err.Error())
+		resp.DB = "error"
+		resp.Status = "error"
+	}
+	if resp.Coins == 0 {
+		resp.Status = "error"
+	}
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	buf, _ := json.Marshal(resp)
+	w.Write(buf)
+}
+
+//----------------------------------------------------------------------
+// HandlersHandler returns the request statistics of blockchain and market
+// handlers. Authenticated API call.
+//----------------------------------------------------------------------
+
+func handlersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	buf, _ := json.Marshal(lib.GetHandlerStats())
+	w.Write(buf)
+}
+
+//----------------------------------------------------------------------
+// BalanceHandler sets the balance of an address (operator override; no
+// incoming funds are recorded). Admin call (POST only).
+//----------------------------------------------------------------------
+
+type balanceResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(balanceResponse)
+	defer func() {
+		buf, _ := json.Marshal(resp)
+		w.Write(buf)
+	}()
+
+	// get new balance
+	balance, err := strconv.ParseFloat(r.FormValue("balance"), 64)
+	if err != nil {
+		resp.Error = "invalid balance"
+		return
+	}
+	// get address entry (by id or by address and coin)
+	var addrID int64
+	if id := r.FormValue("id"); len(id) > 0 {
+		if addrID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			resp.Error = "invalid id"
+			return
+		}
+	} else if addrID, resp.Error = addressID(r.FormValue("addr"), r.FormValue("coin")); len(resp.Error) > 0 {
+		return
+	}
+	// set balance
+	if err = mdl.SetBalance(addrID, balance); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			resp.Error = "unknown address"
+			return
+		}
+		lib.Logf(r.Context(), logger.ERROR, "balance: addr=#%d failed: %s\n", addrID, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+// get the id of the address entry for an address of a coin (an address
+// can be used by more than one coin). Returns an error message if no
+// unique entry is found.
+func addressID(addr, coin string) (int64, string) {
+	if len(addr) == 0 || len(coin) == 0 {
+		return 0, "missing address or coin"
+	}
+	list, err := mdl.GetAddressByValue(addr)
+	if err != nil {
+		return 0, err.Error()
+	}
+	coin = lib.CoinSymbol(coin)
+	list = slices.DeleteFunc(list, func(ai *lib.AddrInfo) bool {
+		return ai.CoinSymb != coin
+	})
+	switch len(list) {
+	case 0:
+		return 0, "unknown address"
+	case 1:
+		return list[0].ID, ""
+	}
+	return 0, "ambiguous address"
+}
+
+//----------------------------------------------------------------------
+// NewAccountHandler creates a new account (POST with 'label', 'name' and
+// optional 'fiat') and assigns coins to it: either the coins listed in
+// the optional 'coins' parameter (comma-separated symbols) or the default
+// coins for new accounts from the configuration. Admin API call.
+//----------------------------------------------------------------------
+
+type newAccountResponse struct {
+	Error string   `json:"error,omitempty"`
+	ID    int64    `json:"id"`
+	Coins []string `json:"coins"`
+}
+
+var (
+	accntLabel = regexp.MustCompile("^[A-Za-z0-9_]{1,7}$")
+	accntFiat  = regexp.MustCompile("^[A-Za-z]{3,7}$")
+)
+
+func newAccountHandler(defCoins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		// create response and send it on exit
+		resp := new(newAccountResponse)
+		defer func() {
+			buf, _ := json.Marshal(resp)
+			w.Write(buf)
+		}()
+
+		// check parameters
+		label := r.FormValue("label")
+		if !accntLabel.MatchString(label) {
+			resp.Error = "invalid label"
+			return
+		}
+		name := r.FormValue("name")
+		if len(name) == 0 || len(name) > 127 {
+			resp.Error = "invalid name"
+			return
+		}
+		fiat := r.FormValue("fiat")
+		if len(fiat) > 0 && !accntFiat.MatchString(fiat) {
+			resp.Error = "invalid fiat currency"
+			return
+		}
+		coins := defCoins
+		if list := r.FormValue("coins"); len(list) > 0 {
+			coins = strings.Split(list, ",")
+		}
+		coinIDs := make([]int64, 0, len(coins))
+		for _, coin := range coins {
+			ci, err := mdl.GetCoin(strings.TrimSpace(coin))
+			if err != nil {
+				resp.Error = "unknown coin '" + coin + "'"
+				return
+			}
+			if slices.Contains(coinIDs, ci.ID) {
+				continue
+			}
+			coinIDs = append(coinIDs, ci.ID)
+			resp.Coins = append(resp.Coins, ci.Symbol)
+		}
+		// create account with coin assignments
+		id, err := mdl.NewAccount(label, name, fiat, coinIDs)
+		if err != nil {
+			lib.Logf(r.Context(), logger.ERROR, "newAccount: label=%s failed: %s\n", label, err.Error())
+			resp.Coins = nil
+			resp.Error = err.Error()
+			return
+		}
+		resp.ID = id
+		lib.Logf(r.Context(), logger.INFO, "API: new account '%s' (#%d)", label, resp.ID)
+	}
+}
+
+//----------------------------------------------------------------------
+// ChainsHandler returns ('GET') or sets ('POST' with comma-separated
+// names in 'order') the order of blockchain handlers used for a coin
+// ('c'). An empty order resets the coin to the configured order. The new
+// order is stored in the database and used immediately. Admin API call.
+//----------------------------------------------------------------------
+
+type chainsResponse struct {
+	Error     string   `json:"error,omitempty"`
+	Coin      string   `json:"coin"`
+	Order     []string `json:"order"`     // blockchain handlers in use (in order)
+	Available []string `json:"available"` // configured blockchain handlers
+}
+
+func chainsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(chainsResponse)
+	defer func() {
+		buf, _ := json.Marshal(resp)
+		w.Write(buf)
+	}()
+
+	// get coin handler
+	resp.Coin = lib.CoinSymbol(r.FormValue("c"))
+	hdlr, ok := lib.HdlrList[resp.Coin]
+	if !ok {
+		resp.Error = "unknown coin '" + resp.Coin + "'"
+		return
+	}
+	// set new order
+	if r.Method == http.MethodPost {
+		var order []string
+		if list := r.FormValue("order"); len(list) > 0 {
+			for _, name := range strings.Split(list, ",") {
+				order = append(order, strings.TrimSpace(name))
+			}
+		}
+		stored := order
+		if len(order) == 0 {
+			order = hdlr.Config().ChainHandlers()
+		}
+		if err := hdlr.SetChainOrder(order); err != nil {
+			resp.Error = err.Error()
+		} else if err = mdl.SetChainOrder(resp.Coin, stored); err != nil {
+			lib.Logf(r.Context(), logger.ERROR, "chains: coin=%s failed: %s\n", resp.Coin, err.Error())
+			resp.Error = err.Error()
+		}
+	}
+	resp.Order = hdlr.ChainOrder()
+	resp.Available = hdlr.Config().ChainHandlers()
+}
+
+//----------------------------------------------------------------------
+// RevenueHandler returns the fiat value of incoming funds per day or week
+// ('b'; ISO weeks) in a time range ('from', 'to' as Unix timestamps;
+// defaults to the last 30 days). Funds without rate in the fiat currency
+// ('f') are counted per period ('noRate'). Authenticated API call.
+//----------------------------------------------------------------------
+
+type revenueResponse struct {
+	Error   string         `json:"error,omitempty"`
+	Fiat    string         `json:"fiat"`
+	Revenue []*lib.Revenue `json:"revenue"`
+}
+
+func revenueHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// create response and send it on exit
+	resp := new(revenueResponse)
+	defer func() {
+		buf, _ := json.Marshal(resp)
+		w.Write(buf)
+	}()
+
+	// get parameters
+	resp.Fiat = strings.ToUpper(r.FormValue("f"))
+	if len(resp.Fiat) == 0 {
+		resp.Fiat = cfg.Handler.Market.Fiat
+	}
+	bucket := r.FormValue("b")
+	if len(bucket) == 0 {
+		bucket = "day"
+	}
+	to, err := strconv.ParseInt(r.FormValue("to"), 10, 64)
+	if err != nil || to <= 0 {
+		to = time.Now().Unix()
+	}
+	from, err := strconv.ParseInt(r.FormValue("from"), 10, 64)
+	if err != nil || from <= 0 {
+		from = to - 30*24*3600
+	}
+	// get revenue per period (current coin rates are in the default fiat
+	// currency)
+	current := resp.Fiat == strings.ToUpper(cfg.Handler.Market.Fiat)
+	if resp.Revenue, err = mdl.IncomingByPeriod(from, to, bucket, resp.Fiat, current); err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "revenue: bucket=%s failed: %s\n", bucket, err.Error())
+		resp.Error = err.Error()
+	}
+}
+
+//----------------------------------------------------------------------
+// IncomingHandler returns the list of recent incoming funds ('n' entries;
+// default 25, max. 200). Authenticated API call.
+//----------------------------------------------------------------------
+
+func incomingHandler(w http.ResponseWriter, r *http.Request) {
+	// get number of entries
+	n, err := strconv.Atoi(r.FormValue("n"))
+	if err != nil || n <= 0 {
+		n = 25
+	}
+	n = min(n, 200)
+
+	// get list of incoming funds
+	list, err := mdl.ListIncoming(n)
+	if err != nil {
+		lib.Logf(r.Context(), logger.ERROR, "incoming: n=%d failed: %s\n", n, err.Error())
+		http.Error(w, "failed to list incoming funds", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = make([]*lib.Incoming, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	buf, _ := json.Marshal(list)
+	w.Write(buf)
+}
+
+//----------------------------------------------------------------------
+// Authentication for API calls
+//----------------------------------------------------------------------
+
+// authenticated wraps a handler for API calls that require a valid access
+// token ("Authorization: Bearer <token>"). If no token is configured, all
+// authenticated API calls are rejected.
+func authenticated(cfg *lib.ServiceConfig, hdlr http.HandlerFunc) http.HandlerFunc {
+	return withToken(cfg.ApiToken, hdlr)
+}
+
+// adminOnly wraps a handler for admin calls that require the admin token.
+// If no admin token is configured, all admin calls are rejected.
+func adminOnly(cfg *lib.ServiceConfig, hdlr http.HandlerFunc) http.HandlerFunc {
+	return withToken(cfg.AdminToken, hdlr)
+}
+
+// context key for API key of client call
+type ctxKey int
+
+const ctxApiKey ctxKey = iota
+
+// permitted wraps a handler for client calls (list, receive). If API keys
+// are configured, the request must carry a valid key ("Authorization:
+// Bearer <key>") that permits the requested account ('a') and coin ('c');
+// the key is passed to the handler in the request context. Without
+// configured API keys, all client calls are allowed.
+func permitted(cfg *lib.ServiceConfig, hdlr http.HandlerFunc) http.HandlerFunc {
+	if len(cfg.ApiKeys) == 0 {
+		return hdlr
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var key *lib.ApiKey
+		if ok {
+			for _, k := range cfg.ApiKeys {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+					key = k
+					break
+				}
+			}
+		}
+		if key == nil {
+			lib.Logf(r.Context(), logger.WARN, "API: unauthorized access to '%s'", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !key.Allows(r.FormValue("a"), lib.CoinSymbol(r.FormValue("c"))) {
+			lib.Logf(r.Context(), logger.WARN, "API: forbidden access to '%s' (a=%s, c=%s)",
+				r.URL.Path, r.FormValue("a"), r.FormValue("c"))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		hdlr(w, r.WithContext(context.WithValue(r.Context(), ctxApiKey, key)))
+	}
+}
+
+// withToken wraps a handler that requires a bearer token
+func withToken(required string, hdlr http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(required) == 0 ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(required)) != 1 {
+			lib.Logf(r.Context(), logger.WARN, "API: unauthorized access to '%s'", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		hdlr(w, r)
+	}
+}
+
+//----------------------------------------------------------------------
+// QR code helpers
+//----------------------------------------------------------------------
+
+// paymentURI returns the text encoded in the QR code for a transaction:
+// the payment URI of the address (with memo/destination tag if required
+// by the coin).
+func paymentURI(tx *lib.Transaction) string {
+	return lib.PaymentURI(tx.Coin, tx.Addr, tx.Memo)
+}
+
+// qrImage returns the MIME type and the image encoder option for the
+// configured format of QR codes ("png" or "jpeg"; defaults to PNG).
+func qrImage(format string) (string, qrcode.ImageOption) {
+	if format == "jpeg" {
+		return "image/jpeg", qrcode.WithBuiltinImageEncoder(qrcode.JPEG_FORMAT)
+	}
+	return "image/png", qrcode.WithBuiltinImageEncoder(qrcode.PNG_FORMAT)
+}
+
+// writeQR renders the QR code for text as an image (JPEG unless specified
+// otherwise in the options) to the writer. The image is streamed to the
+// writer without intermediate buffering.
+func writeQR(w io.Writer, text string, opts ...qrcode.ImageOption) error {
+	qrc, err := qrcode.New(text, opts...)
+	if err != nil {
+		return err
+	}
+	return qrc.SaveTo(w)
+}
+
+// writeQRArchive streams the QR codes for a list of texts as a ZIP archive
+// to the writer (entry "<name>.<format>" for each text). Each image is
+// written directly into its archive entry, so memory usage does not depend
+// on the number of images.
+func writeQRArchive(w io.Writer, names, texts []string, format string) error {
+	mime, opt := qrImage(format)
+	ext := "." + strings.TrimPrefix(mime, "image/")
+	zw := zip.NewWriter(w)
+	for i, text := range texts {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   names[i] + ext,
+			Method: zip.Store, // images are already compressed
+		})
+		if err != nil {
+			return err
+		}
+		if err = writeQR(f, text, opt); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// qrDataURL returns the QR code for text as a data URL (base64-encoded
+// image in the configured format). Returns an empty string if the QR code
+// can't be generated.
+func qrDataURL(text, format string) string {
+	mime, opt := qrImage(format)
+	buf := new(strings.Builder)
+	buf.WriteString("data:" + mime + ";base64,")
+	enc := base64.NewEncoder(base64.StdEncoding, buf)
+	if err := writeQR(enc, text, opt); err != nil {
+		logger.Println(logger.ERROR, "QR code: "+err.Error())
+		return ""
+	}
+	enc.Close()
+	return buf.String()
 }
@@ -0,0 +1,329 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"relay/lib"
+	"strings"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin/wallet"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// BIP32 test vector 1 (master public key)
+const testXpub = "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+
+// set up the service globals with a model on a new SQLite3 database
+// (schema from the create script and additional test data) and a BTC
+// handler deriving addresses locally.
+func setupService(t *testing.T, data ...string) {
+	t.Helper()
+	schema, err := os.ReadFile("../db/db_create.sqlite3.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbFile := t.TempDir() + "/test.db"
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range append([]string{
+		string(schema),
+		"insert into coin(id,symbol,label,logo) values(1,'btc','Bitcoin','')",
+		"insert into coin(id,symbol,label,logo) values(2,'ltc','Litecoin','')",
+		"insert into account(id,label,name) values(1,'shop','Shop')",
+		"insert into accept(accnt,coin) values(1,1)",
+	}, data...) {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg = &lib.Config{
+		Service: &lib.ServiceConfig{},
+		Model: &lib.ModelConfig{
+			DbEngine:    "sqlite3",
+			DbConnect:   dbFile,
+			BalanceWait: []float64{300, 2, 3600},
+			TxTTL:       900,
+		},
+	}
+	if mdl, err = lib.Connect(cfg.Model); err != nil {
+		t.Fatal(err)
+	}
+	hdlr, err := lib.NewHandler(&lib.CoinConfig{
+		Symb:       "btc",
+		Path:       "m",
+		Mode:       "P2PKH",
+		Pk:         testXpub,
+		Blockchain: "mempool.space",
+	}, wallet.NetwMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.HdlrList["btc"] = hdlr
+	t.Cleanup(func() {
+		delete(lib.HdlrList, "btc")
+		mdl.Close()
+		mdl, cfg = nil, nil
+	})
+}
+
+// perform a request on a handler and decode the JSON response
+func testRequest(t *testing.T, hdlr func(w http.ResponseWriter, r *http.Request), url string, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	hdlr(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status %d", url, rec.Code)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %s", url, err.Error())
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	setupService(t)
+
+	var list []*lib.CoinInfo
+	testRequest(t, listHandler(0), "/list/?a=shop", &list)
+	if len(list) != 1 || list[0].Symbol != "btc" || list[0].Label != "Bitcoin" {
+		t.Fatalf("unexpected coin list %v", list)
+	}
+	// unknown or missing account
+	for _, url := range []string{"/list/?a=none", "/list/"} {
+		testRequest(t, listHandler(0), url, &list)
+		if len(list) != 0 {
+			t.Fatalf("%s: coins listed", url)
+		}
+	}
+}
+
+func TestReceiveStatus(t *testing.T) {
+	setupService(t)
+
+	// new transaction for account and coin
+	recv := new(txResponse)
+	testRequest(t, receiveHandler, "/receive/?a=shop&c=btc", recv)
+	if len(recv.Error) > 0 {
+		t.Fatal(recv.Error)
+	}
+	tx := recv.Tx
+	if tx == nil || len(tx.ID) == 0 || !strings.HasPrefix(tx.Addr, "1") || tx.Coin != "btc" {
+		t.Fatalf("unexpected transaction %v", tx)
+	}
+	if recv.Coin == nil || recv.Coin.Symbol != "btc" {
+		t.Fatalf("unexpected coin %v", recv.Coin)
+	}
+	if !strings.HasPrefix(recv.Qr, "data:image/png;base64,") {
+		t.Fatalf("unexpected QR code '%.32s...'", recv.Qr)
+	}
+	// status of the transaction
+	stat := new(txResponse)
+	testRequest(t, statusHandler, "/status/?t="+tx.ID, stat)
+	if len(stat.Error) > 0 {
+		t.Fatal(stat.Error)
+	}
+	if stat.Tx == nil || stat.Tx.ID != tx.ID || stat.Tx.Addr != tx.Addr || stat.Tx.Coin != "btc" {
+		t.Fatalf("unexpected transaction %v", stat.Tx)
+	}
+	if stat.Coin == nil || stat.Coin.Symbol != "btc" || stat.Qr != recv.Qr {
+		t.Fatal("unexpected coin or QR code in status")
+	}
+	// failing requests report errors
+	for _, tc := range []struct {
+		hdlr func(w http.ResponseWriter, r *http.Request)
+		url  string
+	}{
+		{receiveHandler, "/receive/?a=shop&c=ltc"}, // coin without handler
+		{receiveHandler, "/receive/?a=none&c=btc"}, // unknown account
+		{statusHandler, "/status/?t=unknown"},      // unknown transaction
+	} {
+		resp := new(txResponse)
+		testRequest(t, tc.hdlr, tc.url, resp)
+		if len(resp.Error) == 0 {
+			t.Errorf("%s: no error", tc.url)
+		}
+	}
+}
+
+func TestBalanceHandler(t *testing.T) {
+	// same (EIP-55) address for two coins (and twice for ETC)
+	setupService(t,
+		"insert into coin(id,symbol,label,logo) values(3,'eth','Ethereum','')",
+		"insert into coin(id,symbol,label,logo) values(4,'etc','Ethereum Classic','')",
+		"insert into addr(id,coin,accnt,idx,val) values(1,3,1,0,'0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed')",
+		"insert into addr(id,coin,accnt,idx,val) values(2,4,1,0,'0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed')",
+		"insert into addr(id,coin,accnt,idx,val) values(3,4,1,1,'0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed')",
+	)
+	set := func(params url.Values) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/admin/balance", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		balanceHandler(rec, req)
+		resp := new(balanceResponse)
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Error
+	}
+	balance := func(id int64) float64 {
+		t.Helper()
+		list, err := mdl.GetAddresses(id, 0, 0, true)
+		if err != nil || len(list) != 1 {
+			t.Fatalf("address #%d: %v", id, err)
+		}
+		return list[0].Balance
+	}
+	// address (case-insensitive) and coin
+	if msg := set(url.Values{"addr": {"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}, "coin": {"eth"}, "balance": {"1.5"}}); len(msg) > 0 {
+		t.Fatal(msg)
+	}
+	if balance(1) != 1.5 || balance(2) != 0 {
+		t.Fatalf("balances %f/%f, want 1.5/0", balance(1), balance(2))
+	}
+	// address entry id
+	if msg := set(url.Values{"id": {"2"}, "balance": {"2"}}); len(msg) > 0 {
+		t.Fatal(msg)
+	}
+	if balance(1) != 1.5 || balance(2) != 2 || balance(3) != 0 {
+		t.Fatalf("balances %f/%f/%f, want 1.5/2/0", balance(1), balance(2), balance(3))
+	}
+	// failing requests
+	for _, params := range []url.Values{
+		{"addr": {"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}, "balance": {"1"}},                  // missing coin
+		{"addr": {"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}, "coin": {"btc"}, "balance": {"1"}}, // wrong coin
+		{"addr": {"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}, "coin": {"etc"}, "balance": {"1"}}, // ambiguous
+		{"id": {"9"}, "balance": {"1"}},  // unknown entry
+		{"id": {"1"}, "balance": {"-1"}}, // invalid balance
+		{"id": {"1"}, "balance": {"x"}},  // invalid balance
+	} {
+		if msg := set(params); len(msg) == 0 {
+			t.Errorf("%v: no error", params)
+		}
+	}
+}
+
+func TestQRArchive(t *testing.T) {
+	setupService(t)
+
+	// create transactions
+	var txids []string
+	for i := 0; i < 3; i++ {
+		resp := new(txResponse)
+		testRequest(t, receiveHandler, "/receive/?a=shop&c=btc", resp)
+		if len(resp.Error) > 0 {
+			t.Fatal(resp.Error)
+		}
+		txids = append(txids, resp.Tx.ID)
+	}
+	// get QR codes as archive
+	rec := httptest.NewRecorder()
+	qrHandler("png")(rec, httptest.NewRequest("GET", "/qr/?tx="+strings.Join(txids, "&tx="), nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d (%s)", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(txids) {
+		t.Fatalf("%d images in archive, want %d", len(zr.File), len(txids))
+	}
+	for i, f := range zr.File {
+		if f.Name != txids[i]+".png" {
+			t.Errorf("entry '%s', want '%s.png'", f.Name, txids[i])
+		}
+		rdr, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := io.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(img, []byte("\x89PNG")) {
+			t.Errorf("entry '%s' is no PNG image", f.Name)
+		}
+	}
+	// unknown transaction in batch
+	rec = httptest.NewRecorder()
+	qrHandler("png")(rec, httptest.NewRequest("GET", "/qr/?tx="+txids[0]+"&tx=unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d for unknown transaction", rec.Code)
+	}
+}
+
+func TestWriteQRArchive(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := writeQRArchive(buf, []string{"a", "b"}, []string{"bitcoin:addr1", "bitcoin:addr2"}, "jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "a.jpeg" || zr.File[1].Name != "b.jpeg" {
+		t.Fatalf("unexpected archive entries")
+	}
+	if zr.File[0].Method != zip.Store {
+		t.Errorf("image is compressed in archive")
+	}
+}
+
+func TestIncomingHandler(t *testing.T) {
+	setupService(t)
+
+	// no incoming funds: empty list
+	rec := httptest.NewRecorder()
+	incomingHandler(rec, httptest.NewRequest("GET", "/incoming/", nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("status %d: '%s'", rec.Code, rec.Body.String())
+	}
+	// list of recent funds (limited)
+	resp := new(txResponse)
+	testRequest(t, receiveHandler, "/receive/?a=shop&c=btc", resp)
+	list, err := mdl.GetAddressByValue(resp.Tx.Addr)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("address not found: %v", err)
+	}
+	for _, amount := range []float64{0.1, 0.2, 0.3} {
+		if err = mdl.Incoming(list[0].ID, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var funds []*lib.Incoming
+	testRequest(t, incomingHandler, "/incoming/?n=2", &funds)
+	if len(funds) != 2 || funds[0].Account != "Shop" || funds[0].Symbol != "btc" {
+		t.Fatalf("unexpected list of funds (%d entries)", len(funds))
+	}
+}
@@ -0,0 +1,70 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"relay/lib"
+	"time"
+)
+
+// Snapshot of the dashboard data (for external monitoring)
+type Snapshot struct {
+	Time     int64              `json:"time"`     // time of snapshot
+	Fiat     string             `json:"fiat"`     // fiat currency
+	Total    float64            `json:"total"`    // total value of all coins
+	Coins    []*lib.AccCoinInfo `json:"coins"`    // list of active coins
+	Accounts []*lib.AccntInfo   `json:"accounts"` // list of active accounts
+	Incoming []*lib.Incoming    `json:"incoming"` // recently incoming funds
+}
+
+// writeSnapshot collects the dashboard data and writes it as JSON to a
+// file. The file is replaced atomically, so readers never see a partial
+// snapshot.
+func writeSnapshot(fname string) (err error) {
+	snap := &Snapshot{
+		Time: time.Now().Unix(),
+		Fiat: cfg.Handler.Market.Fiat,
+	}
+	if snap.Coins, err = mdl.GetAccumulatedCoin(0); err != nil {
+		return
+	}
+	for _, coin := range snap.Coins {
+		snap.Total += coin.Total * coin.Rate
+	}
+	if snap.Accounts, err = mdl.GetAccounts(0); err != nil {
+		return
+	}
+	if snap.Incoming, err = mdl.ListIncoming(25); err != nil {
+		return
+	}
+	// write snapshot to temporary file and rename it
+	var data []byte
+	if data, err = json.Marshal(snap); err != nil {
+		return
+	}
+	tmp := fname + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, fname)
+}
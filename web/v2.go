@@ -0,0 +1,234 @@
+//----------------------------------------------------------------------
+// This file is part of 'bitbank-relay'.
+// Copyright (C) 2021-2024, Bernd Fix >Y<
+//
+// 'bitbank-relay' is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// 'bitbank-relay' is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"relay/lib"
+	"strings"
+)
+
+//----------------------------------------------------------------------
+// API version 2: all calls are available under the path prefix "/v2";
+// responses use consistent field names. Legacy paths keep the old field
+// names for existing clients.
+//----------------------------------------------------------------------
+
+// marshal a response for the API version of the request
+func marshal(r *http.Request, v any) ([]byte, error) {
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		v = toV2(v)
+	}
+	return json.Marshal(v)
+}
+
+// convert response to version 2 (if it contains renamed fields)
+func toV2(v any) any {
+	switch x := v.(type) {
+	case []*lib.CoinInfo:
+		list := make([]*coinV2, len(x))
+		for i, ci := range x {
+			list[i] = newCoinV2(ci)
+		}
+		return list
+	case *txResponse:
+		return &txResponseV2{
+			Error:       x.Error,
+			Tx:          newTxV2(x.Tx),
+			Qr:          x.Qr,
+			Coin:        newCoinV2(x.Coin),
+			Received:    x.Received,
+			Unconfirmed: x.Unconfirmed,
+		}
+	case *transactionsResponse:
+		resp := &transactionsResponseV2{
+			Error:  x.Error,
+			Total:  x.Total,
+			Offset: x.Offset,
+			Txs:    make([]*txV2, len(x.Txs)),
+		}
+		for i, tx := range x.Txs {
+			resp.Txs[i] = newTxV2(tx)
+		}
+		return resp
+	case *txDetailResponse:
+		resp := &txDetailResponseV2{Error: x.Error}
+		if x.Detail != nil {
+			resp.Detail = &txDetailV2{
+				TxDetail: x.Detail,
+				Tx:       newTxV2(x.Detail.Tx),
+			}
+		}
+		return resp
+	case *addressesResponse:
+		resp := &addressesResponseV2{
+			Error: x.Error,
+			Addrs: make([]*addrV2, len(x.Addrs)),
+		}
+		for i, ai := range x.Addrs {
+			resp.Addrs[i] = newAddrV2(ai)
+		}
+		return resp
+	}
+	return v
+}
+
+// coinV2 is the coin information (lib.CoinInfo)
+type coinV2 struct {
+	ID        int64   `json:"id"`                  // repository ID of coin
+	Symbol    string  `json:"symbol"`              // ticker symbol of coin
+	Name      string  `json:"name"`                // full coin name
+	Logo      string  `json:"logo"`                // SVG-encoded coin logo
+	Rate      float64 `json:"rate"`                // price of coin in fiat currency
+	Estimated bool    `json:"estimated"`           // rate is estimated (via BTC)
+	Display   string  `json:"display,omitempty"`   // short display name
+	Color     string  `json:"color,omitempty"`     // accent color
+	Status    string  `json:"status,omitempty"`    // "enabled" or "paused"
+	MinAmount float64 `json:"minAmount,omitempty"` // min. accepted amount
+	Fee       float64 `json:"fee,omitempty"`       // typical network fee
+}
+
+func newCoinV2(ci *lib.CoinInfo) *coinV2 {
+	if ci == nil {
+		return nil
+	}
+	return &coinV2{
+		ID:        ci.ID,
+		Symbol:    ci.Symbol,
+		Name:      ci.Label,
+		Logo:      ci.Logo,
+		Rate:      ci.Rate,
+		Estimated: ci.Est,
+		Display:   ci.Display,
+		Color:     ci.Color,
+		Status:    ci.Status,
+		MinAmount: ci.MinAmount,
+		Fee:       ci.Fee,
+	}
+}
+
+// txV2 is a transaction (lib.Transaction)
+type txV2 struct {
+	TxID      string `json:"txid"`             // transaction identifier
+	Address   string `json:"address"`          // receiving address
+	Account   string `json:"accountName"`      // name of account
+	Coin      string `json:"coin"`             // coin
+	Status    int    `json:"status"`           // status (0=pending, 1=expired)
+	ValidFrom int64  `json:"validFrom"`        // start of life-span
+	ValidTo   int64  `json:"validTo"`          // end of life-span
+	Late      bool   `json:"late"`             // funds received in grace period
+	Memo      int64  `json:"memo,omitempty"`   // memo/destination tag
+	Refund    string `json:"refund,omitempty"` // refund address of customer
+}
+
+func newTxV2(tx *lib.Transaction) *txV2 {
+	if tx == nil {
+		return nil
+	}
+	return &txV2{
+		TxID:      tx.ID,
+		Address:   tx.Addr,
+		Account:   tx.Accnt,
+		Coin:      tx.Coin,
+		Status:    tx.Status,
+		ValidFrom: tx.ValidFrom,
+		ValidTo:   tx.ValidTo,
+		Late:      tx.Late,
+		Memo:      tx.Memo,
+		Refund:    tx.Refund,
+	}
+}
+
+// addrV2 is an address (lib.AddrInfo)
+type addrV2 struct {
+	ID           int64   `json:"id"`           // repository ID of address
+	Status       int     `json:"status"`       // status (0=open, 1=closed, 2=removed)
+	CoinName     string  `json:"coinName"`     // full coin name
+	CoinSymbol   string  `json:"coinSymbol"`   // coin symbol
+	AccountName  string  `json:"accountName"`  // name of account
+	AccountLabel string  `json:"accountLabel"` // account label
+	Address      string  `json:"address"`      // address string
+	Balance      float64 `json:"balance"`      // address balance
+	Rate         float64 `json:"rate"`         // price per coin
+	RefCount     int     `json:"refCount"`     // number of transactions
+	LastCheck    string  `json:"lastCheck"`    // last balance check
+	NextCheck    string  `json:"nextCheck"`    // next balance check
+	WaitCheck    int     `json:"waitCheck"`    // wait time between checks (seconds)
+	LastTx       string  `json:"lastTx"`       // last used in a transaction
+	ValidSince   string  `json:"validSince"`   // start of active period
+	ValidUntil   string  `json:"validUntil"`   // end of active period
+	Explorer     string  `json:"explorer"`     // URL of address in blockchain explorer
+}
+
+func newAddrV2(ai *lib.AddrInfo) *addrV2 {
+	return &addrV2{
+		ID:           ai.ID,
+		Status:       ai.Status,
+		CoinName:     ai.CoinName,
+		CoinSymbol:   ai.CoinSymb,
+		AccountName:  ai.Account,
+		AccountLabel: ai.AccntLabel,
+		Address:      ai.Val,
+		Balance:      ai.Balance,
+		Rate:         ai.Rate,
+		RefCount:     ai.RefCount,
+		LastCheck:    ai.LastCheck,
+		NextCheck:    ai.NextCheck,
+		WaitCheck:    ai.WaitCheck,
+		LastTx:       ai.LastTx,
+		ValidSince:   ai.ValidSince,
+		ValidUntil:   ai.ValidUntil,
+		Explorer:     ai.Explorer,
+	}
+}
+
+// txDetailV2 is the full information about a transaction (lib.TxDetail)
+type txDetailV2 struct {
+	*lib.TxDetail
+	Tx *txV2 `json:"tx"` // transaction
+}
+
+// response types (version 2)
+type (
+	txDetailResponseV2 struct {
+		Error  string      `json:"error,omitempty"`
+		Detail *txDetailV2 `json:"detail"`
+	}
+	txResponseV2 struct {
+		Error       string   `json:"error,omitempty"`
+		Tx          *txV2    `json:"tx"`
+		Qr          string   `json:"qr"`
+		Coin        *coinV2  `json:"coin"`
+		Received    *float64 `json:"received,omitempty"`
+		Unconfirmed *float64 `json:"unconfirmed,omitempty"`
+	}
+	transactionsResponseV2 struct {
+		Error  string  `json:"error,omitempty"`
+		Total  int     `json:"total"`
+		Offset int     `json:"offset"`
+		Txs    []*txV2 `json:"txs"`
+	}
+	addressesResponseV2 struct {
+		Error string    `json:"error,omitempty"`
+		Addrs []*addrV2 `json:"addresses"`
+	}
+)